@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+// -attribution-files refines the name-based guess of attributeBucket with
+// a sample of each bucket's files: the domains in their paths, and the
+// company recorded in the metadata of a few pdf and office documents.
+// Every signal is reduced to an organization token the way bucket names
+// are, and the token with the most evidence wins.
+
+const (
+	// attributionDocs is how many documents of a bucket are downloaded
+	// for their metadata, and attributionDocSize the largest one.
+	attributionDocs    = 3
+	attributionDocSize = 5 << 20
+
+	// weights of one piece of evidence; domains count at most three
+	// times per organization
+	attributionDomainWeight = 0.3
+	attributionDomainHits   = 3
+	attributionDocWeight    = 0.6
+)
+
+// attributionEvidence collects the weights seen for each organization.
+type attributionEvidence map[string][]float64
+
+func (e attributionEvidence) add(org string, weight float64) {
+	if org != "" {
+		e[org] = append(e[org], weight)
+	}
+}
+
+// best returns the organization whose evidence combines to the highest
+// confidence, 1 - the product of 1 - each weight, rounded to 2 places.
+func (e attributionEvidence) best() (string, float64) {
+	orgs := make([]string, 0, len(e))
+	for org := range e {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	best, score := "", 0.0
+	for _, org := range orgs {
+		miss := 1.0
+		for _, w := range e[org] {
+			miss *= 1 - w
+		}
+		if s := 1 - miss; s > score {
+			best, score = org, s
+		}
+	}
+	return best, math.Round(math.Min(score, 0.99)*100) / 100
+}
+
+// corporateTokens are the legal form and filler words of company names.
+var corporateTokens = map[string]bool{
+	"the": true, "inc": true, "corp": true, "corporation": true, "ltd": true, "llc": true,
+	"gmbh": true, "company": true, "group": true, "limited": true, "holdings": true,
+}
+
+// orgToken reduces a company name or domain label to its first
+// distinctive token, e.g. "The Acme Corporation" -> acme.
+func orgToken(s string) string {
+	for _, t := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	}) {
+		if len(t) >= 3 && !genericTokens[t] && !corporateTokens[t] {
+			return t
+		}
+	}
+	return ""
+}
+
+// pathDomain matches a hostname that is a directory of a file path, like
+// www.acme.com/ or exports/acme.co.uk/, capturing the label before the
+// public suffix.
+var pathDomain = regexp.MustCompile(`([a-z0-9-]+)\.(?:com|net|org|io|co|biz|info|gov|edu|de|uk|fr|cn|jp|ru|br|in|it|es|nl|au|ca|ch|se)(?:\.[a-z]{2})?[/:]`)
+
+// thirdPartyDomains are the sites paths often mention that aren't the
+// owner's.
+var thirdPartyDomains = map[string]bool{
+	"amazonaws": true, "cloudfront": true, "google": true, "googleapis": true, "gstatic": true,
+	"github": true, "microsoft": true, "apple": true, "adobe": true, "facebook": true,
+	"example": true, "jquery": true, "wordpress": true, "gravatar": true, "twitter": true,
+}
+
+// pathOrgs returns the organizations of the domains in a file path.
+func pathOrgs(name string) []string {
+	var orgs []string
+	for _, m := range pathDomain.FindAllStringSubmatch(strings.ToLower(name), -1) {
+		if org := orgToken(m[1]); org != "" && !thirdPartyDomains[org] {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs
+}
+
+// metadataExts are the documents whose metadata can name a company.
+var metadataExts = map[string]bool{"pdf": true, "docx": true, "xlsx": true, "pptx": true}
+
+// documentCompany downloads a document and returns the company its
+// metadata records, or "" when it has none or can't be read. Like the
+// region probe it sends no api key.
+func documentCompany(client *http.Client, fileURL, ext string) string {
+	req, err := http.NewRequestWithContext(runCtx, "GET", fileURL, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, attributionDocSize+1))
+	if err != nil || len(data) > attributionDocSize {
+		return ""
+	}
+	if ext == "pdf" {
+		return pdfCompany(data)
+	}
+	return officeCompany(data)
+}
+
+// pdfInfoCompany matches the Company entry of a pdf document info
+// dictionary, which office suites fill in when exporting, and pdfEscape
+// the escapes of its string.
+var (
+	pdfInfoCompany = regexp.MustCompile(`/Company\s*\(((?:\\.|[^\\)])*)\)`)
+	pdfEscape      = regexp.MustCompile(`\\(.)`)
+)
+
+func pdfCompany(data []byte) string {
+	m := pdfInfoCompany.FindSubmatch(data)
+	if m == nil {
+		return ""
+	}
+	s := pdfEscape.ReplaceAll(m[1], []byte("$1"))
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		// a utf-16 text string
+		u := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			u = append(u, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return strings.TrimSpace(string(utf16.Decode(u)))
+	}
+	return strings.TrimSpace(string(s))
+}
+
+// officeCompany reads the Company of docProps/app.xml in a docx, xlsx or
+// pptx.
+func officeCompany(data []byte) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+	for _, f := range zr.File {
+		if f.Name != "docProps/app.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return ""
+		}
+		defer rc.Close()
+		var props struct {
+			Company string `xml:"Company"`
+		}
+		if err := xml.NewDecoder(io.LimitReader(rc, 1<<20)).Decode(&props); err != nil {
+			return ""
+		}
+		return strings.TrimSpace(props.Company)
+	}
+	return ""
+}
+
+// attributeFromFiles lists n files of each bucket, one api request each
+// taken from the run budget, and sets Attribution from the bucket name
+// guess already there together with the domains in the file paths and
+// the company of up to attributionDocs documents.
+func attributeFromFiles(api *ghw.Client, web *http.Client, buckets []Bucket, n int) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 4)
+	for i := range buckets {
+		if budget.spend() != "" {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(b *Bucket) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := api.SearchFiles(runCtx, ghw.FilesQuery{Bucket: fmt.Sprint(b.ID), Limit: n})
+			if err != nil {
+				// the name guess stands
+				return
+			}
+			ev := attributionEvidence{}
+			ev.add(b.Attribution, b.AttributionScore)
+			hits := map[string]int{}
+			docs := 0
+			for _, f := range resp.Files {
+				seen := map[string]bool{}
+				for _, org := range pathOrgs(f.Name) {
+					if !seen[org] && hits[org] < attributionDomainHits {
+						seen[org] = true
+						hits[org]++
+						ev.add(org, attributionDomainWeight)
+					}
+				}
+				if docs < attributionDocs && metadataExts[fileExt(f.Name)] && f.Size > 0 && f.Size <= attributionDocSize {
+					docs++
+					ev.add(orgToken(documentCompany(web, f.URL, fileExt(f.Name))), attributionDocWeight)
+				}
+			}
+			b.Attribution, b.AttributionScore = ev.best()
+		}(&buckets[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+	"github.com/dogadmin/bucketsearch/ghw/ghwtest"
+)
+
+func TestPathOrgs(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"www.globex.com/index.html", []string{"globex"}},
+		{"exports/globex.co.uk/2023/report.pdf", []string{"globex"}},
+		{"cdn/ajax.googleapis.com/jquery.min.js", nil},
+		{"config.in", nil},
+		{"backup/db.sql", nil},
+	}
+	for _, tt := range tests {
+		if got := pathOrgs(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pathOrgs(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrgToken(t *testing.T) {
+	for in, want := range map[string]string{
+		"The Globex Corporation": "globex",
+		"ACME Inc.":              "acme",
+		"prod-backup":            "",
+	} {
+		if got := orgToken(in); got != want {
+			t.Errorf("orgToken(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPDFCompany(t *testing.T) {
+	plain := []byte("1 0 obj\n<< /Author (Jane) /Company (Globex \\(EU\\) Ltd) >>\nendobj")
+	if got := pdfCompany(plain); got != "Globex (EU) Ltd" {
+		t.Errorf("got %q", got)
+	}
+	utf16 := []byte("<< /Company (\xfe\xff\x00G\x00l\x00o) >>")
+	if got := pdfCompany(utf16); got != "Glo" {
+		t.Errorf("got %q from a utf-16 string", got)
+	}
+	if got := pdfCompany([]byte("%PDF-1.7 no info")); got != "" {
+		t.Errorf("got %q without an info dictionary", got)
+	}
+}
+
+// testOffice is a docx holding only the app properties.
+func testOffice(t *testing.T, company string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("docProps/app.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties"><Application>Microsoft Office Word</Application><Company>`+company+`</Company></Properties>`)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestOfficeCompany(t *testing.T) {
+	if got := officeCompany(testOffice(t, "Globex")); got != "Globex" {
+		t.Errorf("got %q", got)
+	}
+	if got := officeCompany([]byte("not a zip")); got != "" {
+		t.Errorf("got %q from garbage", got)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestAttributeFromFiles(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	const host = "acme-prod.s3.amazonaws.com"
+	s.Files = []ghw.File{
+		{Bucket: host, Name: "www.globex.com/index.html", URL: "https://" + host + "/www.globex.com/index.html", Size: 10},
+		{Bucket: host, Name: "reports/q1.pdf", URL: "https://" + host + "/reports/q1.pdf", Size: 100},
+		{Bucket: host, Name: "reports/q2.docx", URL: "https://" + host + "/reports/q2.docx", Size: 100},
+	}
+	docs := map[string][]byte{
+		"/reports/q1.pdf":  []byte("<< /Company (Globex Corporation) >>"),
+		"/reports/q2.docx": testOffice(t, "Globex"),
+	}
+	var fetched []string
+	web := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		fetched = append(fetched, r.URL.Path)
+		if r.Header.Get("X-Api-Key") != "" || r.Header.Get("Authorization") != "" {
+			t.Errorf("sent the api key to %s", r.URL)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(docs[r.URL.Path]))}, nil
+	})}
+
+	buckets := []Bucket{
+		{Bucket: ghw.Bucket{ID: host, Name: "acme-prod"}},
+		{Bucket: ghw.Bucket{ID: "nothing-here", Name: "initech-logs"}},
+	}
+	for i := range buckets {
+		buckets[i].Attribution, buckets[i].AttributionScore = attributeBucket(buckets[i].Name)
+	}
+	attributeFromFiles(s.Client(), web, buckets, 50)
+
+	// two documents and a domain outweigh the name
+	if buckets[0].Attribution != "globex" || buckets[0].AttributionScore != 0.89 {
+		t.Errorf("got %s %.2f, want globex 0.89", buckets[0].Attribution, buckets[0].AttributionScore)
+	}
+	// no files, the name guess stands
+	if buckets[1].Attribution != "initech" || buckets[1].AttributionScore != 0.8 {
+		t.Errorf("got %s %.2f, want initech 0.8", buckets[1].Attribution, buckets[1].AttributionScore)
+	}
+	if strings.Join(fetched, ",") != "/reports/q1.pdf,/reports/q2.docx" {
+		t.Errorf("downloaded %v", fetched)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func runBackfill(args []string) {
+	fs := newFlagSet("backfill", "Walk every result of a search into -dir, one csv per -slice of lastModified, checkpointing after each page so it can run for days and pick up where it stopped.")
+	common := addAPIFlags(fs)
+	filters := addFileFilterFlags(fs)
+	keywords := fs.String("keywords", "", "Search keywords")
+	limit := fs.Int("limit", 1000, "Page size, at most 1000")
+	dir := fs.String("dir", "backfill", "Directory for the slice csvs and backfill.state")
+	slice := fs.String("slice", "month", "Group files by lastModified: day|month|year")
+	pause := fs.Duration("pause", time.Second, "Wait between two pages, to stay well inside the quota")
+	errorWait := fs.Duration("error-wait", 5*time.Minute, "Wait this long after a request failed for good before trying the page again")
+	addMaxRuntimeFlag(fs)
+	restart := fs.Bool("restart", false, "Discard the state in -dir and start over")
+	addNotifyFlag(fs)
+	addQuietFlag(fs)
+	parseFlags(fs, args)
+
+	layout, ok := map[string]string{"day": "2006-01-02", "month": "2006-01", "year": "2006"}[*slice]
+	if !ok {
+		log.Fatalf("unknown slice %s\n", *slice)
+	}
+	opts := filesOptions{keywords: *keywords, limit: *limit}
+	filters.apply(&opts)
+	if opts.bucketList != nil {
+		log.Fatalln("backfill takes a single -bucket, not -")
+	}
+	api := common.client("backfill")
+	defer telemetry.flush()
+	handleBackfill(api, opts, backfillOptions{
+		dir:       *dir,
+		slice:     *slice,
+		layout:    layout,
+		pause:     *pause,
+		errorWait: *errorWait,
+		restart:   *restart,
+		filters:   filters.clientSide(),
+	})
+}
+
+type backfillOptions struct {
+	dir       string
+	slice     string
+	layout    string
+	pause     time.Duration
+	errorWait time.Duration
+	restart   bool
+	// filters are the client-side filters, which the state must match
+	// like the query
+	filters []string
+}
+
+// backfillState is saved after every page. Positions holds the size of
+// each slice csv at that point, so a run that died mid page truncates the
+// half written rows before continuing.
+type backfillState struct {
+	Query     ghw.FilesQuery   `json:"query"`
+	Slice     string           `json:"slice"`
+	PageSize  int              `json:"pageSize"`
+	Offset    int              `json:"offset"`
+	Total     int              `json:"total"`
+	Files     int              `json:"files"`
+	PerBucket map[string]int   `json:"perBucket"`
+	Positions map[string]int64 `json:"positions"`
+	Started   time.Time        `json:"started"`
+	Updated   time.Time        `json:"updated"`
+	Done      bool             `json:"done"`
+	// Filters and Header are the client-side filters and the csv
+	// columns; empty in states of older versions
+	Filters []string `json:"filters,omitempty"`
+	Header  []string `json:"header,omitempty"`
+}
+
+func handleBackfill(api *ghw.Client, opts filesOptions, bopts backfillOptions) {
+	pageSize := opts.limit
+	if pageSize <= 0 || pageSize > 1000 {
+		pageSize = 1000
+	}
+	if _, ok := severityRank[opts.minSeverity]; opts.minSeverity != "" && !ok {
+		log.Fatalf("unknown severity %s\n", opts.minSeverity)
+	}
+	if err := os.MkdirAll(bopts.dir, 0755); err != nil {
+		log.Fatalf("create dir: %v", err)
+	}
+	statePath := filepath.Join(bopts.dir, "backfill.state")
+	state := backfillState{
+		Query:     filesQuery(opts),
+		Slice:     bopts.slice,
+		PageSize:  pageSize,
+		Total:     -1,
+		PerBucket: map[string]int{},
+		Positions: map[string]int64{},
+		Started:   time.Now(),
+		Filters:   bopts.filters,
+		Header:    fileHeader(opts),
+	}
+	split := &splitWriter{dir: bopts.dir, header: state.Header, created: map[string]bool{}}
+
+	if data, err := os.ReadFile(statePath); err == nil {
+		var prev backfillState
+		if err := json.Unmarshal(data, &prev); err != nil && !bopts.restart {
+			log.Fatalf("read state: %v", err)
+		}
+		if bopts.restart {
+			// slices the new run never writes must not survive it
+			for name := range prev.Positions {
+				os.Remove(filepath.Join(bopts.dir, name+".csv"))
+			}
+		} else if prev.Query != state.Query || prev.Slice != state.Slice || prev.PageSize != state.PageSize {
+			log.Fatalf("%s belongs to a different query, -slice or -limit; use another -dir or -restart\n", statePath)
+		} else if prev.Header != nil && (strings.Join(prev.Filters, "\n") != strings.Join(state.Filters, "\n") || strings.Join(prev.Header, ",") != strings.Join(state.Header, ",")) {
+			log.Fatalf("%s was written with other filters or columns (%s); use another -dir or -restart\n", statePath, strings.Join(changedFilters(prev.Filters, state.Filters), ", "))
+		} else if prev.Done {
+			summary(tr("backfill already complete: %d files in %s", prev.Files, bopts.dir))
+			return
+		} else {
+			state = prev
+			for name, pos := range state.Positions {
+				if err := os.Truncate(filepath.Join(bopts.dir, name+".csv"), pos); err != nil {
+					log.Fatalf("restore %s: %v", name, err)
+				}
+				split.created[name] = true
+			}
+			fmt.Fprintln(statusOut, tr("continuing at offset %d of %d", state.Offset, state.Total))
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("read state: %v", err)
+	}
+
+	status = newProgress(1, api)
+	defer catchInterrupt()()
+	stopped := ""
+	for {
+		if stopped = budget.spend(); stopped != "" {
+			break
+		}
+		q := state.Query
+		q.Start, q.Limit = state.Offset, pageSize
+		status.busy(0, state.Offset)
+		resp, err := api.SearchFiles(runCtx, q)
+		status.idle(0)
+		if err != nil {
+			// waiting won't fix a key or a request the api refuses
+			var se *ghw.StatusError
+			if errors.As(err, &se) && !se.Temporary() {
+				requestFailed(err)
+			}
+			if runCtx.Err() != nil || interrupted.Load() {
+				// the budget tells which
+				continue
+			}
+			// the client already retried; a long outage is waited out
+			log.Printf("offset %d: %v, trying again in %s", state.Offset, err, bopts.errorWait)
+			pauseRun(bopts.errorWait)
+			continue
+		}
+
+		groups := map[string][][]string{}
+		for _, f := range resp.Files {
+			file := File{File: f}
+			file.Bucket = normalizeBucket(file.Bucket)
+			if !keepFile(file, opts) {
+				continue
+			}
+			if opts.perBucketMax > 0 {
+				if state.PerBucket[file.Bucket] >= opts.perBucketMax {
+					continue
+				}
+				state.PerBucket[file.Bucket]++
+			}
+			key := "unknown"
+			if file.LastModified > 0 {
+				key = time.Unix(file.LastModified, 0).UTC().Format(bopts.layout)
+			}
+			groups[key] = append(groups[key], fileRecord(file, opts))
+			state.Files++
+		}
+		for key, rows := range groups {
+			if err := split.write(key, rows); err != nil {
+				log.Fatalf("write csv: %v", err)
+			}
+			name, path := split.file(key)
+			fi, err := os.Stat(path)
+			if err != nil {
+				log.Fatalf("write csv: %v", err)
+			}
+			state.Positions[name] = fi.Size()
+		}
+
+		state.Total = resp.Meta.Results
+		state.Offset += pageSize
+		state.Done = len(resp.Files) < pageSize || state.Offset >= state.Total
+		state.Updated = time.Now()
+		if err := saveJSON(statePath, state); err != nil {
+			log.Fatalf("write state: %v", err)
+		}
+		status.add(len(resp.Files), state.Total)
+		if state.Done {
+			break
+		}
+		pauseRun(bopts.pause)
+	}
+	status.stop()
+	suppressions.report()
+	if stopped != "" {
+		summary(tr("%s at offset %d of %d, %d files saved; run again to continue", stopReason(stopped), state.Offset, state.Total, state.Files))
+		notify("bucketsearch backfill", tr("%s, stopped at offset %d", stopReason(stopped), state.Offset))
+		return
+	}
+	done := tr("completed, %d files in %d slices saved to %s", state.Files, len(state.Positions), bopts.dir)
+	summary(done)
+	notify("bucketsearch backfill", done)
+}
+
+// changedFilters lists the filters of now that differ from before, or
+// says it is the columns.
+func changedFilters(before, now []string) []string {
+	was := map[string]bool{}
+	for _, f := range before {
+		was[f] = true
+	}
+	var changed []string
+	for _, f := range now {
+		if !was[f] {
+			changed = append(changed, "-"+f)
+		}
+	}
+	if len(changed) == 0 {
+		changed = append(changed, "columns")
+	}
+	return changed
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw/ghwtest"
+)
+
+// readBackfill returns the rows of every slice csv in dir by slice name,
+// failing on a url written twice.
+func readBackfill(t *testing.T, dir string) map[string]int {
+	t.Helper()
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.csv"))
+	slices := map[string]int{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := indexOf(records[0], "url")
+		for _, r := range records[1:] {
+			if seen[r[url]] {
+				t.Errorf("%s written twice", r[url])
+			}
+			seen[r[url]] = true
+		}
+		slices[filepath.Base(path)] = len(records) - 1
+	}
+	return slices
+}
+
+func readTestState(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBackfillResumes(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+	opts := filesOptions{limit: 10, maxDepth: -1}
+	bopts := backfillOptions{dir: dir, slice: "month", layout: "2006-01"}
+
+	// the first run stops after one page
+	budget = runBudget{maxRequests: 1}
+	defer func() { budget = runBudget{} }()
+	handleBackfill(s.Client(), opts, bopts)
+	var state backfillState
+	if err := readTestState(filepath.Join(dir, "backfill.state"), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Done || state.Offset != 10 || state.Files != 10 {
+		t.Fatalf("state after one page %+v", state)
+	}
+
+	// a row half written when the run died is dropped on resume
+	name := filepath.Join(dir, "2023-01.csv")
+	f, _ := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString("https://half")
+	f.Close()
+
+	budget = runBudget{}
+	handleBackfill(s.Client(), opts, bopts)
+	if err := readTestState(filepath.Join(dir, "backfill.state"), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Done || state.Files != len(s.Files) {
+		t.Errorf("state after resuming %+v", state)
+	}
+	total := 0
+	for _, n := range readBackfill(t, dir) {
+		total += n
+	}
+	if total != len(s.Files) {
+		t.Errorf("wrote %d rows, want %d", total, len(s.Files))
+	}
+	// the fixtures are a week apart from 2023-01-01
+	slices := readBackfill(t, dir)
+	if slices["2023-01.csv"] != 5 || slices["2023-06.csv"] == 0 {
+		t.Errorf("got slices %v", slices)
+	}
+	if requests := len(s.Requests()); requests != 3 {
+		t.Errorf("sent %d requests, want 3 pages", requests)
+	}
+
+	// a complete backfill isn't fetched again
+	handleBackfill(s.Client(), opts, bopts)
+	if requests := len(s.Requests()); requests != 3 {
+		t.Errorf("sent %d requests after completing", requests)
+	}
+}
+
+func TestBackfillMaxRuntime(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+
+	// the pause is cut short at -max-runtime and no page follows it
+	budget = runBudget{deadline: time.Now().Add(50 * time.Millisecond)}
+	defer func() { budget = runBudget{} }()
+	start := time.Now()
+	handleBackfill(s.Client(), filesOptions{limit: 10, maxDepth: -1},
+		backfillOptions{dir: dir, slice: "month", layout: "2006-01", pause: time.Hour})
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s to stop", d)
+	}
+	var state backfillState
+	if err := readTestState(filepath.Join(dir, "backfill.state"), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Done || state.Offset != 10 || len(s.Requests()) != 1 {
+		t.Errorf("state %+v after %d requests, want one page saved", state, len(s.Requests()))
+	}
+}
+
+func TestBackfillRestart(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+	opts := filesOptions{limit: 1000, maxDepth: -1}
+	handleBackfill(s.Client(), opts, backfillOptions{dir: dir, slice: "month", layout: "2006-01"})
+	handleBackfill(s.Client(), opts, backfillOptions{dir: dir, slice: "year", layout: "2006", restart: true})
+	slices := readBackfill(t, dir)
+	if !reflect.DeepEqual(slices, map[string]int{"2023.csv": len(s.Files)}) {
+		t.Errorf("got slices %v after -restart by year", slices)
+	}
+}
+
+func TestChangedFilters(t *testing.T) {
+	got := changedFilters([]string{"min-size=1", "prefix=a"}, []string{"min-size=1", "prefix=b", "max-depth=2"})
+	if !reflect.DeepEqual(got, []string{"-prefix=b", "-max-depth=2"}) {
+		t.Errorf("got %q", got)
+	}
+	if got := changedFilters([]string{"a=1"}, []string{"a=1"}); !reflect.DeepEqual(got, []string{"columns"}) {
+		t.Errorf("got %q for the same filters", got)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cache keeps every file and bucket the files and buckets commands fetch
+// with -cache, before any client-side filter, in a sqlite database with
+// the tables of -format sqlite. query searches it without spending api
+// requests. It is opt-in as it holds third-party exposure data in the
+// clear.
+var cache *resultCache
+
+type resultCache struct {
+	db *sql.DB
+}
+
+func cachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bucketsearch", "cache.db"), nil
+}
+
+func openCacheDB(path string) (*sql.DB, error) {
+	// concurrent runs wait for each other instead of failing
+	// and replaced rows fire the delete triggers keeping search-local's
+	// index in step
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=10000&_journal_mode=WAL&_recursive_triggers=1")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err == nil {
+		err = addLaterColumns(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openCache sets up the cache of this run. Failing to, like failing to
+// write to it later, is reported and never fails the run.
+func openCache() {
+	path, err := cachePath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+	}
+	var db *sql.DB
+	if err == nil {
+		db, err = openCacheDB(path)
+	}
+	if err != nil {
+		log.Printf("cache disabled: %v", err)
+		return
+	}
+	cache = &resultCache{db: db}
+}
+
+func (c *resultCache) addFiles(files []File) {
+	if c == nil || len(files) == 0 {
+		return
+	}
+	c.check(insertFiles(c.db, files))
+}
+
+func (c *resultCache) addBuckets(buckets []Bucket) {
+	if c == nil || len(buckets) == 0 {
+		return
+	}
+	c.check(insertBuckets(c.db, buckets))
+}
+
+func (c *resultCache) check(err error) {
+	if err != nil {
+		log.Printf("cache disabled: %v", err)
+		c.db.Close()
+		cache = nil
+	}
+}
+
+func runQuery(args []string) {
+	fs := newFlagSet("query", "Search the files, or buckets with -buckets, that earlier files and buckets runs cached, without using the api.")
+	keywords := fs.String("keywords", "", "Words the url (bucket name with -buckets) must all contain")
+	buckets := fs.Bool("buckets", false, "Query the cached buckets instead of files")
+	filters := addFileFilterFlags(fs)
+	output := fs.String("o", "", "Output file path. If empty, print to stdout")
+	format := fs.String("format", "", "Output format: json|csv|ndjson (default json on stdout, csv with -o)")
+	limit := fs.Int("limit", 0, "Output at most N results, 0 means all")
+	parseFlags(fs, args)
+
+	db := openQueryCache()
+	defer db.Close()
+	w := newQueryWriter(*output, *format)
+
+	var n int
+	var err error
+	if *buckets {
+		n, err = queryBuckets(db, *keywords, *limit, w)
+	} else {
+		opts := filesOptions{}
+		filters.apply(&opts)
+		where, args := likeTerms("url", *keywords)
+		n, err = queryFiles(db, where, args, opts, *limit, w)
+	}
+	if err != nil {
+		log.Fatalf("query cache: %v", err)
+	}
+	w.done(n)
+}
+
+// openQueryCache opens the cache for query and search-local.
+func openQueryCache() *sql.DB {
+	path, err := cachePath()
+	if err != nil {
+		log.Fatalf("cache: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		log.Fatalf("no cache at %s yet, run files or buckets with -cache first\n", path)
+	}
+	db, err := openCacheDB(path)
+	if err != nil {
+		log.Fatalf("open cache: %v", err)
+	}
+	return db
+}
+
+// likeTerms turns the -keywords terms into LIKE conditions on column.
+func likeTerms(column, keywords string) (where []string, args []any) {
+	for _, term := range keywordTerms(keywords) {
+		where = append(where, "lower("+column+") LIKE ? ESCAPE '\\'")
+		term = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(term))
+		args = append(args, "%"+term+"%")
+	}
+	return where, args
+}
+
+// queryFiles writes the cached files matching the where conditions and
+// the file filters; -ext, -noext and -bucket, which the api applies on a
+// files run, go into the sql.
+func queryFiles(db *sql.DB, where []string, args []any, opts filesOptions, limit int, w *queryWriter) (int, error) {
+	buckets := opts.bucketList
+	if opts.bucket != "" {
+		buckets = []string{opts.bucket}
+	}
+	if len(buckets) > 0 {
+		marks := strings.TrimSuffix(strings.Repeat("?, ", len(buckets)), ", ")
+		where = append(where, "(bucket IN ("+marks+") OR bucket_id IN ("+marks+"))")
+		for i := 0; i < 2; i++ {
+			for _, b := range buckets {
+				args = append(args, b)
+			}
+		}
+	}
+	for _, filter := range []struct {
+		list string
+		op   string
+	}{{opts.ext, "IN"}, {opts.noext, "NOT IN"}} {
+		set := splitSet(filter.list)
+		if len(set) == 0 {
+			continue
+		}
+		var marks []string
+		for e := range set {
+			marks = append(marks, "?")
+			args = append(args, strings.TrimPrefix(e, "."))
+		}
+		where = append(where, "ext "+filter.op+" ("+strings.Join(marks, ", ")+")")
+	}
+	query := "SELECT url, id, bucket, bucket_id, name, size, type, last_modified FROM files"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := db.Query(query+" ORDER BY bucket, name", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if w.format == "csv" {
+		w.csvHeader(fileHeader(opts))
+	}
+	n := 0
+	perBucket := map[string]int{}
+	for rows.Next() && (limit <= 0 || n < limit) {
+		var f File
+		var id, bucketID, modified string
+		if err := rows.Scan(&f.URL, &id, &f.Bucket, &bucketID, &f.Name, &f.Size, &f.Type, &modified); err != nil {
+			return n, err
+		}
+		f.ID, f.BucketID = id, bucketID
+		if ts, err := time.Parse(time.RFC3339, modified); err == nil {
+			f.LastModified = ts.Unix()
+		}
+		if !keepFile(f, opts) {
+			continue
+		}
+		if opts.perBucketMax > 0 {
+			if perBucket[f.Bucket] >= opts.perBucketMax {
+				continue
+			}
+			perBucket[f.Bucket]++
+		}
+		if w.format == "csv" {
+			w.csv.Write(fileRecord(f, opts))
+		} else {
+			w.write(f)
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func queryBuckets(db *sql.DB, keywords string, limit int, w *queryWriter) (int, error) {
+	where, args := likeTerms("bucket", keywords)
+	query := "SELECT bucket, id, file_count, type FROM buckets"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := db.Query(query+" ORDER BY file_count DESC, bucket", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if w.format == "csv" {
+		w.csvHeader(bucketHeader(bucketsOptions{}))
+	}
+	n := 0
+	for rows.Next() && (limit <= 0 || n < limit) {
+		var b Bucket
+		var id string
+		if err := rows.Scan(&b.Name, &id, &b.FileCount, &b.Type); err != nil {
+			return n, err
+		}
+		b.ID = id
+		if w.format == "csv" {
+			w.csv.Write(safeRow(bucketRecord(b, bucketsOptions{})))
+		} else {
+			w.write(b)
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// queryWriter writes query results as a json array, json lines or csv.
+type queryWriter struct {
+	out    io.Writer
+	file   *os.File
+	format string
+	array  *jsonArray
+	csv    *csv.Writer
+}
+
+// newQueryWriter writes to output, or stdout without one, in format:
+// json|csv|ndjson, by default json on stdout and csv with -o.
+func newQueryWriter(output, format string) *queryWriter {
+	w := &queryWriter{out: os.Stdout, format: strings.ToLower(format)}
+	if w.format == "" {
+		w.format = "json"
+		if output != "" {
+			w.format = "csv"
+		}
+	}
+	if w.format != "json" && w.format != "csv" && w.format != "ndjson" {
+		log.Fatalf("unknown format %s\n", format)
+	}
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("create file: %v", err)
+		}
+		w.out, w.file = f, f
+	}
+	w.array = &jsonArray{w: w.out}
+	return w
+}
+
+func (w *queryWriter) csvHeader(header []string) {
+	w.csv = csv.NewWriter(w.out)
+	w.csv.Write(header)
+}
+
+func (w *queryWriter) write(v any) {
+	if w.format == "ndjson" {
+		data, _ := json.Marshal(v)
+		w.out.Write(append(data, '\n'))
+		return
+	}
+	w.array.write(v)
+}
+
+// done finishes the output and reports where n results went.
+func (w *queryWriter) done(n int) {
+	switch w.format {
+	case "json":
+		w.array.close()
+		io.WriteString(w.out, "\n")
+	case "csv":
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			log.Fatalf("write csv: %v", err)
+		}
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			log.Fatalf("write file: %v", err)
+		}
+		fmt.Println(tr("%d results saved to %s", n, w.file.Name()))
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is set by -config.
+var configFile string
+
+// config is the yaml config file, by default config.yaml in the user
+// config dir. defaults, the command sections and saved queries give flag
+// values, by flag name, for what the command line leaves out:
+//
+//	defaults:                # every command that has the flag
+//	  apikey: ...
+//	  retries: 5
+//	commands:
+//	  files:
+//	    format: csv
+//	    ext: "@documents"
+//	queries:                 # run with -saved backups
+//	  backups:
+//	    keywords: backup
+//	    ext: [sql, bak]
+//
+// sinks post a summary of matching results to chat channels when a files
+// run or a watch poll with -notify-sinks finds any:
+//
+//	sinks:
+//	  - type: slack            # slack|discord|telegram
+//	    webhook: https://hooks.slack.com/services/...
+//	    min-severity: high
+//	  - type: telegram
+//	    token: 123456:ABC...
+//	    chat: "-1001234567890"
+//	    ext: [sql, bak, env]
+//	    bucket: "(?i)backup"   # regex on the bucket name
+//	    min-files: 5
+type config struct {
+	Defaults map[string]any            `yaml:"defaults"`
+	Commands map[string]map[string]any `yaml:"commands"`
+	Queries  map[string]map[string]any `yaml:"queries"`
+	Sinks    []sinkConfig              `yaml:"sinks"`
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bucketsearch", "config.yaml"), nil
+}
+
+// loadConfig reads -config, or the default config file when it exists.
+func loadConfig() (config, error) {
+	var cfg config
+	path := configFile
+	if path == "" {
+		p, err := configPath()
+		if err != nil {
+			return cfg, nil
+		}
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return cfg, nil
+		}
+		path = p
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// envFlags take their default from the environment, which wins over the
+// config file.
+var envFlags = map[string]string{"apikey": "GHW_API_KEY", "api-base": "GHW_API_BASE", "es-api-key": "ES_API_KEY"}
+
+// parseFlags parses the command line of a command and fills in what it
+// leaves out from the config file. Commands with -keywords also get
+// -saved.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	addLangFlag(fs)
+	fs.StringVar(&configFile, "config", "", "Yaml config file with flag defaults, saved queries and sinks (default config.yaml in the user config dir, next to presets.json)")
+	var saved *string
+	if fs.Lookup("keywords") != nil {
+		saved = fs.String("saved", "", "Run the query saved under this name in the config file")
+	}
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("read config: %v", err)
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, env := range envFlags {
+		if os.Getenv(env) != "" {
+			set[name] = true
+		}
+	}
+	// later layers win: defaults, then the command's section, then the
+	// saved query
+	values := map[string]string{}
+	for name, v := range cfg.Defaults {
+		if fs.Lookup(name) != nil {
+			values[name] = configValue(v)
+		}
+	}
+	if err := mergeConfigValues(fs, values, cfg.Commands[fs.Name()]); err != nil {
+		log.Fatalf("config: commands: %s: %v", fs.Name(), err)
+	}
+	if saved != nil && *saved != "" {
+		q, ok := cfg.Queries[*saved]
+		if !ok {
+			log.Fatalf("config: no saved query %s\n", *saved)
+		}
+		if err := mergeConfigValues(fs, values, q); err != nil {
+			log.Fatalf("config: queries: %s: %v", *saved, err)
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			log.Fatalf("config: %s: %v", name, err)
+		}
+	}
+}
+
+func mergeConfigValues(fs *flag.FlagSet, values map[string]string, section map[string]any) error {
+	for name, v := range section {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("no flag -%s", name)
+		}
+		values[name] = configValue(v)
+	}
+	return nil
+}
+
+// configValue turns a yaml value into a flag value; lists are comma
+// separated.
+func configValue(v any) string {
+	if list, ok := v.([]any); ok {
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v)
+}
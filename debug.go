@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// verbosity is 1 with -v, logging every request with its status and
+// latency, and 2 with -vv, adding the start of each response body.
+var verbosity int
+
+// debugLog takes the -v lines: stderr, or -log-file.
+var debugLog = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
+
+// debugBodyMax is how much of a response body -vv logs.
+const debugBodyMax = 2048
+
+func addVerboseFlags(fs *flag.FlagSet) {
+	fs.Var(levelFlag(1), "v", "Log every request url, response status and latency to stderr")
+	fs.Var(levelFlag(2), "vv", "Like -v, adding the first 2KB of each response body")
+	fs.Func("log-file", "Write the -v/-vv log to this file instead of stderr, appending", func(path string) error {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		debugLog.SetOutput(f)
+		return nil
+	})
+}
+
+// levelFlag is a boolean flag raising verbosity to its level.
+type levelFlag int
+
+func (l levelFlag) IsBoolFlag() bool { return true }
+func (l levelFlag) String() string   { return "false" }
+
+func (l levelFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if on && int(l) > verbosity {
+		verbosity = int(l)
+	}
+	return err
+}
+
+// debugTransport logs the requests going through it at -v and -vv.
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if verbosity == 0 {
+		return t.next.RoundTrip(req)
+	}
+	began := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	took := time.Since(began).Round(time.Millisecond)
+	if err != nil {
+		debugLog.Printf("%s %s -> %v (%s)", req.Method, req.URL.Redacted(), err, took)
+		return resp, err
+	}
+	debugLog.Printf("%s %s -> %s (%s)", req.Method, req.URL.Redacted(), resp.Status, took)
+	if verbosity >= 2 && resp.Body != nil {
+		// log the start and hand the whole body on
+		head := make([]byte, debugBodyMax)
+		n, _ := io.ReadFull(resp.Body, head)
+		more := ""
+		if n == debugBodyMax {
+			more = " ..."
+		}
+		debugLog.Printf("  body: %s%s", bytes.TrimSpace(head[:n]), more)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head[:n]), resp.Body), resp.Body}
+	}
+	return resp, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// dedupFPRate is set by -dedup-fp-rate.
+var dedupFPRate float64
+
+func addDedupFlag(fs *flag.FlagSet) {
+	fs.Float64Var(&dedupFPRate, "dedup-fp-rate", 0.01, "False positive rate of the bloom filter in front of the on-disk index that dropping repeats of several searches moves to past a million results; lower takes more memory and fewer disk lookups, the result is exact either way")
+}
+
+const (
+	// dedupMemoryKeys is how many keys a dedupSet holds in memory before
+	// it moves them to disk, and dedupBatch how many new keys are written
+	// there at once.
+	dedupMemoryKeys = 1 << 20
+	dedupBatch      = 10000
+)
+
+// dedupSet is the set of urls or bucket names a run with several searches
+// already wrote. It starts as a map; past its memory limit the keys move
+// to a temporary sqlite index with a bloom filter in front, so only keys
+// the filter may have seen cost a disk lookup.
+type dedupSet struct {
+	mem   map[string]bool
+	limit int
+	bloom *bloomFilter
+	db    *sql.DB
+	dir   string
+	batch [][]byte
+}
+
+func newDedupSet() *dedupSet {
+	return &dedupSet{mem: map[string]bool{}, limit: dedupMemoryKeys}
+}
+
+// add records key and says whether it is new. A nil set keeps nothing and
+// takes every key as new.
+func (s *dedupSet) add(key string) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+	if s.db == nil {
+		if s.mem[key] {
+			return false, nil
+		}
+		s.mem[key] = true
+		if len(s.mem) > s.limit {
+			return true, s.spill()
+		}
+		return true, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	h := sum[:16]
+	if s.bloom.has(h) {
+		if err := s.flush(); err != nil {
+			return false, err
+		}
+		var n int
+		if err := s.db.QueryRow("SELECT count(*) FROM seen WHERE key = ?", h).Scan(&n); err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return false, nil
+		}
+	}
+	s.bloom.add(h)
+	s.batch = append(s.batch, h)
+	if len(s.batch) >= dedupBatch {
+		return true, s.flush()
+	}
+	return true, nil
+}
+
+// spill moves the keys in memory to the disk index, sizing the bloom
+// filter for ten times as many.
+func (s *dedupSet) spill() error {
+	dir, err := os.MkdirTemp("", "bucketsearch-dedup")
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(dir, "seen.db")+"?_journal_mode=OFF&_synchronous=OFF")
+	if err == nil {
+		_, err = db.Exec("CREATE TABLE seen (key BLOB PRIMARY KEY) WITHOUT ROWID")
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("dedup index: %v", err)
+	}
+	s.db, s.dir = db, dir
+	s.bloom = newBloomFilter(10*len(s.mem), dedupFPRate)
+	for key := range s.mem {
+		sum := sha256.Sum256([]byte(key))
+		s.bloom.add(sum[:16])
+		s.batch = append(s.batch, sum[:16])
+	}
+	s.mem = nil
+	return s.flush()
+}
+
+// flush writes the new keys batched since the last flush.
+func (s *dedupSet) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO seen (key) VALUES (?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, h := range s.batch {
+		if _, err := stmt.Exec(h); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	stmt.Close()
+	s.batch = s.batch[:0]
+	return tx.Commit()
+}
+
+// close removes the disk index, if the set moved to one.
+func (s *dedupSet) close() {
+	if s == nil || s.db == nil {
+		return
+	}
+	s.db.Close()
+	os.RemoveAll(s.dir)
+}
+
+// bloomFilter is a bloom filter over sha256 prefixes, its k probes
+// derived from two 64 bit halves of the hash.
+type bloomFilter struct {
+	bits []uint64
+	m, k uint64
+}
+
+// newBloomFilter sizes a filter for n keys at false positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+func (b *bloomFilter) add(h []byte) {
+	h1, h2 := binary.LittleEndian.Uint64(h), binary.LittleEndian.Uint64(h[8:])
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) has(h []byte) bool {
+	h1, h2 := binary.LittleEndian.Uint64(h), binary.LittleEndian.Uint64(h[8:])
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDedupSetSpillsToDisk(t *testing.T) {
+	s := newDedupSet()
+	s.limit = 100
+	for i := 0; i < 2*dedupBatch; i++ {
+		if isNew, err := s.add(fmt.Sprint("url", i)); err != nil || !isNew {
+			t.Fatalf("add %d = %v, %v, want new", i, isNew, err)
+		}
+	}
+	if s.db == nil {
+		t.Fatal("the set never moved to disk")
+	}
+	// repeats from before the spill, from flushed batches and from the
+	// batch not yet written
+	for _, i := range []int{0, 50, 100, 101, 5000, 2*dedupBatch - 1} {
+		if isNew, err := s.add(fmt.Sprint("url", i)); err != nil || isNew {
+			t.Errorf("repeat %d = %v, %v, want seen", i, isNew, err)
+		}
+	}
+	if isNew, _ := s.add("another"); !isNew {
+		t.Error("a new key was taken as seen")
+	}
+	dir := s.dir
+	s.close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the disk index outlived the set: %v", err)
+	}
+
+	var none *dedupSet
+	if isNew, err := none.add("a"); !isNew || err != nil {
+		t.Errorf("a nil set took a key as seen")
+	}
+}
+
+func TestBloomFilterRate(t *testing.T) {
+	b := newBloomFilter(10000, 0.01)
+	hash := func(s string) []byte {
+		sum := sha256.Sum256([]byte(s))
+		return sum[:16]
+	}
+	for i := 0; i < 10000; i++ {
+		b.add(hash(fmt.Sprint("in", i)))
+	}
+	for i := 0; i < 10000; i++ {
+		if !b.has(hash(fmt.Sprint("in", i))) {
+			t.Fatalf("lost key %d", i)
+		}
+	}
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if b.has(hash(fmt.Sprint("out", i))) {
+			fp++
+		}
+	}
+	if fp > 200 {
+		t.Errorf("got %d false positives in 10000, want about 100", fp)
+	}
+}
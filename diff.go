@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func runDiff(args []string) {
+	fs := newFlagSet("diff", "Compare two files or buckets exports given as arguments, old then new, in any format -o writes except xlsx, and report what was added, removed and changed.")
+	output := fs.String("o", "", "Output file path. If empty, print to stdout")
+	format := fs.String("format", "text", "Output format: text|csv|json")
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	old, err := loadTable(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("read %s: %v", fs.Arg(0), err)
+	}
+	cur, err := loadTable(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("read %s: %v", fs.Arg(1), err)
+	}
+	if old.kind != cur.kind {
+		log.Fatalf("%s holds %s but %s holds %s\n", fs.Arg(0), old.kind, fs.Arg(1), cur.kind)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("create file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	writeDiff(out, strings.ToLower(*format), diffTables(old, cur))
+}
+
+type fieldChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type rowChange struct {
+	Key    string                 `json:"key"`
+	Fields map[string]fieldChange `json:"fields"`
+}
+
+type tableDiff struct {
+	Kind    string              `json:"kind"`
+	Before  int                 `json:"before"`
+	After   int                 `json:"after"`
+	Added   []map[string]string `json:"added"`
+	Removed []map[string]string `json:"removed"`
+	Changed []rowChange         `json:"changed"`
+}
+
+// diffTables matches rows by key and compares the columns both sides have.
+func diffTables(old, cur *table) tableDiff {
+	d := tableDiff{
+		Kind:    cur.kind,
+		Before:  len(old.rows),
+		After:   len(cur.rows),
+		Added:   []map[string]string{},
+		Removed: []map[string]string{},
+		Changed: []rowChange{},
+	}
+	inOld := map[string]bool{}
+	for _, c := range old.columns {
+		inOld[c] = true
+	}
+	var common []string
+	for _, c := range cur.columns {
+		if inOld[c] && c != cur.key {
+			common = append(common, c)
+		}
+	}
+	before := map[string]map[string]string{}
+	for _, row := range old.rows {
+		before[row[old.key]] = row
+	}
+	after := map[string]bool{}
+	for _, row := range cur.rows {
+		key := row[cur.key]
+		after[key] = true
+		prev, ok := before[key]
+		if !ok {
+			d.Added = append(d.Added, row)
+			continue
+		}
+		fields := map[string]fieldChange{}
+		for _, c := range common {
+			if a, b := diffValue(c, prev[c]), diffValue(c, row[c]); a != b {
+				fields[c] = fieldChange{prev[c], row[c]}
+			}
+		}
+		if len(fields) > 0 {
+			d.Changed = append(d.Changed, rowChange{key, fields})
+		}
+	}
+	for _, row := range old.rows {
+		if !after[row[old.key]] {
+			d.Removed = append(d.Removed, row)
+		}
+	}
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Key < d.Changed[j].Key })
+	return d
+}
+
+// diffValue normalizes what differs between exports without meaning a
+// change: csv writes times in local time, sqlite in UTC.
+func diffValue(column, v string) string {
+	if column == "lastModified" || column == "firstSeen" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return v
+}
+
+func writeDiff(out io.Writer, format string, d tableDiff) {
+	key := "url"
+	if d.Kind == "buckets" {
+		key = "bucket"
+	}
+	switch format {
+	case "json":
+		b, _ := json.MarshalIndent(d, "", "  ")
+		out.Write(append(b, '\n'))
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"change", key, "field", "old", "new"})
+		for _, row := range d.Added {
+			w.Write(safeRow([]string{"added", row[key], "", "", ""}))
+		}
+		for _, row := range d.Removed {
+			w.Write(safeRow([]string{"removed", row[key], "", "", ""}))
+		}
+		for _, c := range d.Changed {
+			for _, f := range sortedFields(c.Fields) {
+				w.Write(safeRow([]string{"changed", c.Key, f, c.Fields[f].Old, c.Fields[f].New}))
+			}
+		}
+		w.Flush()
+	default:
+		fmt.Fprintf(out, "%s: %d added, %d removed, %d changed (%d -> %d)\n", d.Kind, len(d.Added), len(d.Removed), len(d.Changed), d.Before, d.After)
+		for _, row := range d.Added {
+			fmt.Fprintf(out, "+ %s\n", row[key])
+		}
+		for _, row := range d.Removed {
+			fmt.Fprintf(out, "- %s\n", row[key])
+		}
+		for _, c := range d.Changed {
+			var parts []string
+			for _, f := range sortedFields(c.Fields) {
+				parts = append(parts, fmt.Sprintf("%s %s -> %s", f, c.Fields[f].Old, c.Fields[f].New))
+			}
+			fmt.Fprintf(out, "~ %s  %s\n", c.Key, strings.Join(parts, ", "))
+		}
+	}
+}
+
+func sortedFields(fields map[string]fieldChange) []string {
+	var names []string
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestExport(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestTable(t *testing.T, name, data string) *table {
+	t.Helper()
+	tab, err := loadTable(writeTestExport(t, name, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tab
+}
+
+func TestDiffTables(t *testing.T) {
+	old := loadTestTable(t, "old.csv", "bucket,url,size,lastModified\n"+
+		"a,https://a/1,10,2024-01-01T08:00:00+08:00\n"+
+		"a,https://a/2,20,2024-01-01T00:00:00Z\n"+
+		"b,https://b/3,30,2024-01-01T00:00:00Z\n")
+	// a json export of the same files, with times in unix seconds
+	cur := loadTestTable(t, "new.ndjson", `{"bucket":"a","url":"https://a/1","size":10,"lastModified":1704067200}
+{"bucket":"a","url":"https://a/2","size":25,"lastModified":1704067200}
+{"bucket":"c","url":"https://c/4","size":40,"lastModified":1704067200}
+`)
+	d := diffTables(old, cur)
+	if d.Kind != "files" || d.Before != 3 || d.After != 3 {
+		t.Errorf("got %s %d -> %d", d.Kind, d.Before, d.After)
+	}
+	if len(d.Added) != 1 || d.Added[0]["url"] != "https://c/4" {
+		t.Errorf("added %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0]["url"] != "https://b/3" {
+		t.Errorf("removed %v", d.Removed)
+	}
+	want := []rowChange{{"https://a/2", map[string]fieldChange{"size": {"20", "25"}}}}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("changed %+v, want %+v", d.Changed, want)
+	}
+
+	var text, csv bytes.Buffer
+	writeDiff(&text, "text", d)
+	wantText := "files: 1 added, 1 removed, 1 changed (3 -> 3)\n+ https://c/4\n- https://b/3\n~ https://a/2  size 20 -> 25\n"
+	if text.String() != wantText {
+		t.Errorf("text diff:\n%s\nwant:\n%s", text.String(), wantText)
+	}
+	writeDiff(&csv, "csv", d)
+	wantCSV := "change,url,field,old,new\nadded,https://c/4,,,\nremoved,https://b/3,,,\nchanged,https://a/2,size,20,25\n"
+	if csv.String() != wantCSV {
+		t.Errorf("csv diff:\n%s\nwant:\n%s", csv.String(), wantCSV)
+	}
+}
+
+func TestDiffBuckets(t *testing.T) {
+	old := loadTestTable(t, "old.csv", "bucket,fileCount\na,1\nb,2\n")
+	cur := loadTestTable(t, "new.csv", "bucket,fileCount,region\na,1,us-east-1\nb,3,eu-west-1\n")
+	d := diffTables(old, cur)
+	// region is new, so only fileCount is compared
+	if d.Kind != "buckets" || len(d.Added)+len(d.Removed) != 0 || len(d.Changed) != 1 || d.Changed[0].Key != "b" {
+		t.Errorf("got %+v", d)
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+func runDownload(args []string) {
+	fs := newFlagSet("download", "Download the files a search matches, or the files of a -input export, into -dir/<bucket>/<path>.")
+	common := addAPIFlags(fs)
+	filters := addFileFilterFlags(fs)
+	keywords := fs.String("keywords", "", "Search keywords")
+	limit := fs.Int("limit", 1000, "Page size for the search, at most 1000")
+	start := fs.Int("start", 0, "Search offset to start at")
+	input := fs.String("input", "", "Export of a previous files run to download instead of searching: csv, json, ndjson, sqlite or parquet")
+	dir := fs.String("dir", "downloads", "Directory to download into, one subdirectory per bucket")
+	workers := fs.Int("workers", 4, "Download up to N files concurrently")
+	maxFileSize := fs.String("max-file-size", "", "Skip files larger than this, e.g. 50MB")
+	maxTotalSize := fs.String("max-total-size", "", "Stop downloading once this much was fetched, e.g. 2GB")
+	manifest := fs.String("manifest", "", "Csv manifest of every file and what happened to it (default <dir>/manifest.csv)")
+	addNotifyFlag(fs)
+	addQuietFlag(fs)
+	yaraRules := fs.String("yara", "", "Directory of .yar/.yara rules to scan every downloaded file with, using the yara command; matches go to the manifest")
+	parseFlags(fs, args)
+	startDeadline()
+
+	opts := downloadOptions{dir: *dir, workers: *workers, manifest: *manifest}
+	var err error
+	if *yaraRules != "" {
+		if opts.yara, err = newYaraScanner(*yaraRules); err != nil {
+			log.Fatalf("yara: %v", err)
+		}
+	}
+	if opts.maxFileSize, err = parseByteSize(*maxFileSize); err != nil {
+		log.Fatalf("max-file-size: %v", err)
+	}
+	if opts.maxTotalSize, err = parseByteSize(*maxTotalSize); err != nil {
+		log.Fatalf("max-total-size: %v", err)
+	}
+	if opts.manifest == "" {
+		opts.manifest = filepath.Join(opts.dir, "manifest.csv")
+	}
+
+	// an interrupt lets the downloads in flight finish and records the
+	// rest as stopped, so the manifest is complete; handleFiles only
+	// catches it while it pages
+	defer catchInterrupt()()
+	d := newDownloader(opts)
+	if *input != "" {
+		files, err := loadFindings(*input)
+		if err != nil {
+			log.Fatalf("read %s: %v", *input, err)
+		}
+		d.add(files)
+	} else {
+		fopts := filesOptions{keywords: *keywords, limit: *limit, start: *start, workers: 1, collect: d.add}
+		filters.apply(&fopts)
+		api := common.client("download")
+		defer telemetry.flush()
+		handleFiles(api, newWebClient(), fopts)
+	}
+	d.wait()
+}
+
+type downloadOptions struct {
+	dir          string
+	workers      int
+	maxFileSize  int64
+	maxTotalSize int64
+	manifest     string
+	yara         *yaraScanner
+}
+
+// downloader fetches files on a pool of workers and records each outcome
+// in the manifest.
+type downloader struct {
+	opts   downloadOptions
+	client *http.Client
+	queue  chan download
+	done   sync.WaitGroup
+	// dests holds the destinations taken so far, lower cased for case
+	// insensitive filesystems, and the url each was taken for
+	dests map[string]string
+
+	mu       sync.Mutex
+	manifest *csv.Writer
+	file     *os.File
+	used     int64
+	counts   map[string]int
+}
+
+func newDownloader(opts downloadOptions) *downloader {
+	if err := os.MkdirAll(opts.dir, 0755); err != nil {
+		log.Fatalf("create dir: %v", err)
+	}
+	f, err := os.Create(opts.manifest)
+	if err != nil {
+		log.Fatalf("create manifest: %v", err)
+	}
+	d := &downloader{
+		opts:     opts,
+		client:   &http.Client{Transport: newTransport(30 * time.Second)},
+		queue:    make(chan download, 100),
+		dests:    map[string]string{},
+		manifest: csv.NewWriter(f),
+		file:     f,
+		counts:   map[string]int{},
+	}
+	d.manifest.Write([]string{"url", "bucket", "name", "path", "size", "sha256", "status", "error", "yara"})
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		d.done.Add(1)
+		go func() {
+			defer d.done.Done()
+			for dl := range d.queue {
+				d.fetch(dl)
+			}
+		}()
+	}
+	return d
+}
+
+// download is a queued file and the destination reserved for it.
+type download struct {
+	file File
+	dest string
+	err  error
+}
+
+// add queues files, reserving their destinations in input order so
+// reruns of the same input give every file the same path.
+func (d *downloader) add(files []File) {
+	for _, file := range files {
+		dest, err := d.reserve(file)
+		d.queue <- download{file, dest, err}
+	}
+}
+
+var errDuplicate = errors.New("listed before")
+
+// reserve claims the destination of a file. When another url already has
+// it, as names differing only in case or in the characters downloadPath
+// replaces do, the file gets a numbered name, e.g. report (2).pdf, so no
+// two workers write the same file or .part. A url seen before is
+// errDuplicate.
+func (d *downloader) reserve(file File) (string, error) {
+	dest, err := downloadPath(d.opts.dir, file)
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ext := filepath.Ext(dest)
+	for i := 1; ; i++ {
+		try := dest
+		if i > 1 {
+			try = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(dest, ext), i, ext)
+		}
+		key := strings.ToLower(try)
+		if url, ok := d.dests[key]; ok && url == file.URL {
+			return try, errDuplicate
+		}
+		_, taken := d.dests[key]
+		_, partTaken := d.dests[key+".part"]
+		if !taken && !partTaken {
+			d.dests[key] = file.URL
+			d.dests[key+".part"] = file.URL
+			return try, nil
+		}
+	}
+}
+
+// wait lets the workers finish, closes the manifest and prints a summary.
+func (d *downloader) wait() {
+	close(d.queue)
+	d.done.Wait()
+	d.manifest.Flush()
+	if err := d.manifest.Error(); err != nil {
+		log.Fatalf("write manifest: %v", err)
+	}
+	d.file.Close()
+	done := tr("downloaded %d files (%s) to %s", d.counts["ok"], humanSize(d.used), d.opts.dir)
+	for _, status := range []string{"exists", "duplicate", "too-large", "over-budget", "failed", "stopped"} {
+		if n := d.counts[status]; n > 0 {
+			done += fmt.Sprintf(", %d %s", n, status)
+		}
+	}
+	if d.opts.yara != nil {
+		done += tr(", %d matched yara rules", d.counts["yara"])
+	}
+	summary(tr("%s; manifest at %s", done, d.opts.manifest))
+	notify("bucketsearch download", done)
+}
+
+// fetch downloads one file unless it is over a limit or already there,
+// or the run reached -deadline or was interrupted.
+func (d *downloader) fetch(dl download) {
+	file, dest := dl.file, dl.dest
+	switch {
+	case errors.Is(dl.err, errDuplicate):
+		d.record(file, dest, 0, "", "duplicate", nil, nil)
+		return
+	case dl.err != nil:
+		d.record(file, "", 0, "", "failed", nil, dl.err)
+		return
+	}
+	if err := runStopped(); err != nil {
+		d.record(file, dest, 0, "", "stopped", nil, err)
+		return
+	}
+	if d.opts.maxFileSize > 0 && file.Size > d.opts.maxFileSize {
+		d.record(file, dest, file.Size, "", "too-large", nil, nil)
+		return
+	}
+	if fi, err := os.Stat(dest); err == nil && fi.Size() == file.Size {
+		d.record(file, dest, fi.Size(), "", "exists", d.scan(dest), nil)
+		return
+	}
+
+	// the indexed size is reserved against the budget up front, the real
+	// one settled once the download is done; a file cut off by what is
+	// left of the budget rather than by -max-file-size is over-budget
+	limit, cutStatus := d.opts.maxFileSize, "too-large"
+	d.mu.Lock()
+	if d.opts.maxTotalSize > 0 {
+		left := d.opts.maxTotalSize - d.used
+		if file.Size > left || left <= 0 {
+			d.mu.Unlock()
+			d.record(file, dest, file.Size, "", "over-budget", nil, nil)
+			return
+		}
+		if limit == 0 || left < limit {
+			limit, cutStatus = left, "over-budget"
+		}
+	}
+	d.used += file.Size
+	d.mu.Unlock()
+
+	n, sum, err := d.get(file.URL, dest, limit)
+	d.mu.Lock()
+	d.used += n - file.Size
+	d.mu.Unlock()
+	switch {
+	case errors.Is(err, errTooLarge):
+		d.record(file, dest, n, "", cutStatus, nil, nil)
+	case err != nil && runCtx.Err() != nil:
+		d.record(file, dest, n, "", "stopped", nil, runCtx.Err())
+	case err != nil:
+		d.record(file, dest, n, "", "failed", nil, err)
+	default:
+		d.record(file, dest, n, sum, "ok", d.scan(dest), nil)
+	}
+}
+
+// scan runs the yara rules over a downloaded file, nil without -yara.
+func (d *downloader) scan(path string) []string {
+	if d.opts.yara == nil {
+		return nil
+	}
+	matches, err := d.opts.yara.scan(path)
+	if err != nil {
+		log.Printf("yara %s: %v", path, err)
+	}
+	return matches
+}
+
+var errTooLarge = errors.New("larger than the limit")
+
+// runStopped says why the run is stopping, nil while it isn't.
+func runStopped() error {
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	if interrupted.Load() {
+		return errors.New("interrupted")
+	}
+	return nil
+}
+
+// get streams url into dest through a .part file, giving up past limit
+// bytes when limit is positive. It returns the bytes kept and their sha256.
+func (d *downloader) get(url, dest string, limit int64) (int64, string, error) {
+	req, err := http.NewRequestWithContext(runCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("http %d", resp.StatusCode)
+	}
+	if limit > 0 && resp.ContentLength > limit {
+		return 0, "", errTooLarge
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, "", err
+	}
+	part := dest + ".part"
+	out, err := os.Create(part)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	n, err := io.Copy(io.MultiWriter(out, h), body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && limit > 0 && n > limit {
+		err = errTooLarge
+	}
+	if err != nil {
+		os.Remove(part)
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), os.Rename(part, dest)
+}
+
+// record writes the manifest row of a file; status ok and exists rows
+// carry the yara rules that matched.
+func (d *downloader) record(file File, dest string, size int64, sum, status string, rules []string, err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	matched := strings.Join(rules, ";")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counts[status]++
+	if matched != "" {
+		d.counts["yara"]++
+	}
+	d.manifest.Write(safeRow([]string{file.URL, file.Bucket, file.Name, dest, strconv.FormatInt(size, 10), sum, status, msg, matched}))
+	d.manifest.Flush()
+}
+
+// downloadPath maps a file to dir/<bucket>/<path>, keeping it inside dir
+// whatever the indexed name contains. Every path component is made safe
+// to create on windows too, since downloads get copied there: reserved
+// characters become _, reserved device names get a _ prefix, and names
+// over maxNameLen bytes are cut short with a hash of the original. A
+// path still longer than maxPathLen goes flat into the bucket directory,
+// its file name prefixed with a hash of the whole path and cut to fit. The manifest maps each url to its path.
+func downloadPath(dir string, file File) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(file.Name, "\\", "/")), "/")
+	if file.Bucket == "" || file.Bucket == "." || file.Bucket == ".." || name == "" {
+		return "", fmt.Errorf("no usable bucket or name in %q", file.URL)
+	}
+	bucket := filepath.Join(dir, safeComponent(file.Bucket))
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = safeComponent(p)
+	}
+	dest := filepath.Join(bucket, filepath.Join(parts...))
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return "", err
+	}
+	if len(abs) <= maxPathLen {
+		return dest, nil
+	}
+	room := maxPathLen - (len(abs) - len(dest)) - len(bucket) - 1
+	if room < 32 {
+		room = 32
+	}
+	base := parts[len(parts)-1]
+	if len(parts) > 1 {
+		base = hashName(name) + "-" + base
+	}
+	return filepath.Join(bucket, shortenName(base, room)), nil
+}
+
+const (
+	// maxNameLen is the longest file name most filesystems take, and
+	// maxPathLen windows' MAX_PATH less the terminating nul and room for
+	// the .part and (n) suffixes a destination can get.
+	maxNameLen = 255
+	maxPathLen = 259 - 16
+)
+
+// windowsDevices are the names windows reserves, with any extension.
+var windowsDevices = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// safeComponent makes one path component safe to create: no separators,
+// reserved or control characters, trailing dots or spaces, device names
+// or names over maxNameLen bytes.
+func safeComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return "_"
+	}
+	stem, _, _ := strings.Cut(s, ".")
+	if windowsDevices[strings.ToUpper(strings.TrimSpace(stem))] {
+		s = "_" + s
+	}
+	return shortenName(s, maxNameLen)
+}
+
+// shortenName cuts a name to n bytes, keeping a short extension and
+// putting a hash of the whole name before it so cut names stay distinct.
+func shortenName(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	ext := path.Ext(s)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(s, ext)
+	keep := n - len(ext) - 9
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	return stem[:keep] + "~" + hashName(s) + ext
+}
+
+// hashName returns 8 hex digits of the sha256 of s.
+func hashName(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:4])
+}
+
+// parseByteSize reads sizes like 512, 10KB, 1.5MB, 10M or 2G; units are
+// powers of 1024. An empty string is 0, no limit.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// yaraScanner runs the yara command line tool, so no libyara is needed
+// at build time.
+type yaraScanner struct {
+	bin   string
+	rules []string
+}
+
+func newYaraScanner(dir string) (*yaraScanner, error) {
+	bin, err := exec.LookPath("yara")
+	if err != nil {
+		return nil, errors.New("-yara needs the yara command in PATH")
+	}
+	var rules []string
+	for _, pattern := range []string{"*.yar", "*.yara"} {
+		m, _ := filepath.Glob(filepath.Join(dir, pattern))
+		rules = append(rules, m...)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no .yar or .yara files in %s", dir)
+	}
+	return &yaraScanner{bin: bin, rules: rules}, nil
+}
+
+// scan returns the names of the rules matching the file at path.
+func (y *yaraScanner) scan(path string) ([]string, error) {
+	args := append([]string{"-w"}, y.rules...)
+	out, err := exec.Command(y.bin, append(args, path)...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return nil, errors.New(strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, err
+	}
+	// one "<rule> <path>" line per match
+	var matches []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if rule, _, ok := strings.Cut(line, " "); ok {
+			matches = append(matches, rule)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		bad  bool
+	}{
+		{"", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"10KB", 10 << 10, false},
+		{"10k", 10 << 10, false},
+		{"1.5MB", 3 << 19, false},
+		{"10M", 10 << 20, false},
+		{" 2 GB ", 2 << 30, false},
+		{"1T", 1 << 40, false},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.bad || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.bad)
+		}
+	}
+}
+
+func TestDownloadPath(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("é", 200) + ".pdf"
+	tests := []struct {
+		bucket, name string
+		want         string
+		bad          bool
+	}{
+		{"a", "dir/dump.sql", "a/dir/dump.sql", false},
+		{"a", "../../etc/passwd", "a/etc/passwd", false},
+		{"a", `..\..\win.ini`, "a/win.ini", false},
+		{"a/../b", "x", "a_.._b/x", false},
+		{"a", `what?<is>:"this"|*.txt`, "a/what__is___this___.txt", false},
+		{"a", "tab\there\x00.txt", "a/tab_here_.txt", false},
+		{"a", "trailing. /dots...", "a/trailing/dots", false},
+		{"a", "con", "a/_con", false},
+		{"a", "dir/Nul.tar.gz", "a/dir/_Nul.tar.gz", false},
+		{"a", "lpt1 .txt", "a/_lpt1 .txt", false},
+		{"a", "console.txt", "a/console.txt", false},
+		{"a", "", "", true},
+		{"..", "x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := downloadPath(dir, File{File: ghw.File{Bucket: tt.bucket, Name: tt.name}})
+		if (err != nil) != tt.bad {
+			t.Errorf("downloadPath(%q, %q) error %v, want error %v", tt.bucket, tt.name, err, tt.bad)
+			continue
+		}
+		if !tt.bad && got != filepath.Join(dir, filepath.FromSlash(tt.want)) {
+			t.Errorf("downloadPath(%q, %q) = %s, want %s", tt.bucket, tt.name, got, tt.want)
+		}
+	}
+
+	got, err := downloadPath(dir, File{File: ghw.File{Bucket: "a", Name: long}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs(got)
+	if base := filepath.Base(got); len(abs) > maxPathLen || !strings.HasSuffix(base, ".pdf") || !strings.HasPrefix(base, "é") {
+		t.Errorf("shortened %d bytes to %s", len(long), base)
+	}
+	other, _ := downloadPath(dir, File{File: ghw.File{Bucket: "a", Name: strings.Repeat("é", 201) + ".pdf"}})
+	if other == got {
+		t.Errorf("two long names shortened to the same %s", got)
+	}
+
+	if s := safeComponent(strings.Repeat("x", 300) + ".tar.gz"); len(s) != maxNameLen || !strings.HasSuffix(s, ".gz") {
+		t.Errorf("safeComponent cut 307 bytes to %d: %s", len(s), s)
+	}
+
+	deep := strings.Repeat("directory/", 30) + "file.txt"
+	got, err = downloadPath(dir, File{File: ghw.File{Bucket: "a", Name: deep}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ = filepath.Abs(got)
+	if len(abs) > maxPathLen || filepath.Dir(got) != filepath.Join(dir, "a") || !strings.HasSuffix(got, "-file.txt") {
+		t.Errorf("got %s (%d bytes) for a %d byte name", got, len(abs), len(deep))
+	}
+}
+
+func TestDownloadReservesDestinations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	d := newDownloader(downloadOptions{dir: dir, workers: 4, manifest: filepath.Join(dir, "manifest.csv")})
+	files := []File{
+		{File: ghw.File{Bucket: "a", Name: "Report.pdf", URL: srv.URL + "/1"}},
+		{File: ghw.File{Bucket: "a", Name: "report.pdf", URL: srv.URL + "/2"}},
+		{File: ghw.File{Bucket: "a", Name: "report?.pdf", URL: srv.URL + "/3"}},
+		{File: ghw.File{Bucket: "a", Name: "report_.pdf", URL: srv.URL + "/4"}},
+		{File: ghw.File{Bucket: "a", Name: "Report.pdf", URL: srv.URL + "/1"}},
+	}
+	for i := range files {
+		files[i].Size = int64(len("/1"))
+	}
+	d.add(files)
+	d.wait()
+
+	rows := readTestCSV(t, filepath.Join(dir, "manifest.csv"))
+	paths := map[string]string{}
+	statuses := map[string]int{}
+	for _, row := range rows[1:] {
+		statuses[row[6]]++
+		if row[6] == "ok" {
+			paths[row[0]] = row[3]
+		}
+	}
+	want := map[string]string{
+		srv.URL + "/1": "Report.pdf",
+		srv.URL + "/2": "report (2).pdf",
+		srv.URL + "/3": "report_.pdf",
+		srv.URL + "/4": "report_ (2).pdf",
+	}
+	for url, name := range want {
+		if paths[url] != filepath.Join(dir, "a", name) {
+			t.Errorf("%s went to %s, want %s", url, paths[url], name)
+			continue
+		}
+		if data, _ := os.ReadFile(paths[url]); string(data) != strings.TrimPrefix(url, srv.URL) {
+			t.Errorf("%s holds %q", paths[url], data)
+		}
+	}
+	if statuses["ok"] != 4 || statuses["duplicate"] != 1 {
+		t.Errorf("got statuses %v, want 4 ok and a duplicate", statuses)
+	}
+}
+
+func TestDownloadLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name              string
+		maxFile, maxTotal int64
+		want              string
+	}{
+		{"per file", 5, 0, "too-large"},
+		{"budget", 0, 5, "over-budget"},
+		{"budget under the per file limit", 8, 5, "over-budget"},
+		{"per file under the budget", 5, 8, "too-large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := newDownloader(downloadOptions{dir: dir, workers: 1, manifest: filepath.Join(dir, "manifest.csv"),
+				maxFileSize: tt.maxFile, maxTotalSize: tt.maxTotal})
+			// the index says 2 bytes, the server sends 10
+			d.add([]File{{File: ghw.File{Bucket: "a", Name: "f", URL: srv.URL + "/f", Size: 2}}})
+			d.wait()
+			rows := readTestCSV(t, filepath.Join(dir, "manifest.csv"))
+			if got := rows[1][6]; got != tt.want {
+				t.Errorf("got status %s, want %s", got, tt.want)
+			}
+			if d.used != 0 {
+				t.Errorf("kept %d bytes of the budget", d.used)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+// elasticServer answers index creation and bulk requests like es,
+// rejecting the documents whose name is in reject.
+type elasticServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	indices  map[string]string
+	actions  []map[string]map[string]string
+	docs     []map[string]any
+	auth     []string
+	reject   string
+	bulkCode int
+}
+
+func newElasticServer(t *testing.T) *elasticServer {
+	s := &elasticServer{indices: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.auth = append(s.auth, r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == "PUT":
+			index := strings.TrimPrefix(r.URL.Path, "/")
+			if _, ok := s.indices[index]; ok {
+				http.Error(w, `{"error":{"type":"resource_already_exists_exception"}}`, http.StatusBadRequest)
+				return
+			}
+			s.indices[index] = string(body)
+		case r.Method == "POST" && r.URL.Path == "/_bulk":
+			if s.bulkCode != 0 {
+				http.Error(w, "no", s.bulkCode)
+				return
+			}
+			var items []map[string]any
+			sc := bufio.NewScanner(strings.NewReader(string(body)))
+			sc.Buffer(nil, 1<<20)
+			for sc.Scan() {
+				var action map[string]map[string]string
+				json.Unmarshal(sc.Bytes(), &action)
+				sc.Scan()
+				var doc map[string]any
+				json.Unmarshal(sc.Bytes(), &doc)
+				s.actions = append(s.actions, action)
+				s.docs = append(s.docs, doc)
+				result := map[string]any{"status": 201}
+				if doc["name"] == s.reject && s.reject != "" {
+					result["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "bad"}
+				}
+				items = append(items, map[string]any{"index": result})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"errors": s.reject != "", "items": items})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestElastic(t *testing.T, base string, batch int) *elasticSink {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Cleanup(func() { sinkErr = nil })
+	return &elasticSink{base: base, user: "elastic", password: "pw", batch: batch, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func TestElasticIndexesFiles(t *testing.T) {
+	srv := newElasticServer(t)
+	srv.reject = "bad.sql"
+	s := newTestElastic(t, srv.URL, 2)
+	files := []File{
+		{File: ghw.File{ID: 1, Bucket: "a", BucketID: 10, Name: "dump.sql", URL: "https://a.s3.amazonaws.com/dump.sql", Size: 5, LastModified: 1700000000}, Keyword: "dump", MatchedOn: []string{"dump", "sql"}},
+		{File: ghw.File{ID: 2, Bucket: "a", BucketID: 10, Name: "bad.sql", URL: "https://a.s3.amazonaws.com/bad.sql"}},
+		{File: ghw.File{ID: 3, Bucket: "b", BucketID: 20, Name: "notes.txt", URL: "https://b.s3.amazonaws.com/" + strings.Repeat("x", 600)}},
+	}
+	s.addFiles(files)
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if mapping, ok := srv.indices["bucketsearch-files"]; !ok || !strings.Contains(mapping, `"lastModified"`) || !strings.Contains(mapping, `"keyword":{`) {
+		t.Errorf("created indices %v, want bucketsearch-files with the files mapping", srv.indices)
+	}
+	if len(srv.docs) != 3 || s.indexed != 2 {
+		t.Fatalf("sent %d documents, %d indexed, want 3 and 2", len(srv.docs), s.indexed)
+	}
+	ids := map[string]bool{}
+	for _, a := range srv.actions {
+		id := a["index"]["_id"]
+		if len(id) != 64 || a["index"]["_index"] != "bucketsearch-files" {
+			t.Errorf("got action %v, want a url hash into bucketsearch-files", a)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("got %d distinct ids for 3 urls", len(ids))
+	}
+	doc := srv.docs[0]
+	if doc["ext"] != "sql" || doc["id"] != "1" || doc["lastModified"] != float64(1700000000) || doc["keyword"] != "dump" ||
+		!reflect.DeepEqual(doc["matchedOn"], []any{"dump", "sql"}) {
+		t.Errorf("got document %v", doc)
+	}
+	if _, ok := srv.docs[1]["lastModified"]; ok {
+		t.Errorf("sent a zero lastModified: %v", srv.docs[1])
+	}
+	if _, ok := srv.docs[1]["keyword"]; ok {
+		t.Errorf("sent an empty keyword: %v", srv.docs[1])
+	}
+	if srv.auth[0] != "Basic ZWxhc3RpYzpwdw==" {
+		t.Errorf("sent Authorization %q", srv.auth[0])
+	}
+
+	// a second run updates the same documents
+	again := newTestElastic(t, srv.URL, 10)
+	again.addFiles(files[:1])
+	if err := again.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if id := srv.actions[3]["index"]["_id"]; id != srv.actions[0]["index"]["_id"] {
+		t.Errorf("the same url got ids %s and %s", srv.actions[0]["index"]["_id"], id)
+	}
+}
+
+func TestElasticIndexesBuckets(t *testing.T) {
+	srv := newElasticServer(t)
+	s := newTestElastic(t, srv.URL, 10)
+	s.index = "mine"
+	s.apiKey = "key"
+	s.addBuckets([]Bucket{{Bucket: ghw.Bucket{Name: "a", ID: 1, FileCount: 3, Type: "aws"}, Region: "eu-west-1"}})
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.indices["mine"]; !ok {
+		t.Errorf("created indices %v, want mine", srv.indices)
+	}
+	if len(srv.docs) != 1 || srv.actions[0]["index"]["_id"] != "a" || srv.docs[0]["region"] != "eu-west-1" {
+		t.Errorf("sent %v %v", srv.actions, srv.docs)
+	}
+	if srv.auth[0] != "ApiKey key" {
+		t.Errorf("sent Authorization %q", srv.auth[0])
+	}
+}
+
+func TestElasticKeepsFailedBatches(t *testing.T) {
+	srv := newElasticServer(t)
+	srv.bulkCode = http.StatusBadRequest
+	s := newTestElastic(t, srv.URL, 1)
+	s.addBuckets([]Bucket{{Bucket: ghw.Bucket{Name: "a"}}, {Bucket: ghw.Bucket{Name: "b"}}})
+	if s.err == nil || sinkFailure() == nil {
+		t.Fatal("a failed bulk went unreported")
+	}
+
+	var batches []pendingBatch
+	n, err := sendPending("es", srv.URL, func(b pendingBatch) error {
+		batches = append(batches, b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(batches) != 2 || batches[0].Index != "bucketsearch-buckets" || batches[0].Kind != "buckets" ||
+		!strings.Contains(batches[1].Body, `"_id":"b"`) {
+		t.Errorf("kept %d documents in %+v", n, batches)
+	}
+}
@@ -0,0 +1,588 @@
+// Package ghw is a client for the buckets.grayhatwarfare.com v2 API.
+//
+//	c := ghw.NewClient(os.Getenv("GHW_API_KEY"))
+//	resp, err := c.SearchFiles(ctx, ghw.FilesQuery{Keywords: "backup", Extensions: "sql"})
+package ghw
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultBaseURL = "https://buckets.grayhatwarfare.com/api/v2"
+
+type File struct {
+	ID           any    `json:"id"`
+	Bucket       string `json:"bucket"`
+	BucketID     any    `json:"bucketId"`
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	Size         int64  `json:"size"`
+	Type         string `json:"type"`
+	LastModified int64  `json:"lastModified"`
+}
+
+type FilesResponse struct {
+	Files []File `json:"files"`
+	Meta  Meta   `json:"meta"`
+}
+
+type Bucket struct {
+	ID        any    `json:"id"`
+	Name      string `json:"bucket"`
+	FileCount int    `json:"fileCount"`
+	Type      string `json:"type"`
+}
+
+type BucketsResponse struct {
+	Buckets []Bucket `json:"buckets"`
+	Meta    Meta     `json:"meta"`
+}
+
+type Meta struct {
+	Results int `json:"results"`
+}
+
+type Stats struct {
+	FilesCount int64 `json:"filesCount"`
+	AwsCount   int   `json:"awsCount"`
+	AzureCount int   `json:"azureCount"`
+	DosCount   int   `json:"dosCount"`
+	GcpCount   int   `json:"gcpCount"`
+	AliCount   int   `json:"aliCount"`
+}
+
+type StatsResponse struct {
+	Stats Stats `json:"stats"`
+}
+
+// FilesQuery holds the /files search parameters. Extensions and
+// StopExtensions are comma separated; zero values are not sent.
+type FilesQuery struct {
+	Keywords       string
+	Bucket         string
+	Extensions     string
+	StopExtensions string
+	Start          int
+	Limit          int
+}
+
+// BucketsQuery holds the /buckets search parameters; zero values are not sent.
+type BucketsQuery struct {
+	Keywords string
+	Type     string
+	Start    int
+	Limit    int
+}
+
+// Errors a *StatusError matches with errors.Is, for the statuses callers
+// usually handle differently.
+var (
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded is the 429 of a key with no requests left until
+	// its quota resets, which waiting a moment doesn't help.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrNotFound      = errors.New("not found")
+	// ErrBadRequest is the api rejecting a parameter.
+	ErrBadRequest = errors.New("bad request")
+)
+
+// StatusError is returned when the API answers with anything but 200.
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is how long the API asked to wait, from Retry-After or,
+	// on 429, X-RateLimit-Reset; zero when it didn't say.
+	RetryAfter time.Duration
+	// Code and Message are the error and message of the json body the
+	// API explains the status with, empty when it sent none.
+	Code    string
+	Message string
+	// OutOfQuota is set on a 429 reporting no requests left.
+	OutOfQuota bool
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Code
+	}
+	if msg == "" {
+		return fmt.Sprintf("http %d", e.StatusCode)
+	}
+	return fmt.Sprintf("http %d: %s", e.StatusCode, msg)
+}
+
+// Is makes errors.Is(err, ErrRateLimited) and friends work; 403 counts as
+// unauthorized, the api sends it for keys without access.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrQuotaExceeded:
+		return e.StatusCode == http.StatusTooManyRequests && e.OutOfQuota
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed when sent again.
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RateLimit is the request quota reported by the X-RateLimit-* headers.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+type Client struct {
+	APIKey string
+	// Keys, when set, are used in turn instead of APIKey to pool their
+	// quota, each tracked on its own. A key that is rate limited or out of
+	// quota is set aside until its reset, one the api rejects with 401 for
+	// the rest of the run and one it answers 403 for ForbiddenRest, and
+	// the request goes out again right away with the next key.
+	Keys       []string
+	BaseURL    string
+	HTTPClient *http.Client
+	// Timeout bounds each request attempt on its own, so retries get a
+	// fresh one; a deadline on the context bounds the whole call.
+	Timeout time.Duration
+
+	// Retries is how many times a request failing with a network error or
+	// a temporary status is sent again, waiting exponentially longer with
+	// jitter each time, at most RetryMaxWait (30s when zero), which also
+	// caps a Retry-After.
+	Retries      int
+	RetryMaxWait time.Duration
+	// OnRetry, if set, is called before each retry.
+	OnRetry func(attempt int, err error, wait time.Duration)
+	// Limiter, if set, paces every request of the client, retries
+	// included. A 429 no other key can take over from slows an adaptive
+	// one down.
+	Limiter *Limiter
+
+	mu sync.Mutex
+	// rateLimits holds the latest quota reported for each key
+	rateLimits map[string]RateLimit
+	nextKey    int
+	// keyRested holds when a set aside key may be used again
+	keyRested map[string]time.Time
+}
+
+// ForbiddenRest is how long a key the api answers 403 for is set aside.
+// A 403 may be a plan not covering one endpoint rather than a dead key, so
+// the key gets another chance later in the run.
+const ForbiddenRest = 15 * time.Minute
+
+// NewClient returns a client for the public API with a 15s request timeout
+// and 3 retries.
+func NewClient(apiKey string) *Client {
+	return &Client{
+		APIKey:       apiKey,
+		BaseURL:      DefaultBaseURL,
+		HTTPClient:   &http.Client{},
+		Timeout:      15 * time.Second,
+		Retries:      3,
+		RetryMaxWait: 30 * time.Second,
+	}
+}
+
+// SearchFiles fetches one page of files matching q.
+func (c *Client) SearchFiles(ctx context.Context, q FilesQuery) (*FilesResponse, error) {
+	var resp FilesResponse
+	if err := c.getJSON(ctx, "/files", filesParams(q), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SearchBuckets fetches one page of buckets matching q.
+func (c *Client) SearchBuckets(ctx context.Context, q BucketsQuery) (*BucketsResponse, error) {
+	var resp BucketsResponse
+	if err := c.getJSON(ctx, "/buckets", bucketsParams(q), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FilesURL is the url SearchFiles requests for q, without sending it.
+func (c *Client) FilesURL(q FilesQuery) string {
+	return c.url("/files", filesParams(q))
+}
+
+// BucketsURL is the url SearchBuckets requests for q, without sending it.
+func (c *Client) BucketsURL(q BucketsQuery) string {
+	return c.url("/buckets", bucketsParams(q))
+}
+
+func filesParams(q FilesQuery) url.Values {
+	params := url.Values{}
+	setParam(params, "keywords", q.Keywords)
+	setParam(params, "bucket", q.Bucket)
+	setParam(params, "extensions", q.Extensions)
+	setParam(params, "stopextensions", q.StopExtensions)
+	setIntParam(params, "start", q.Start)
+	setIntParam(params, "limit", q.Limit)
+	return params
+}
+
+func bucketsParams(q BucketsQuery) url.Values {
+	params := url.Values{}
+	setParam(params, "keywords", q.Keywords)
+	setParam(params, "type", q.Type)
+	setIntParam(params, "start", q.Start)
+	setIntParam(params, "limit", q.Limit)
+	return params
+}
+
+func (c *Client) url(path string, params url.Values) string {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	u := base + path
+	if len(params) > 0 && strings.Contains(u, "?") {
+		u += "&" + params.Encode()
+	} else if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u
+}
+
+// Stats fetches the global index statistics.
+func (c *Client) Stats(ctx context.Context) (*StatsResponse, error) {
+	var resp StatsResponse
+	if err := c.getJSON(ctx, "/stats", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Get sends an authenticated GET for an arbitrary API path such as "/files"
+// and returns the raw response body, retrying temporary failures until ctx
+// is done. A key out of quota with no other key free fails right away
+// with ErrQuotaExceeded.
+func (c *Client) Get(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	u, err := url.Parse(c.url(path, params))
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		// the wait for a slot is not part of the attempt, which
+		// c.Timeout bounds
+		if err := c.Limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		data, key, err := c.get(ctx, u.String())
+		if err != nil && ctx.Err() == nil && c.rotate(key, err) {
+			// another key is free, which is no reason to wait
+			attempt--
+			continue
+		}
+		if errors.Is(err, ErrRateLimited) {
+			c.Limiter.slowDown()
+		}
+		if err == nil || attempt > c.Retries || !retryable(err) || ctx.Err() != nil {
+			return data, err
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			// no other key is free and this one is out until its reset
+			return nil, err
+		}
+		wait := c.backoff(attempt)
+		if se, ok := err.(*StatusError); ok && se.RetryAfter > 0 {
+			// the api knows best when it takes requests again, as long as
+			// that is within RetryMaxWait
+			wait = se.RetryAfter
+			if limit := c.maxWait(); wait > limit {
+				wait = limit
+			}
+		}
+		if c.OnRetry != nil {
+			c.OnRetry(attempt, err, wait)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func retryable(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		return se.Temporary()
+	}
+	// anything else went wrong on the way to or from the api
+	return true
+}
+
+// backoff returns a random wait between half and all of 2^(attempt-1)
+// seconds, capped at RetryMaxWait (30s when zero).
+func (c *Client) backoff(attempt int) time.Duration {
+	limit := c.maxWait()
+	if attempt <= 30 && time.Second<<(attempt-1) < limit {
+		limit = time.Second << (attempt - 1)
+	}
+	return limit/2 + time.Duration(rand.Int63n(int64(limit/2)+1))
+}
+
+func (c *Client) maxWait() time.Duration {
+	if c.RetryMaxWait <= 0 {
+		return 30 * time.Second
+	}
+	return c.RetryMaxWait
+}
+
+// key picks the api key of the next request: APIKey, or the next of Keys
+// that isn't set aside. When all are, it is the one free again first.
+func (c *Client) key() string {
+	if len(c.Keys) == 0 {
+		return c.APIKey
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	best := ""
+	for i := 0; i < len(c.Keys); i++ {
+		k := c.Keys[(c.nextKey+i)%len(c.Keys)]
+		until, rested := c.keyRested[k]
+		if !rested || now.After(until) {
+			c.nextKey = (c.nextKey + i + 1) % len(c.Keys)
+			return k
+		}
+		if best == "" || until.Before(c.keyRested[best]) {
+			best = k
+		}
+	}
+	return best
+}
+
+// rest sets key aside until the given time.
+func (c *Client) rest(key string, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keyRested == nil {
+		c.keyRested = map[string]time.Time{}
+	}
+	c.keyRested[key] = until
+}
+
+// rotate sets aside a key that failed with err because of its quota or
+// access and reports whether another key is free to send the request with.
+func (c *Client) rotate(key string, err error) bool {
+	if len(c.Keys) < 2 {
+		return false
+	}
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch {
+	case errors.Is(se, ErrRateLimited):
+		wait := se.RetryAfter
+		if wait <= 0 {
+			wait = time.Minute
+		}
+		c.rest(key, time.Now().Add(wait))
+	case se.StatusCode == http.StatusForbidden:
+		c.rest(key, time.Now().Add(ForbiddenRest))
+	case errors.Is(se, ErrUnauthorized):
+		// an invalid key stays invalid
+		c.rest(key, time.Now().Add(100*365*24*time.Hour))
+	default:
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, k := range c.Keys {
+		if until, rested := c.keyRested[k]; !rested || now.After(until) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) get(ctx context.Context, urlStr string) ([]byte, string, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	key := c.key()
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, key, err
+	}
+	defer resp.Body.Close()
+	rl, hasRL := parseRateLimit(resp.Header)
+	if hasRL {
+		c.mu.Lock()
+		if c.rateLimits == nil {
+			c.rateLimits = map[string]RateLimit{}
+		}
+		c.rateLimits[key] = rl
+		c.mu.Unlock()
+		if len(c.Keys) > 1 && resp.StatusCode == http.StatusOK && resp.Header.Get("X-RateLimit-Remaining") != "" && rl.Remaining <= 0 && !rl.Reset.IsZero() {
+			// that was the last request of this key's quota
+			c.rest(key, rl.Reset)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		se := &StatusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		se.Code, se.Message = parseErrorBody(body)
+		if resp.StatusCode == http.StatusTooManyRequests && hasRL && resp.Header.Get("X-RateLimit-Remaining") != "" && rl.Remaining <= 0 {
+			se.OutOfQuota = true
+		}
+		if se.RetryAfter == 0 && resp.StatusCode == http.StatusTooManyRequests && hasRL && !rl.Reset.IsZero() {
+			se.RetryAfter = time.Until(rl.Reset)
+		}
+		if se.RetryAfter < 0 {
+			se.RetryAfter = 0
+		}
+		return nil, key, se
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, key, err
+}
+
+// parseErrorBody picks the error code and message out of a json error
+// body like {"error":"Unauthorized","message":"Invalid api key"}.
+func parseErrorBody(data []byte) (code, message string) {
+	var body map[string]any
+	if json.Unmarshal(data, &body) != nil {
+		return "", ""
+	}
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := body[k].(string); ok && v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if nested, ok := body["error"].(map[string]any); ok {
+		// {"error": {"code": ..., "message": ...}}
+		body = nested
+		return str("code", "type"), str("message", "detail")
+	}
+	return str("error", "code"), str("message", "detail", "error_description")
+}
+
+// RateLimit returns the quota the api reported, if it sent one: with
+// several keys their limits and remaining requests added up and the
+// earliest reset.
+func (c *Client) RateLimit() (RateLimit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sum RateLimit
+	for _, rl := range c.rateLimits {
+		sum.Limit += rl.Limit
+		sum.Remaining += rl.Remaining
+		if sum.Reset.IsZero() || (!rl.Reset.IsZero() && rl.Reset.Before(sum.Reset)) {
+			sum.Reset = rl.Reset
+		}
+	}
+	return sum, len(c.rateLimits) > 0
+}
+
+// KeyRateLimit returns the quota last reported for one key.
+func (c *Client) KeyRateLimit(key string) (RateLimit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rl, ok := c.rateLimits[key]
+	return rl, ok
+}
+
+// retryAfter parses a Retry-After value in seconds or as an http date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func parseRateLimit(h http.Header) (RateLimit, bool) {
+	limit, err1 := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err1 != nil && err2 != nil {
+		return RateLimit{}, false
+	}
+	rl := RateLimit{Limit: limit, Remaining: remaining}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		// either a unix time or seconds from now
+		if reset > 1e9 {
+			rl.Reset = time.Unix(reset, 0)
+		} else {
+			rl.Reset = time.Now().Add(time.Duration(reset) * time.Second)
+		}
+	}
+	return rl, true
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, params url.Values, v any) error {
+	data, err := c.Get(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	return nil
+}
+
+func setParam(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
+
+func setIntParam(params url.Values, key string, value int) {
+	if value > 0 {
+		params.Set(key, strconv.Itoa(value))
+	}
+}
@@ -0,0 +1,231 @@
+package ghw_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+	"github.com/dogadmin/bucketsearch/ghw/ghwtest"
+)
+
+func TestGetRetriesTemporaryFailures(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.FailNext(2, http.StatusBadGateway, 0)
+	c := s.Client()
+	var retries int
+	c.OnRetry = func(attempt int, err error, wait time.Duration) { retries++ }
+
+	resp, err := c.SearchFiles(context.Background(), ghw.FilesQuery{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Files) != 5 || resp.Meta.Results != len(s.Files) {
+		t.Errorf("got %d files of %d, want 5 of %d", len(resp.Files), resp.Meta.Results, len(s.Files))
+	}
+	if retries != 2 || len(s.Requests()) != 3 {
+		t.Errorf("got %d retries and %d requests, want 2 and 3", retries, len(s.Requests()))
+	}
+}
+
+func TestGetGivesUpAfterRetries(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.FailNext(10, http.StatusServiceUnavailable, 0)
+	c := s.Client()
+	c.Retries = 2
+
+	_, err := c.Get(context.Background(), "/files", nil)
+	var se *ghw.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want a 503 StatusError", err)
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestGetDoesNotRetryPermanentFailures(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.FailNext(1, http.StatusBadRequest, 0)
+
+	_, err := s.Client().Get(context.Background(), "/files", nil)
+	if !errors.Is(err, ghw.ErrBadRequest) {
+		t.Fatalf("got %v, want ErrBadRequest", err)
+	}
+	if n := len(s.Requests()); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestGetStopsWithContext(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.FailNext(10, http.StatusBadGateway, 0)
+	c := s.Client()
+	c.RetryMaxWait = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.Get(ctx, "/files", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context deadline", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s to stop", d)
+	}
+}
+
+func TestGetCapsRetryAfter(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.FailNext(1, http.StatusTooManyRequests, time.Hour)
+	c := s.Client()
+	var waited time.Duration
+	c.OnRetry = func(attempt int, err error, wait time.Duration) { waited = wait }
+
+	if _, err := c.Get(context.Background(), "/files", nil); err != nil {
+		t.Fatal(err)
+	}
+	if waited != c.RetryMaxWait {
+		t.Errorf("waited %s, want RetryMaxWait %s", waited, c.RetryMaxWait)
+	}
+}
+
+func TestGetFailsFastOutOfQuota(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.SetQuota(1, time.Hour)
+	c := s.Client()
+	var retries int
+	c.OnRetry = func(attempt int, err error, wait time.Duration) { retries++ }
+
+	if _, err := c.Get(context.Background(), "/files", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rl, ok := c.RateLimit(); !ok || rl.Limit != 1 || rl.Remaining != 0 {
+		t.Errorf("got rate limit %+v, %v, want 0 of 1 left", rl, ok)
+	}
+	_, err := c.Get(context.Background(), "/files", nil)
+	if !errors.Is(err, ghw.ErrQuotaExceeded) {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+	if retries != 0 {
+		t.Errorf("got %d retries, want none", retries)
+	}
+}
+
+func TestGetRotatesKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"rate limited", http.StatusTooManyRequests},
+		{"forbidden", http.StatusForbidden},
+		{"unauthorized", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ghwtest.NewServer()
+			defer s.Close()
+			s.APIKey = ""
+			s.FailNext(1, tt.status, 0)
+			c := s.Client()
+			c.Keys = []string{"a", "b"}
+			var retries int
+			c.OnRetry = func(attempt int, err error, wait time.Duration) { retries++ }
+
+			if _, err := c.Get(context.Background(), "/files", nil); err != nil {
+				t.Fatal(err)
+			}
+			if retries != 0 {
+				t.Errorf("got %d retries, want the next key right away", retries)
+			}
+			// a is set aside, so the next requests all go out with b
+			for i := 0; i < 2; i++ {
+				if _, err := c.Get(context.Background(), "/files", nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := len(s.Requests()); n != 4 {
+				t.Errorf("got %d requests, want 4", n)
+			}
+		})
+	}
+}
+
+func TestGetRotatesOnInvalidKey(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	c.Keys = []string{"bad", s.APIKey}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(context.Background(), "/files", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	// one 401 for the bad key, then it stays set aside
+	if n := len(s.Requests()); n != 4 {
+		t.Errorf("got %d requests, want 4", n)
+	}
+}
+
+func TestGetPoolsQuotaOfKeys(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	s.APIKey = ""
+	// the server counts one quota for all keys, but a key that used up
+	// its last request must not be sent again before the reset
+	s.SetQuota(2, time.Hour)
+	c := s.Client()
+	c.Keys = []string{"a", "b"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(context.Background(), "/files", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if rl, ok := c.KeyRateLimit("b"); !ok || rl.Remaining != 0 {
+		t.Errorf("got rate limit %+v, %v for b, want none left", rl, ok)
+	}
+	if _, err := c.Get(context.Background(), "/files", nil); !errors.Is(err, ghw.ErrQuotaExceeded) {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestFilesIter(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+
+	var urls []string
+	it := s.Client().Files(context.Background(), ghw.FilesQuery{Limit: 7}).Iter()
+	for it.Next() {
+		urls = append(urls, it.Value().URL)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != len(s.Files) {
+		t.Fatalf("got %d files, want %d", len(urls), len(s.Files))
+	}
+	for i, u := range urls {
+		if u != s.Files[i].URL {
+			t.Errorf("file %d is %s, want %s", i, u, s.Files[i].URL)
+		}
+	}
+	if n := len(s.Requests()); n != 4 {
+		t.Errorf("got %d requests, want 4 pages", n)
+	}
+	for _, r := range s.Requests() {
+		if !strings.Contains(r, "limit=7") {
+			t.Errorf("request %s doesn't ask for pages of 7", r)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package ghwtest runs an in-memory fake of the GrayhatWarfare v2 API for
+// testing code built on ghw without network access.
+//
+//	srv := ghwtest.NewServer()
+//	defer srv.Close()
+//	srv.FailNext(2, http.StatusServiceUnavailable, 0)
+//	resp, err := srv.Client().SearchFiles(ctx, ghw.FilesQuery{Keywords: "backup"})
+package ghwtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+// APIKey is the key the server accepts unless Server.APIKey is changed.
+const APIKey = "test-key"
+
+// Server serves /files, /buckets and /stats from its fixtures, paginated
+// by start and limit like the real API. Fields may be changed between
+// requests.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// APIKey is the accepted bearer token; requests with another one get
+	// 401. Empty accepts anything.
+	APIKey  string
+	Files   []ghw.File
+	Buckets []ghw.Bucket
+	Stats   ghw.Stats
+	// MaxLimit caps the page size, 1000 when zero.
+	MaxLimit int
+
+	quota     int
+	remaining int
+	reset     time.Duration
+	resetAt   time.Time
+	failures  []failure
+	requests  []string
+}
+
+type failure struct {
+	status     int
+	retryAfter time.Duration
+}
+
+// NewServer starts a server loaded with Files, Buckets and Stats. Close it
+// when done.
+func NewServer() *Server {
+	s := &Server{APIKey: APIKey, Files: Files(), Buckets: Buckets(), Stats: Stats()}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Client returns a client for the server with the accepted key and
+// retries that wait milliseconds rather than seconds.
+func (s *Server) Client() *ghw.Client {
+	c := ghw.NewClient(s.APIKey)
+	c.BaseURL = s.URL
+	c.RetryMaxWait = 10 * time.Millisecond
+	return c
+}
+
+// FailNext makes the next n requests fail with status, sending
+// Retry-After when retryAfter is positive.
+func (s *Server) FailNext(n, status int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status, retryAfter})
+	}
+}
+
+// SetQuota reports a quota of limit requests through the X-RateLimit
+// headers; once it is used up requests get 429 until reset has passed,
+// after which it starts over. A limit of 0 turns the headers off.
+func (s *Server) SetQuota(limit int, reset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quota, s.remaining, s.reset, s.resetAt = limit, limit, reset, time.Time{}
+}
+
+// Requests returns the path and query of every request received so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+
+	if s.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.APIKey {
+		writeError(w, http.StatusUnauthorized, "Invalid api key")
+		return
+	}
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((f.retryAfter+time.Second-1)/time.Second)))
+		}
+		writeError(w, f.status, http.StatusText(f.status))
+		return
+	}
+	if s.quota > 0 {
+		if s.resetAt.IsZero() || time.Now().After(s.resetAt) {
+			s.remaining, s.resetAt = s.quota, time.Now().Add(s.reset)
+		}
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(s.quota))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(int(time.Until(s.resetAt).Seconds()+0.999)))
+		if s.remaining == 0 {
+			h.Set("X-RateLimit-Remaining", "0")
+			writeError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		s.remaining--
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+	}
+
+	q := r.URL.Query()
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/files":
+		var files []ghw.File
+		for _, f := range s.Files {
+			if matchFile(f, q.Get("keywords"), q.Get("bucket"), q.Get("extensions"), q.Get("stopextensions")) {
+				files = append(files, f)
+			}
+		}
+		start, end := s.page(q, len(files))
+		writeJSON(w, ghw.FilesResponse{Files: append([]ghw.File{}, files[start:end]...), Meta: ghw.Meta{Results: len(files)}})
+	case "/buckets":
+		var buckets []ghw.Bucket
+		for _, b := range s.Buckets {
+			if contains(b.Name, q.Get("keywords")) && (q.Get("type") == "" || b.Type == q.Get("type")) {
+				buckets = append(buckets, b)
+			}
+		}
+		start, end := s.page(q, len(buckets))
+		writeJSON(w, ghw.BucketsResponse{Buckets: append([]ghw.Bucket{}, buckets[start:end]...), Meta: ghw.Meta{Results: len(buckets)}})
+	case "/stats":
+		writeJSON(w, ghw.StatsResponse{Stats: s.Stats})
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// page returns the slice bounds for the start and limit parameters.
+func (s *Server) page(q map[string][]string, n int) (start, end int) {
+	max := s.MaxLimit
+	if max <= 0 {
+		max = 1000
+	}
+	start = atoi(q["start"])
+	limit := atoi(q["limit"])
+	if limit <= 0 || limit > max {
+		limit = max
+	}
+	if start > n {
+		start = n
+	}
+	end = start + limit
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+func atoi(v []string) int {
+	if len(v) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(v[0])
+	return n
+}
+
+func matchFile(f ghw.File, keywords, bucket, exts, stopExts string) bool {
+	if !contains(f.Name, keywords) || bucket != "" && f.Bucket != bucket {
+		return false
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(f.Name), "."))
+	if exts != "" && !inList(ext, exts) {
+		return false
+	}
+	return stopExts == "" || !inList(ext, stopExts)
+}
+
+// contains reports whether every space separated keyword is in s.
+func contains(s, keywords string) bool {
+	for _, k := range strings.Fields(keywords) {
+		if !strings.Contains(strings.ToLower(s), strings.ToLower(k)) {
+			return false
+		}
+	}
+	return true
+}
+
+func inList(ext, list string) bool {
+	for _, e := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(e), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status), "message": message})
+}
+
+// Files returns the canned files: 25 across three buckets with a mix of
+// extensions, sizes and dates.
+func Files() []ghw.File {
+	exts := []string{"sql", "pdf", "txt", "env", "zip"}
+	names := []string{"backup", "report", "notes", "config", "archive"}
+	files := make([]ghw.File, 25)
+	for i := range files {
+		bucket := fmt.Sprintf("acme-%s.s3.amazonaws.com", []string{"prod", "dev", "logs"}[i%3])
+		name := fmt.Sprintf("dir%d/%s-%d.%s", i%4, names[i%5], i, exts[i%5])
+		files[i] = ghw.File{
+			ID:           float64(i + 1),
+			Bucket:       bucket,
+			BucketID:     float64(i%3 + 1),
+			Name:         name,
+			URL:          "https://" + bucket + "/" + name,
+			Size:         int64(i+1) * 4096,
+			Type:         "aws",
+			LastModified: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix() + int64(i)*86400*7,
+		}
+	}
+	return files
+}
+
+// Buckets returns the canned buckets: 12 across aws, azure and gcp.
+func Buckets() []ghw.Bucket {
+	buckets := make([]ghw.Bucket, 12)
+	for i := range buckets {
+		typ := []string{"aws", "azure", "gcp"}[i%3]
+		buckets[i] = ghw.Bucket{
+			ID:        float64(i + 1),
+			Name:      fmt.Sprintf("acme-%s-%d", []string{"prod", "dev", "logs", "backup"}[i%4], i),
+			FileCount: (i + 1) * 100,
+			Type:      typ,
+		}
+	}
+	return buckets
+}
+
+// Stats returns the canned index statistics.
+func Stats() ghw.Stats {
+	return ghw.Stats{FilesCount: 25, AwsCount: 4, AzureCount: 4, GcpCount: 4}
+}
@@ -0,0 +1,127 @@
+package ghw
+
+import "context"
+
+// DefaultPageSize is the page size Files and Buckets use when the query
+// has no Limit.
+const DefaultPageSize = 1000
+
+// Pager walks all pages of one search, starting at the query's Start.
+//
+//	it := c.Files(ctx, ghw.FilesQuery{Keywords: "backup"}).Iter()
+//	for it.Next() {
+//		fmt.Println(it.Value().URL)
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Pager[T any] struct {
+	ctx   context.Context
+	start int
+	limit int
+	fetch func(ctx context.Context, start, limit int) (items []T, total int, err error)
+}
+
+// Files returns a pager over every file matching q; q.Limit is the page
+// size. Nothing is requested until the results are read.
+func (c *Client) Files(ctx context.Context, q FilesQuery) *Pager[File] {
+	return newPager(ctx, q.Start, q.Limit, func(ctx context.Context, start, limit int) ([]File, int, error) {
+		q.Start, q.Limit = start, limit
+		resp, err := c.SearchFiles(ctx, q)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Files, resp.Meta.Results, nil
+	})
+}
+
+// Buckets returns a pager over every bucket matching q; q.Limit is the
+// page size.
+func (c *Client) Buckets(ctx context.Context, q BucketsQuery) *Pager[Bucket] {
+	return newPager(ctx, q.Start, q.Limit, func(ctx context.Context, start, limit int) ([]Bucket, int, error) {
+		q.Start, q.Limit = start, limit
+		resp, err := c.SearchBuckets(ctx, q)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Buckets, resp.Meta.Results, nil
+	})
+}
+
+func newPager[T any](ctx context.Context, start, limit int, fetch func(context.Context, int, int) ([]T, int, error)) *Pager[T] {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	return &Pager[T]{ctx: ctx, start: start, limit: limit, fetch: fetch}
+}
+
+// Iter returns an iterator fetching the next page whenever the current one
+// is used up. Each call starts over from the first page.
+func (p *Pager[T]) Iter() *Iterator[T] {
+	return &Iterator[T]{p: p, offset: p.start}
+}
+
+// Chan sends every result on the returned channel, which is closed at the
+// end, on the first error or when the context is done. err reports why it
+// ended and is only meaningful once the channel is closed.
+func (p *Pager[T]) Chan() (results <-chan T, err func() error) {
+	ch := make(chan T)
+	it := p.Iter()
+	go func() {
+		defer close(ch)
+		for it.Next() {
+			select {
+			case ch <- it.Value():
+			case <-p.ctx.Done():
+				it.err = p.ctx.Err()
+				return
+			}
+		}
+	}()
+	return ch, it.Err
+}
+
+// Iterator yields the results of a Pager one at a time.
+type Iterator[T any] struct {
+	p      *Pager[T]
+	page   []T
+	i      int
+	offset int
+	done   bool
+	err    error
+	cur    T
+}
+
+// Next advances to the next result, fetching a page if needed. It returns
+// false when the results are exhausted or a request failed; see Err.
+func (it *Iterator[T]) Next() bool {
+	for it.i >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		if it.err = it.p.ctx.Err(); it.err != nil {
+			return false
+		}
+		items, total, err := it.p.fetch(it.p.ctx, it.offset, it.p.limit)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.i = items, 0
+		it.offset += it.p.limit
+		if len(items) < it.p.limit || total > 0 && it.offset >= total {
+			it.done = true
+		}
+	}
+	it.cur = it.page[it.i]
+	it.i++
+	return true
+}
+
+// Value returns the current result.
+func (it *Iterator[T]) Value() T {
+	return it.cur
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
@@ -0,0 +1,120 @@
+package ghw
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket spacing requests out to a rate, shared by all
+// goroutines sending through the client. An adaptive one, with no rate set,
+// lets every request through until the api answers 429 and then settles
+// below the rate the requests were going at. Its methods are safe on a nil
+// Limiter, which limits nothing.
+type Limiter struct {
+	// OnSlowDown, if set, is called when a 429 lowers an adaptive rate.
+	OnSlowDown func(perSecond float64)
+
+	mu       sync.Mutex
+	rate     float64
+	adaptive bool
+	tokens   float64
+	last     time.Time
+	slowed   time.Time
+	// sent holds the send times of the last minute, for the observed rate
+	sent []time.Time
+}
+
+// NewLimiter returns a limiter allowing perSecond requests a second, or
+// an adaptive one when perSecond is 0.
+func NewLimiter(perSecond float64) *Limiter {
+	return &Limiter{rate: perSecond, adaptive: perSecond <= 0, tokens: 1}
+}
+
+// Rate is the current rate in requests a second, 0 while unlimited.
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait blocks until the next request may go out or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.adaptive {
+		cut := 0
+		for cut < len(l.sent) && now.Sub(l.sent[cut]) > time.Minute {
+			cut++
+		}
+		l.sent = append(l.sent[cut:], now)
+	}
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	// refill, at most one token so requests never bunch up, and take one;
+	// below zero the request has a reserved slot that far ahead
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		// the request won't go out, so its slot is free again
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// slowDown lowers an adaptive rate to 80% of what was sent over the last
+// minute, once per 10s so a burst of 429s counts as one.
+func (l *Limiter) slowDown() {
+	if l == nil || !l.adaptive {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.slowed) < 10*time.Second || len(l.sent) == 0 {
+		l.mu.Unlock()
+		return
+	}
+	l.slowed = now
+	span := now.Sub(l.sent[0]).Seconds()
+	if span < 1 {
+		span = 1
+	}
+	observed := float64(len(l.sent)) / span
+	if l.rate > 0 && l.rate < observed {
+		observed = l.rate
+	}
+	l.rate = observed * 0.8
+	if l.rate < 1.0/60 {
+		l.rate = 1.0 / 60
+	}
+	l.tokens, l.last = 0, now
+	rate := l.rate
+	l.mu.Unlock()
+	if l.OnSlowDown != nil {
+		l.OnSlowDown(rate)
+	}
+}
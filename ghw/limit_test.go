@@ -0,0 +1,88 @@
+package ghw
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterSpacesRequests(t *testing.T) {
+	l := NewLimiter(20)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first goes out right away, the other four 50ms apart
+	if d := time.Since(start); d < 190*time.Millisecond {
+		t.Errorf("5 requests at 20/s took %s, want at least 200ms", d)
+	}
+}
+
+func TestLimiterNil(t *testing.T) {
+	var l *Limiter
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r := l.Rate(); r != 0 {
+		t.Errorf("nil limiter rate %v, want 0", r)
+	}
+	l.slowDown()
+}
+
+func TestLimiterCancelReturnsSlot(t *testing.T) {
+	l := NewLimiter(10)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	}
+	// the cancelled waits gave their slots back, so the next request only
+	// waits for the one after the first
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("waited %s after cancelled waits, want at most 100ms", d)
+	}
+}
+
+func TestLimiterAdaptive(t *testing.T) {
+	l := NewLimiter(0)
+	var slowed float64
+	l.OnSlowDown = func(perSecond float64) { slowed = perSecond }
+	for i := 0; i < 50; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := l.Rate(); r != 0 {
+		t.Fatalf("adaptive rate %v before any 429, want 0", r)
+	}
+	// 50 requests within the first second count as 50/s
+	l.slowDown()
+	if r := l.Rate(); r != 40 || slowed != 40 {
+		t.Errorf("rate %v and OnSlowDown %v after a 429, want 40", r, slowed)
+	}
+	// a second 429 right after is part of the same burst
+	l.slowDown()
+	if r := l.Rate(); r != 40 {
+		t.Errorf("rate %v after a second 429, want 40", r)
+	}
+}
+
+func TestLimiterFixedRateIgnoresSlowDown(t *testing.T) {
+	l := NewLimiter(5)
+	l.slowDown()
+	if r := l.Rate(); r != 5 {
+		t.Errorf("rate %v, want 5", r)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/dogadmin/bucketsearch
+
+go 1.20
+
+require (
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/net v0.35.0
+	golang.org/x/text v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// lang is the language of progress and status messages: -lang, or the
+// locale of LC_ALL, LC_MESSAGES or LANG. Errors stay in English.
+var lang = localeLang()
+
+func localeLang() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			if strings.HasPrefix(strings.ToLower(v), "zh") {
+				return "zh"
+			}
+			return "en"
+		}
+	}
+	return "en"
+}
+
+func addLangFlag(fs *flag.FlagSet) {
+	fs.Func("lang", "Language of progress and status messages: en|zh (default from LANG)", func(v string) error {
+		v = strings.ToLower(v)
+		if _, ok := catalogs[v]; !ok && v != "en" {
+			return errors.New("want en or zh")
+		}
+		lang = v
+		return nil
+	})
+}
+
+// catalogs translate the status messages, keyed by their English format.
+// A translation can reorder the arguments with %[n]d.
+var catalogs = map[string]map[string]string{
+	"zh": {
+		// progress line
+		"fetched %d":      "已获取 %d 条",
+		"fetched %d / %d": "已获取 %d / %d 条",
+		" %.0f/s":         " %.0f 条/秒",
+		" %d retries":     " 重试 %d 次",
+		" quota left %d":  " 剩余配额 %d",
+
+		// requests
+		"rate limited, slowing down to %s (set -rate to choose one)": "被限速，降到 %s（可以用 -rate 指定）",
+		"request error: %v, retry %d/%d in %s":                       "请求出错：%v，%[4]s 后第 %[2]d/%[3]d 次重试",
+
+		// paging
+		"interrupted, finishing the pages in flight (again to quit now)": "已中断，正在处理进行中的页（再按一次立即退出）",
+		"interrupted":              "已中断",
+		"%s reached":               "已达到 %s",
+		", %d files saved":         "，已保存 %d 个文件",
+		"%s, %d buckets saved":     "%s，已保存 %d 个 bucket",
+		"%s, stopped at offset %d": "%s，停在 offset %d",
+		"%s, stopped at offset %d of %s (%d of %d)":                  "%s，停在 %[3]s 的 offset %[2]d（第 %[4]d/%[5]d 个搜索）",
+		"%s, stopped at offset %d of keyword %q (%d of %d)":          "%s，停在关键词 %[3]q 的 offset %[2]d（第 %[4]d/%[5]d 个）",
+		"%s, stopped at offset %d; rerun with -start %d to continue": "%s，停在 offset %d；加 -start %d 重新运行可以继续",
+		" or add -resume to append to the same csv":                  "，或加 -resume 接着写同一个 csv",
+		"%d results suppressed":                                      "已屏蔽 %d 条结果",
+		"%d files have grown since indexing":                         "%d 个文件比索引时变大了",
+		"estimate: %d rows, %d requests, ~%s":                        "预估：%d 条，%d 次请求，约 %s",
+		"continue? [y/N] ":                                           "继续？[y/N] ",
+		"aborted":                                                    "已取消",
+		"baseline recorded, %d files":                                "已记录基线，%d 个文件",
+		"%d new files":                                               "%d 个新文件",
+		"dry run, no request is sent":                                "试运行，不发送任何请求",
+		"each search pages on with start=%d, %d, ... until the result count its first page reports": "每个搜索之后按 start=%d、%d……翻页，直到第一页返回的结果总数",
+		"requests: about %d, going by the %d results the local cache holds for these searches":      "请求数：约 %d 次，按本地缓存里这些搜索的 %d 条结果估算",
+		"requests: at least %d, one per search, and one more for every further %d results":          "请求数：至少 %d 次，每个搜索 1 次，结果每多 %d 条再加 1 次",
+		"-estimate counts the results first with one request per search":                            "-estimate 会先用每个搜索一次请求统计结果数",
+		"at most %d requests with -max-requests":                                                    "-max-requests 限制最多 %d 次请求",
+
+		// summaries
+		"completed":                                 "完成",
+		"completed, saved to %s":                    "完成，已保存到 %s",
+		"completed, %d files saved to %s":           "完成，%d 个文件保存在 %s",
+		"saved to %s":                               "已保存到 %s",
+		"%d results saved to %s":                    "%d 条结果已保存到 %s",
+		"backfill already complete: %d files in %s": "backfill 已经完成：%d 个文件在 %s",
+		"continuing at offset %d of %d":             "从 offset %d / %d 继续",
+		"%s at offset %d of %d, %d files saved; run again to continue": "%s，在 offset %d / %d，已保存 %d 个文件；再次运行可以继续",
+		"completed, %d files in %d slices saved to %s":                 "完成，%d 个文件分 %d 片保存在 %s",
+		"downloaded %d files (%s) to %s":                               "已下载 %d 个文件（%s）到 %s",
+		", %d matched yara rules":                                      "，%d 个命中 yara 规则",
+		"%s; manifest at %s":                                           "%s；清单在 %s",
+		"stats saved to %s":                                            "统计已保存到 %s",
+		"response saved to %s":                                         "响应已保存到 %s",
+		"draft saved to %s":                                            "草稿已保存到 %s",
+		"%d presets saved to %s":                                       "%d 个预设已保存到 %s",
+	},
+}
+
+// tr formats a status message in lang.
+func tr(format string, args ...any) string {
+	if t, ok := catalogs[lang][format]; ok {
+		format = t
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// table is an export read back as rows of named string cells. Column
+// names follow the csv header, sqlite and parquet names are converted to
+// them and times are RFC3339.
+type table struct {
+	kind    string // files or buckets
+	key     string // column identifying a row: url or bucket
+	columns []string
+	rows    []map[string]string
+}
+
+// loadTable reads a files or buckets export in any format written by -o:
+// csv, json, ndjson, sqlite or parquet, told apart by their content.
+func loadTable(path string) (*table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t *table
+	switch {
+	case bytes.HasPrefix(data, []byte("SQLite format 3\x00")):
+		t, err = loadSQLiteTable(path)
+	case bytes.HasPrefix(data, []byte("PAR1")):
+		t, err = loadParquetTable(data)
+	default:
+		data = bytes.TrimPrefix(data, []byte("\ufeff"))
+		// only the first byte tells, a csv cell may well start with [
+		switch trimmed := bytes.TrimSpace(data); {
+		case bytes.HasPrefix(trimmed, []byte("[")):
+			t, err = loadJSONTable(trimmed, false)
+		case bytes.HasPrefix(trimmed, []byte("{")):
+			t, err = loadJSONTable(trimmed, true)
+		default:
+			t, err = loadCSVTable(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, t.detect()
+}
+
+func loadCSVTable(data []byte) (*table, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = sniffDelimiter(data)
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty csv")
+	}
+	t := &table{columns: records[0]}
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, name := range t.columns {
+			if i < len(record) {
+				row[name] = unguardCell(record[i])
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
+
+// sniffDelimiter tells the -delimiter a csv was written with from its
+// header line: whichever of tab, ; and | appears there more often than
+// the comma, outside quotes.
+func sniffDelimiter(data []byte) rune {
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	counts := map[rune]int{}
+	quoted := false
+	for _, c := range string(header) {
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted {
+			counts[c]++
+		}
+	}
+	comma := ','
+	for _, c := range []rune{'\t', ';', '|'} {
+		if counts[c] > counts[comma] {
+			comma = c
+		}
+	}
+	return comma
+}
+
+// loadJSONTable reads a json array or, with lines, one object per line.
+// Columns are the keys in order of first appearance; nested values such as
+// annotation are kept as json.
+func loadJSONTable(data []byte, lines bool) (*table, error) {
+	var objects []map[string]json.RawMessage
+	if lines {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var obj map[string]json.RawMessage
+			if err := dec.Decode(&obj); err != nil {
+				return nil, err
+			}
+			objects = append(objects, obj)
+		}
+	} else if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+	t := &table{}
+	known := map[string]bool{}
+	for _, obj := range objects {
+		row := map[string]string{}
+		for _, name := range jsonKeys(obj) {
+			if !known[name] {
+				known[name] = true
+				t.columns = append(t.columns, name)
+			}
+			raw := obj[name]
+			var s string
+			switch {
+			case json.Unmarshal(raw, &s) == nil:
+			case string(raw) == "null":
+			default:
+				s = string(raw)
+			}
+			if (name == "lastModified" || name == "firstSeen") && s != "" {
+				// the api gives unix seconds
+				if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+					s = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+				}
+			}
+			row[name] = s
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
+
+// jsonColumns are the export columns, which come first in their usual
+// order; other keys follow sorted.
+var jsonColumns = []string{"id", "bucket", "bucketId", "name", "url", "size", "fileCount", "type", "lastModified"}
+
+func jsonKeys(obj map[string]json.RawMessage) []string {
+	var keys, rest []string
+	first := map[string]bool{}
+	for _, name := range jsonColumns {
+		first[name] = true
+		if _, ok := obj[name]; ok {
+			keys = append(keys, name)
+		}
+	}
+	for name := range obj {
+		if !first[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// loadSQLiteTable reads the files table of a -format sqlite database, or
+// its buckets table when there are no files.
+func loadSQLiteTable(path string) (*table, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var files int
+	if err := db.QueryRow("SELECT count(*) FROM files").Scan(&files); err != nil {
+		return nil, err
+	}
+	name := "files"
+	if files == 0 {
+		name = "buckets"
+	}
+	rows, err := db.Query("SELECT * FROM " + name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	t := &table{}
+	for _, c := range cols {
+		t.columns = append(t.columns, camelCase(c))
+	}
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := map[string]string{}
+		for i, name := range t.columns {
+			row[name] = values[i].String
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, rows.Err()
+}
+
+func loadParquetTable(data []byte) (*table, error) {
+	columns, rows, err := readParquet(data)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{}
+	for _, c := range columns {
+		t.columns = append(t.columns, camelCase(c))
+	}
+	for _, record := range rows {
+		row := map[string]string{}
+		for i, name := range t.columns {
+			row[name] = record[i]
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
+
+// detect tells files from buckets: files have a url, a name or an id
+// column, buckets only the bucket one. A findings export of another tool
+// may have nothing but the id.
+func (t *table) detect() error {
+	has := map[string]bool{}
+	for _, c := range t.columns {
+		has[c] = true
+	}
+	switch {
+	case has["url"]:
+		t.kind, t.key = "files", "url"
+	case has["name"]:
+		t.kind, t.key = "files", "name"
+	case has["id"] && !has["bucket"]:
+		t.kind, t.key = "files", "id"
+	case has["bucket"]:
+		t.kind, t.key = "buckets", "bucket"
+	default:
+		return fmt.Errorf("no url, name, id or bucket column")
+	}
+	return nil
+}
+
+// files turns the rows of a files table back into files.
+func (t *table) files() ([]File, error) {
+	if t.kind != "files" {
+		return nil, fmt.Errorf("a %s export, not a files one", t.kind)
+	}
+	var files []File
+	for _, row := range t.rows {
+		f := File{}
+		f.ID = row["id"]
+		f.Bucket = row["bucket"]
+		f.BucketID = row["bucketId"]
+		f.Name = row["name"]
+		f.URL = row["url"]
+		f.Size, _ = strconv.ParseInt(row["size"], 10, 64)
+		f.Type = row["type"]
+		if ts, err := time.Parse(time.RFC3339, row["lastModified"]); err == nil {
+			f.LastModified = ts.Unix()
+		}
+		if v, err := strconv.ParseInt(row["liveSize"], 10, 64); err == nil {
+			f.LiveSize = &v
+		}
+		if v, err := strconv.ParseInt(row["sizeDelta"], 10, 64); err == nil {
+			f.SizeDelta = &v
+		}
+		f.Keyword = row["keyword"]
+		if row["matchedOn"] != "" {
+			f.MatchedOn = strings.Split(row["matchedOn"], ";")
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// camelCase turns sqlite and parquet column names like last_modified into
+// the csv header names.
+func camelCase(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want rune
+	}{
+		{"url,bucket,size\na,b,1\n", ','},
+		{"url\tbucket\tsize\n", '\t'},
+		{"url;bucket;size\n", ';'},
+		{"url|bucket|size\n", '|'},
+		{"url\n", ','},
+		// a ; inside a quoted name is not a delimiter
+		{"\"a;b;c\",bucket\n", ','},
+		// only the header counts
+		{"url,bucket\n\"x;y;z\",b\n", ','},
+	}
+	for _, tt := range tests {
+		if got := sniffDelimiter([]byte(tt.in)); got != tt.want {
+			t.Errorf("sniffDelimiter(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	want := []map[string]string{
+		{"url": "https://a.s3.amazonaws.com/db.sql", "bucket": "a", "size": "10"},
+		{"url": "https://b.s3.amazonaws.com/[old].zip", "bucket": "b", "size": "20"},
+	}
+	// json columns come in export order, as in the csv header
+	tests := []struct {
+		name, data string
+	}{
+		{"csv", "bucket,url,size\na,https://a.s3.amazonaws.com/db.sql,10\nb,https://b.s3.amazonaws.com/[old].zip,20\n"},
+		{"csv with bom", "\ufeffbucket,url,size\na,https://a.s3.amazonaws.com/db.sql,10\nb,https://b.s3.amazonaws.com/[old].zip,20\n"},
+		{"semicolon csv", "bucket;url;size\na;https://a.s3.amazonaws.com/db.sql;10\nb;https://b.s3.amazonaws.com/[old].zip;20\n"},
+		{"json", `[{"url":"https://a.s3.amazonaws.com/db.sql","bucket":"a","size":10},
+{"url":"https://b.s3.amazonaws.com/[old].zip","bucket":"b","size":20}]`},
+		{"ndjson", `{"url":"https://a.s3.amazonaws.com/db.sql","bucket":"a","size":10}
+{"url":"https://b.s3.amazonaws.com/[old].zip","bucket":"b","size":20}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "export")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			tab, err := loadTable(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tab.kind != "files" || tab.key != "url" {
+				t.Errorf("got a %s table keyed by %s, want files by url", tab.kind, tab.key)
+			}
+			if !reflect.DeepEqual(tab.columns, []string{"bucket", "url", "size"}) {
+				t.Errorf("got columns %q", tab.columns)
+			}
+			if !reflect.DeepEqual(tab.rows, want) {
+				t.Errorf("got rows %q, want %q", tab.rows, want)
+			}
+		})
+	}
+}
+
+func TestLoadTableCSVCellStartingWithBracket(t *testing.T) {
+	// only the first byte tells json from csv
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(path, []byte("name,bucket\n[old].zip,b\n{new}.zip,b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tab, err := loadTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tab.rows) != 2 || tab.rows[0]["name"] != "[old].zip" || tab.rows[1]["name"] != "{new}.zip" {
+		t.Errorf("got rows %q", tab.rows)
+	}
+}
+
+func TestLoadTableBuckets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.csv")
+	if err := os.WriteFile(path, []byte("bucket,fileCount,type\nmybucket,12,aws\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tab, err := loadTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tab.kind != "buckets" || tab.key != "bucket" || tab.rows[0]["fileCount"] != "12" {
+		t.Errorf("got a %s table keyed by %s with rows %q", tab.kind, tab.key, tab.rows)
+	}
+	if _, err := tab.files(); err == nil {
+		t.Error("read files from a buckets table")
+	}
+}
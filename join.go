@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runJoin(args []string) {
+	fs := newFlagSet("join", "Enrich a files export, the first argument, with the columns of the findings exports that follow: a -verify-size run, a download manifest with its yara matches, or any csv/json with a url or id column.")
+	output := fs.String("o", "", "Output file path. If empty, print to stdout")
+	format := fs.String("format", "csv", "Output format: csv|json")
+	on := fs.String("on", "url", "Column rows are matched on: url|id")
+	parseFlags(fs, args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *on != "url" && *on != "id" {
+		log.Fatalf("unknown -on %s, use url or id\n", *on)
+	}
+
+	base, err := loadTable(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("read %s: %v", fs.Arg(0), err)
+	}
+	if base.kind != "files" {
+		log.Fatalf("%s holds %s, join needs a files export\n", fs.Arg(0), base.kind)
+	}
+	for _, path := range fs.Args()[1:] {
+		t, err := loadTable(path)
+		if err != nil {
+			log.Fatalf("read %s: %v", path, err)
+		}
+		if !joinTable(base, t, *on, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))) {
+			log.Fatalf("%s has no %s column\n", path, *on)
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("create file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	switch strings.ToLower(*format) {
+	case "json":
+		b, _ := json.MarshalIndent(base.rows, "", "  ")
+		out.Write(append(b, '\n'))
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write(base.columns)
+		for _, row := range base.rows {
+			record := make([]string, len(base.columns))
+			for i, c := range base.columns {
+				record[i] = row[c]
+			}
+			w.Write(safeRow(record))
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Fatalf("write csv: %v", err)
+		}
+	default:
+		log.Fatalf("unknown format %s\n", *format)
+	}
+}
+
+// joinTable adds the columns of findings to the rows of base with the same
+// on value; several findings rows for one file are joined with ";". A
+// column base has already is skipped when it holds the same values, else
+// added as source.column. It reports false when findings lacks on.
+func joinTable(base, findings *table, on, source string) bool {
+	if !containsString(findings.columns, on) {
+		return false
+	}
+	byKey := map[string][]map[string]string{}
+	for _, row := range findings.rows {
+		if k := strings.TrimSpace(row[on]); k != "" {
+			byKey[k] = append(byKey[k], row)
+		}
+	}
+	has := map[string]bool{}
+	for _, c := range base.columns {
+		has[c] = true
+	}
+	for _, c := range findings.columns {
+		if c == on {
+			continue
+		}
+		values := make([]string, len(base.rows))
+		same := true
+		for i, row := range base.rows {
+			values[i] = joinValues(byKey[strings.TrimSpace(row[on])], c)
+			if values[i] != "" && values[i] != row[c] {
+				same = false
+			}
+		}
+		name := c
+		if has[c] {
+			if same {
+				continue
+			}
+			name = source + "." + c
+		}
+		base.columns = append(base.columns, name)
+		for i, row := range base.rows {
+			row[name] = values[i]
+		}
+	}
+	return true
+}
+
+// joinValues is the distinct non-empty values of column c in rows.
+func joinValues(rows []map[string]string, c string) string {
+	var values []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		if v := row[c]; v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return strings.Join(values, ";")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
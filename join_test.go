@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinTable(t *testing.T) {
+	base := loadTestTable(t, "files.csv", "bucket,url,size\na,https://a/1,10\na,https://a/2,20\nb,https://b/3,30\n")
+	// a manifest with two yara matches for one file and a size that only
+	// differs for another
+	findings := loadTestTable(t, "manifest.ndjson", `{"url":"https://a/1","status":"ok","yara":"aws_key","size":10}
+{"url":"https://a/1","status":"ok","yara":"private_key","size":10}
+{"url":"https://b/3","status":"too-large","size":31}
+`)
+	if !joinTable(base, findings, "url", "manifest") {
+		t.Fatal("no url column found")
+	}
+	wantColumns := []string{"bucket", "url", "size", "manifest.size", "status", "yara"}
+	if !reflect.DeepEqual(base.columns, wantColumns) {
+		t.Errorf("got columns %q, want %q", base.columns, wantColumns)
+	}
+	want := []map[string]string{
+		{"bucket": "a", "url": "https://a/1", "size": "10", "status": "ok", "yara": "aws_key;private_key", "manifest.size": "10"},
+		{"bucket": "a", "url": "https://a/2", "size": "20", "status": "", "yara": "", "manifest.size": ""},
+		{"bucket": "b", "url": "https://b/3", "size": "30", "status": "too-large", "yara": "", "manifest.size": "31"},
+	}
+	if !reflect.DeepEqual(base.rows, want) {
+		t.Errorf("got rows %q, want %q", base.rows, want)
+	}
+}
+
+func TestJoinTableSameColumnSkipped(t *testing.T) {
+	base := loadTestTable(t, "files.csv", "url,size\nhttps://a/1,10\n")
+	findings := loadTestTable(t, "verify.csv", "url,size,liveSize\nhttps://a/1,10,12\n")
+	joinTable(base, findings, "url", "verify")
+	if !reflect.DeepEqual(base.columns, []string{"url", "size", "liveSize"}) || base.rows[0]["liveSize"] != "12" {
+		t.Errorf("got columns %q and rows %q", base.columns, base.rows)
+	}
+}
+
+func TestJoinTableOnID(t *testing.T) {
+	base := loadTestTable(t, "files.csv", "id,url\n7,https://a/1\n8,https://a/2\n")
+	// findings of another tool with nothing but the id
+	findings := loadTestTable(t, "findings.csv", "id,verdict\n8,leak\n")
+	if !joinTable(base, findings, "id", "findings") || base.rows[1]["verdict"] != "leak" || base.rows[0]["verdict"] != "" {
+		t.Errorf("got rows %q", base.rows)
+	}
+	if joinTable(base, findings, "url", "findings") {
+		t.Error("joined on a column findings doesn't have")
+	}
+}
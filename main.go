@@ -1,324 +1,4718 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
 	"time"
-)
+	"unicode"
 
-const baseURL = "https://buckets.grayhatwarfare.com/api/v2"
+	"github.com/dogadmin/bucketsearch/ghw"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+	"gopkg.in/yaml.v3"
+)
 
+// File is a ghw.File plus the columns added by -verify-size.
 type File struct {
-	ID           any    `json:"id"`
-	Bucket       string `json:"bucket"`
-	BucketID     any    `json:"bucketId"`
-	Name         string `json:"name"`
-	URL          string `json:"url"`
-	Size         int64  `json:"size"`
-	Type         string `json:"type"`
-	LastModified int64  `json:"lastModified"`
-}
+	ghw.File
+
+	LiveSize  *int64 `json:"liveSize,omitempty"`
+	SizeDelta *int64 `json:"sizeDelta,omitempty"`
 
-type FilesResponse struct {
-	Files []File `json:"files"`
-	Meta  struct {
-		Results int `json:"results"`
-	} `json:"meta"`
+	Annotation *annotation `json:"annotation,omitempty"`
+	MatchedOn  []string    `json:"matchedOn,omitempty"`
+	// Keyword is the -keywords-file line that found the file
+	Keyword string `json:"keyword,omitempty"`
 }
 
+// Bucket is a ghw.Bucket plus the columns added by the enrichment flags.
 type Bucket struct {
-	ID        any    `json:"id"`
-	Bucket    string `json:"bucket"`
-	FileCount int    `json:"fileCount"`
-	Type      string `json:"type"`
-}
+	ghw.Bucket
+	Region    string `json:"region,omitempty"`
+	FirstSeen string `json:"firstSeen,omitempty"`
 
-type BucketsResponse struct {
-	Buckets []Bucket `json:"buckets"`
-	Meta    struct {
-		Results int `json:"results"`
-	} `json:"meta"`
+	Attribution      string  `json:"attribution,omitempty"`
+	AttributionScore float64 `json:"attributionScore,omitempty"`
+	Keyword          string  `json:"keyword,omitempty"`
 }
 
-type StatsResponse struct {
-	Stats struct {
-		FilesCount int64 `json:"filesCount"`
-		AwsCount   int   `json:"awsCount"`
-		AzureCount int   `json:"azureCount"`
-		DosCount   int   `json:"dosCount"`
-		GcpCount   int   `json:"gcpCount"`
-		AliCount   int   `json:"aliCount"`
-	} `json:"stats"`
-}
+const usage = `Usage: bucketsearch <command> [flags]
 
-func main() {
-	apiKey := flag.String("apikey", os.Getenv("GHW_API_KEY"), "API key (or set env GHW_API_KEY)")
-	cmd := flag.String("cmd", "files", "Command: files|buckets|stats")
-	keywords := flag.String("keywords", "", "Search keywords")
-	ext := flag.String("ext", "", "comma separated extensions filter, e.g. pdf,docx")
-	noext := flag.String("noext", "", "comma separated extensions to exclude")
-	bucket := flag.String("bucket", "", "Bucket id or url")
-	limit := flag.Int("limit", 1000, "Page size (1-1000). All pages will be fetched until results exhausted")
-	start := flag.Int("start", 0, "Start offset (files/buckets)")
-	output := flag.String("o", "", "Output csv file path. If empty, print json")
-	cloudType := flag.String("type", "", "Bucket cloud type filter: aws|azure|dos|gcp|ali")
-	onlyBucket := flag.Bool("onlybucket", false, "Output only bucket names (one per line or single column CSV)")
-	flag.Parse()
-
-	if *apiKey == "" {
-		log.Fatalln("missing api key")
-	}
+Commands:
+  files         search files
+  buckets       search buckets
+  clusters      group buckets by the organization their names point to
+  stats         show index statistics
+  raw           send a GET to any api path and print the response
+  disclose      draft a disclosure email for a bucket
+  share         summarize a files export as markdown, optionally as a gist
+  annotate      attach a status, tags and a note to a file url
+  presets       list, show <name> or update extension, noise and permutation presets
+  download      fetch the files a search or an export matched
+  backfill      walk a whole search into date sliced csvs, resumable over days
+  profile       sample a bucket and print a quick risk profile
+  watch         poll a search and report only newly indexed files
+  diff          compare two files or buckets exports
+  join          enrich a files export with verify and scan findings
+  query         search the results cached by earlier runs, offline
+  search-local  full-text search cached file names and urls, fuzzy and by prefix
 
-	client := &http.Client{Timeout: 15 * time.Second}
+Run "bucketsearch <command> -h" for the flags of a command.
+`
 
-	switch strings.ToLower(*cmd) {
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, args := strings.ToLower(os.Args[1]), os.Args[2:]
+	if legacy, rest, ok := legacyCommand(os.Args[1:]); ok {
+		log.Printf("-cmd is deprecated and will be removed, run: bucketsearch %s [flags]", legacy)
+		cmd, args = legacy, rest
+	}
+	switch cmd {
 	case "files":
-		handleFiles(client, *apiKey, *keywords, *bucket, *ext, *noext, *limit, *start, *output)
+		runFiles(args)
 	case "buckets":
-		handleBuckets(client, *apiKey, *keywords, *cloudType, *limit, *start, *output, *onlyBucket)
+		runBuckets(args)
+	case "clusters":
+		runClusters(args)
 	case "stats":
-		handleStats(client, *apiKey, *output)
+		runStats(args)
+	case "raw":
+		runRaw(args)
+	case "disclose":
+		runDisclose(args)
+	case "share":
+		runShare(args)
+	case "annotate":
+		runAnnotate(args)
+	case "presets":
+		runPresets(args)
+	case "download":
+		runDownload(args)
+	case "backfill":
+		runBackfill(args)
+	case "profile":
+		runProfile(args)
+	case "watch":
+		runWatch(args)
+	case "diff":
+		runDiff(args)
+	case "join":
+		runJoin(args)
+	case "query":
+		runQuery(args)
+	case "search-local":
+		runSearchLocal(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
 	default:
-		log.Fatalf("unknown cmd %s\n", *cmd)
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if cancelRun != nil {
+		cancelRun()
+	}
+	if interrupted.Load() {
+		os.Exit(130)
+	}
+	if sinkFailure() != nil {
+		os.Exit(1)
 	}
 }
 
-func buildURL(path string, params map[string]string) string {
-	u, _ := url.Parse(baseURL + path)
-	q := u.Query()
-	for k, v := range params {
-		if v != "" {
-			q.Set(k, v)
+// legacyCommand reads the command of the old flag-only command line,
+// -cmd <command> among the flags, files when there was none, and returns
+// the other arguments. ok is false for a command line starting with a
+// command.
+func legacyCommand(args []string) (cmd string, rest []string, ok bool) {
+	switch args[0] {
+	case "-h", "-help", "--help":
+		return "", nil, false
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		return "", nil, false
+	}
+	cmd = "files"
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch {
+		case !strings.HasPrefix(args[i], "-") || name != "cmd":
+			rest = append(rest, args[i])
+		case hasValue:
+			cmd = value
+		case i+1 < len(args):
+			i++
+			cmd = args[i]
 		}
 	}
-	u.RawQuery = q.Encode()
-	return u.String()
+	return strings.ToLower(cmd), rest, true
 }
 
-func doGet(client *http.Client, apiKey, urlStr string) ([]byte, error) {
-	req, _ := http.NewRequest("GET", urlStr, nil)
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// newFlagSet returns the flag set of one command with a usage header.
+func newFlagSet(cmd, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bucketsearch %s [flags]\n\n%s\n\nFlags:\n", cmd, summary)
+		fs.PrintDefaults()
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http %d", resp.StatusCode)
+	return fs
+}
+
+// apiFlags are shared by every command that talks to the API.
+type apiFlags struct {
+	apiKey         *string
+	apiKeyFile     *string
+	apiBase        *string
+	rate           *string
+	telemetry      *string
+	retries        *int
+	retryMaxWait   *time.Duration
+	requestTimeout *time.Duration
+}
+
+func addAPIFlags(fs *flag.FlagSet) apiFlags {
+	addProxyFlag(fs)
+	addVerboseFlags(fs)
+	addDeadlineFlag(fs)
+	return apiFlags{
+		apiKey:         fs.String("apikey", os.Getenv("GHW_API_KEY"), "API key (or set env GHW_API_KEY); several comma separated are used in turn, moving on when one is rate limited or out of quota"),
+		apiKeyFile:     fs.String("apikey-file", "", "File of API keys, one per line, pooled like a comma separated -apikey"),
+		apiBase:        fs.String("api-base", os.Getenv("GHW_API_BASE"), "API base url, e.g. an internal caching proxy or a mock server (or set env GHW_API_BASE; default "+ghw.DefaultBaseURL+")"),
+		rate:           fs.String("rate", "", "Send at most this many api requests, e.g. 2/s or 100/m, across all workers (default unlimited until the api answers 429, then below the rate that got it)"),
+		telemetry:      fs.String("telemetry", "", "Opt-in: post aggregate run metrics (duration, request count, retries, latency, error class) to this url"),
+		retries:        fs.Int("retries", 3, "Retry a request this many times on network errors and 429/5xx, backing off exponentially"),
+		retryMaxWait:   fs.Duration("retry-max-wait", 30*time.Second, "Longest wait between two retries"),
+		requestTimeout: fs.Duration("request-timeout", 15*time.Second, "Give up on one api request attempt after this long; retries get a fresh one"),
 	}
-	return io.ReadAll(resp.Body)
 }
 
-func handleFiles(client *http.Client, apiKey, keywords, bucket, ext, noext string, limit, start int, output string) {
-	pageSize := limit
-	if pageSize <= 0 || pageSize > 1000 {
-		pageSize = 1000
+// runTimeout is -deadline. maxRuntime is -max-runtime, which cancels
+// nothing: the paging loops just start no page after it.
+var runTimeout, maxRuntime time.Duration
+
+func addDeadlineFlag(fs *flag.FlagSet) {
+	fs.DurationVar(&runTimeout, "deadline", 0, "Stop the run after this long, e.g. 30m: api requests and downloads still running are cancelled, paging commands stop and save what they have")
+}
+
+func addMaxRuntimeFlag(fs *flag.FlagSet) {
+	fs.DurationVar(&maxRuntime, "max-runtime", 0, "Stop paging cleanly after this long, e.g. 8h: the pages in flight finish and are saved, unlike -deadline nothing is cancelled")
+}
+
+// startDeadline starts the -deadline clock of runCtx and the -max-runtime
+// clock of the budget.
+func startDeadline() {
+	if runTimeout > 0 && cancelRun == nil {
+		runCtx, cancelRun = context.WithTimeout(context.Background(), runTimeout)
+	}
+	if maxRuntime > 0 && budget.deadline.IsZero() {
+		budget.deadline = time.Now().Add(maxRuntime)
 	}
+}
 
-	var allFiles []File
-	var w *csv.Writer
-	var fetched int64
-	if output != "" {
-		f, err := os.Create(output)
-		if err != nil {
-			log.Fatalf("create csv: %v", err)
+// client checks the api key, starts telemetry for cmd and returns the api
+// client. Callers defer telemetry.flush().
+func (f apiFlags) client(cmd string) *ghw.Client {
+	var keys []string
+	for _, k := range strings.Split(*f.apiKey, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
 		}
-		defer f.Close()
-		w = csv.NewWriter(f)
-		defer w.Flush()
-		w.Write([]string{"id", "bucket", "bucketId", "name", "url", "size", "type", "lastModified"})
 	}
-
-	offset := start
-	total := -1
-	for {
-		urlStr := buildURL("/files", map[string]string{
-			"keywords":       keywords,
-			"bucket":         bucket,
-			"extensions":     ext,
-			"stopextensions": noext,
-			"limit":          fmt.Sprintf("%d", pageSize),
-			"start":          fmt.Sprintf("%d", offset),
-		})
-		data, err := doGet(client, apiKey, urlStr)
+	if *f.apiKeyFile != "" {
+		list, err := readList(*f.apiKeyFile)
 		if err != nil {
-			log.Fatalf("request error: %v", err)
+			log.Fatalf("read api keys: %v", err)
 		}
-		var resp FilesResponse
-		if err := json.Unmarshal(data, &resp); err != nil {
-			log.Fatalf("decode: %v", err)
+		keys = append(keys, list...)
+	}
+	if len(keys) == 0 && !dryRun && !offline {
+		log.Fatalln("missing api key")
+	}
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+	if *f.telemetry != "" {
+		telemetry = &telemetryReporter{endpoint: *f.telemetry, command: cmd, started: time.Now()}
+	}
+	api := ghw.NewClient(keys[0])
+	if len(keys) > 1 {
+		api.Keys = keys
+	}
+	if *f.apiBase != "" {
+		u, err := url.Parse(*f.apiBase)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("bad -api-base %s, want an http:// or https:// url\n", *f.apiBase)
 		}
+		api.BaseURL = strings.TrimSuffix(*f.apiBase, "/")
+	}
+	perSecond, err := parseRate(*f.rate)
+	if err != nil {
+		log.Fatalf("rate: %v", err)
+	}
+	api.Limiter = ghw.NewLimiter(perSecond)
+	api.Limiter.OnSlowDown = func(perSecond float64) {
+		log.Print(tr("rate limited, slowing down to %s (set -rate to choose one)", formatRate(perSecond)))
+	}
+	api.HTTPClient.Transport = telemetryTransport{newTransport(0)}
+	if offline {
+		api.Limiter = nil
+		api.HTTPClient.Transport = offlineTransport{db: openQueryCache()}
+	}
+	api.Retries = *f.retries
+	api.RetryMaxWait = *f.retryMaxWait
+	api.Timeout = *f.requestTimeout
+	startDeadline()
+	api.OnRetry = func(attempt int, err error, wait time.Duration) {
+		telemetry.retry()
+		status.retry()
+		log.Print(tr("request error: %v, retry %d/%d in %s", err, attempt, api.Retries, wait.Round(time.Millisecond)))
+	}
+	return api
+}
 
-		// write/collect
-		if w != nil {
-			for _, file := range resp.Files {
-				w.Write([]string{
-					fmt.Sprint(file.ID),
-					file.Bucket,
-					fmt.Sprint(file.BucketID),
-					file.Name,
-					file.URL,
-					fmt.Sprintf("%d", file.Size),
-					file.Type,
-					time.Unix(file.LastModified, 0).Format(time.RFC3339),
-				})
-			}
-			w.Flush()
-		} else {
-			allFiles = append(allFiles, resp.Files...)
-		}
+// parseRate parses a -rate like 2/s, 100/m or 5000/h, a bare number being
+// per second, into requests a second; "" is 0.
+func parseRate(v string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, unit, _ := strings.Cut(strings.TrimSpace(v), "/")
+	per := map[string]float64{"": 1, "s": 1, "m": 60, "h": 3600}[unit]
+	rate, err := strconv.ParseFloat(n, 64)
+	if err != nil || per == 0 || rate <= 0 {
+		return 0, fmt.Errorf("bad rate %s, use e.g. 2/s, 100/m or 5000/h", v)
+	}
+	return rate / per, nil
+}
 
-		atomic.AddInt64(&fetched, int64(len(resp.Files)))
-		if total == -1 {
-			total = resp.Meta.Results
+func formatRate(perSecond float64) string {
+	if perSecond >= 1 {
+		return strconv.FormatFloat(perSecond, 'f', 1, 64) + "/s"
+	}
+	return strconv.FormatFloat(perSecond*60, 'f', 1, 64) + "/m"
+}
+
+// newWebClient returns the client for provider HEAD requests, which never
+// carry the api key.
+func newWebClient() *http.Client {
+	return &http.Client{Timeout: 15 * time.Second, Transport: newTransport(0)}
+}
+
+// pagingFlags are shared by the commands that page through search results.
+type pagingFlags struct {
+	keywords     *string
+	keywordsFile *string
+	translit     *bool
+	limit        *int
+	start        *int
+	output       *string
+	maxRequests  *int
+	estimate     *bool
+	yes          *bool
+	stableSort   *bool
+	idn          *string
+	statusJSON   *bool
+	format       *string
+	webhook      *webhookFlags
+	elastic      *elasticFlags
+	cache        *bool
+	noCache      *bool
+}
+
+func addPagingFlags(fs *flag.FlagSet) pagingFlags {
+	addNotifyFlag(fs)
+	addFlushPendingFlag(fs)
+	f := addSearchFlags(fs)
+	f.webhook = addWebhookFlags(fs)
+	f.elastic = addElasticFlags(fs)
+	f.output = fs.String("o", "", "Output csv file path, - for csv on stdout. If empty, print json")
+	f.stableSort = fs.Bool("stable-sort", false, "Sort final output by bucket, name and id so runs diff cleanly")
+	addDedupFlag(fs)
+	f.format = fs.String("format", "", "Output format: json|csv|ndjson|sqlite|postgres|parquet|xlsx (default json on stdout, csv with -o, postgres with -o postgres://...); csv and ndjson go to stdout without -o, the others need -o")
+	return f
+}
+
+// addSearchFlags adds the flags of how a search pages, without those of
+// where the results go, which clusters has its own of.
+func addSearchFlags(fs *flag.FlagSet) pagingFlags {
+	addQuietFlag(fs)
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the api requests the run would send and how it pages, without sending any")
+	addOfflineFlag(fs)
+	addMaxRuntimeFlag(fs)
+	return pagingFlags{
+		cache:        fs.Bool("cache", false, "Keep the fetched results, unencrypted, in the local cache that query, search-local and -offline read"),
+		noCache:      fs.Bool("no-cache", false, "Deprecated: the cache is off unless -cache is given"),
+		keywords:     fs.String("keywords", "", "Search keywords"),
+		keywordsFile: fs.String("keywords-file", "", "Search once per line of this file instead of -keywords, merging the results without duplicates and adding a keyword column"),
+		translit:     fs.Bool("transliterate", false, "Also search the Latin spelling of keywords written in Cyrillic or Greek, e.g. sberbank for сбербанк; adds a keyword column"),
+		limit:        fs.Int("limit", 1000, "Page size (1-1000). All pages will be fetched until results exhausted"),
+		start:        fs.Int("start", 0, "Start offset"),
+		maxRequests:  fs.Int("max-requests", 0, "Stop paging cleanly after this many API requests"),
+		estimate:     fs.Bool("estimate", false, "Probe the result count first, print the projected requests/rows/time and ask to continue"),
+		yes:          fs.Bool("yes", false, "Don't ask for confirmation after -estimate"),
+		idn:          fs.String("idn", "", "Normalize internationalized bucket hostnames in results: unicode|ascii (punycode)"),
+		statusJSON:   fs.Bool("status-json", false, "Stream progress as one json object per line on stderr instead of the status line"),
+	}
+}
+
+// keywordList reads -keywords-file: one search per line, blank lines and
+// # comments skipped, each followed by its -transliterate spelling. It is
+// nil without either flag.
+func (f pagingFlags) keywordList() []string {
+	var list []string
+	switch {
+	case *f.keywordsFile != "":
+		if *f.keywords != "" {
+			log.Fatalln("use either -keywords or -keywords-file")
 		}
-		if total > 0 {
-			fmt.Printf("\r已获取 %d / %d 条", fetched, total)
-		} else {
-			fmt.Printf("\r已获取 %d 条", fetched)
+		var err error
+		if list, err = readList(*f.keywordsFile); err != nil {
+			log.Fatalf("read keywords file: %v", err)
+		}
+		if len(list) == 0 {
+			log.Fatalf("no keywords in %s\n", *f.keywordsFile)
+		}
+	case *f.translit:
+		if *f.keywords == "" {
+			log.Fatalln("-transliterate needs -keywords or -keywords-file")
 		}
+		list = []string{*f.keywords}
+	default:
+		return nil
+	}
+	if *f.translit {
+		list = transliterateKeywords(list)
+	}
+	return uniqueKeywords(list)
+}
 
-		if len(resp.Files) < pageSize || (total > 0 && offset+pageSize >= total) {
-			break
+// uniqueKeywords drops the keywords repeating an earlier one but for case
+// and spacing, which the api ignores, so a run never pages through the
+// same results twice.
+func uniqueKeywords(list []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, kw := range list {
+		if k := keywordKey(kw); !seen[k] {
+			seen[k] = true
+			out = append(out, kw)
 		}
-		offset += pageSize
 	}
+	return out
+}
 
-	fmt.Println()
-	if w != nil {
-		fmt.Printf("completed, saved to %s\n", output)
+func keywordKey(kw string) string {
+	return strings.ToLower(strings.Join(strings.Fields(kw), " "))
+}
+
+// readList reads one entry per line from path, or stdin for "-", without
+// blank lines, # comments and repeats.
+func readList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
 	} else {
-		out, _ := json.MarshalIndent(allFiles, "", "  ")
-		os.Stdout.Write(out)
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		list = append(list, line)
 	}
+	return list, nil
 }
 
-func handleBuckets(client *http.Client, apiKey, keywords, cloudType string, limit, start int, output string, onlyBucket bool) {
-	pageSize := limit
-	if pageSize <= 0 || pageSize > 1000 {
-		pageSize = 1000
+// sendPending is -flush-pending: setting up the sinks sends what they
+// kept, and nothing else is done.
+func (f pagingFlags) sendPending() {
+	if *f.webhook.url == "" && *f.elastic.url == "" {
+		log.Fatalln("-flush-pending needs the -webhook or -es to send to")
 	}
+	f.webhook.apply()
+	f.elastic.apply()
+}
 
-	var allBuckets []Bucket
-	var w *csv.Writer
-	var fetched int64
-	if output != "" {
-		f, err := os.Create(output)
-		if err != nil {
-			log.Fatalf("create csv: %v", err)
+// apply sets up the run budget and idn output form and returns the
+// preflight options.
+func (f pagingFlags) apply() preflightOptions {
+	switch strings.ToLower(*f.idn) {
+	case "", "unicode", "ascii":
+		idnOutput = strings.ToLower(*f.idn)
+	default:
+		log.Fatalf("unknown idn form %s\n", *f.idn)
+	}
+	statusJSON = *f.statusJSON
+	if f.webhook != nil {
+		f.webhook.apply()
+		f.elastic.apply()
+	}
+	if *f.cache && !*f.noCache {
+		if offline {
+			log.Fatalln("-offline reads the cache, it can't also write it; leave off -cache")
 		}
-		defer f.Close()
-		w = csv.NewWriter(f)
-		defer w.Flush()
-		if onlyBucket {
-			w.Write([]string{"bucket"})
-		} else {
-			w.Write([]string{"id", "bucket", "fileCount", "type"})
+		if encryptState {
+			log.Fatalln("the cache is a plain sqlite database, it can't be kept with -encrypt-state; leave off -cache")
 		}
+		openCache()
 	}
+	budget.maxRequests = *f.maxRequests
+	return preflightOptions{enabled: *f.estimate, yes: *f.yes}
+}
 
-	offset := start
-	total := -1
-	for {
-		urlStr := buildURL("/buckets", map[string]string{
-			"keywords": keywords,
-			"type":     cloudType,
-			"limit":    fmt.Sprintf("%d", pageSize),
-			"start":    fmt.Sprintf("%d", offset),
-		})
-		data, err := doGet(client, apiKey, urlStr)
-		if err != nil {
-			log.Fatalf("request error: %v", err)
+// csvFlags control how csv output is written.
+type csvFlags struct {
+	noEscape   *bool
+	bom        *bool
+	encoding   *string
+	columnMap  *string
+	fields     *string
+	delimiter  *string
+	flushEvery *int
+}
+
+func addCSVFlags(fs *flag.FlagSet) csvFlags {
+	return csvFlags{
+		noEscape:   fs.Bool("no-csv-escape", false, "Don't escape csv cells starting with = + - @ (formula injection guard)"),
+		bom:        fs.Bool("bom", false, "Start utf8 csv output with a byte order mark so Excel detects the encoding"),
+		encoding:   fs.String("encoding", "utf8", "Csv output encoding: utf8|gbk"),
+		columnMap:  fs.String("column-map", "", "Yaml file mapping output columns to new names, in output order, e.g. url: file_url"),
+		delimiter:  fs.String("delimiter", ",", "Csv field separator: one character, or tab for tsv"),
+		fields:     fs.String("fields", "", "Comma separated columns to write, in this order, e.g. url or id,url,size; applies to csv, xlsx, json and ndjson"),
+		flushEvery: fs.Int("flush-every", 1000, "Flush and fsync csv and ndjson output every N rows: fewer rows lose less in a crash, more are faster on network filesystems"),
+	}
+}
+
+// apply sets the csv writer settings and returns the column map, if any.
+func (f csvFlags) apply() *columnMap {
+	escapeCSV = !*f.noEscape
+	csvBOM = *f.bom
+	csvFlushEvery = *f.flushEvery
+	switch d := []rune(*f.delimiter); {
+	case *f.delimiter == "tab" || *f.delimiter == `\t`:
+		csvDelimiter = '\t'
+	case len(d) == 1 && d[0] != '"' && d[0] != '\r' && d[0] != '\n':
+		csvDelimiter = d[0]
+	default:
+		log.Fatalf("bad delimiter %q, want one character or tab\n", *f.delimiter)
+	}
+	switch strings.ToLower(*f.encoding) {
+	case "utf8", "utf-8":
+	case "gbk":
+		csvEncoding = "gbk"
+	default:
+		log.Fatalf("unknown encoding %s\n", *f.encoding)
+	}
+	if *f.fields != "" {
+		if *f.columnMap != "" {
+			log.Fatalln("use either -fields or -column-map")
 		}
-		var resp BucketsResponse
-		if err := json.Unmarshal(data, &resp); err != nil {
-			log.Fatalf("decode: %v", err)
+		m := &columnMap{json: true}
+		for _, name := range strings.Split(*f.fields, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				m.from = append(m.from, name)
+			}
 		}
+		m.to = m.from
+		return m
+	}
+	if *f.columnMap == "" {
+		return nil
+	}
+	columns, err := loadColumnMap(*f.columnMap)
+	if err != nil {
+		log.Fatalf("column map: %v", err)
+	}
+	return columns
+}
 
-		// client-side filter if cloudType specified
-		filtered := resp.Buckets
-		if cloudType != "" {
-			var tmp []Bucket
-			for _, b := range resp.Buckets {
-				if strings.EqualFold(b.Type, cloudType) {
-					tmp = append(tmp, b)
-				}
-			}
-			filtered = tmp
+func runFiles(args []string) {
+	fs := newFlagSet("files", "Search files and write them as json, or csv with -o.")
+	common := addAPIFlags(fs)
+	paging := addPagingFlags(fs)
+	csvOut := addCSVFlags(fs)
+	filters := addFileFilterFlags(fs)
+	onlyURL := fs.Bool("onlyurl", false, "Output only file urls (one per line or single column CSV)")
+	verifySize := fs.Bool("verify-size", false, "HEAD every file url and add liveSize/sizeDelta columns")
+	splitBy := fs.String("split-by", "", "Write one csv per group into the -o directory (default out): bucket|ext|severity")
+	workers := fs.Int("workers", 1, "Fetch up to N pages concurrently; output keeps page order")
+	resume := fs.Bool("resume", false, "Continue an interrupted -o csv export from its .checkpoint file, appending to the csv")
+	strictSchema := fs.Bool("strict-schema", false, "With -resume, stop when the csv has fewer columns than this run writes instead of appending in the csv's columns")
+	annotationsFile := fs.String("annotations", "", "Annotations json written by annotate; adds status/tags/note to each file")
+	matchedOn := fs.Bool("matched-on", false, "Add a matchedOn column listing which -keywords terms each file's bucket or path contains, checked client-side")
+	addNotifySinksFlag(fs)
+	addEncryptFlag(fs)
+	parseFlags(fs, args)
+
+	opts := filesOptions{
+		keywords:    *paging.keywords,
+		keywordList: paging.keywordList(),
+		limit:       *paging.limit,
+		start:       *paging.start,
+		output:      *paging.output,
+		onlyURL:     *onlyURL,
+		verifySize:  *verifySize,
+		splitBy:     strings.ToLower(*splitBy),
+		stableSort:  *paging.stableSort,
+		workers:     *workers,
+		resume:      *resume,
+		strict:      *strictSchema,
+	}
+	filters.apply(&opts)
+	setupSinks()
+	if *matchedOn {
+		opts.matchedOn = keywordTerms(opts.keywords)
+		for _, kw := range opts.keywordList {
+			opts.matchedOn = append(opts.matchedOn, keywordTerms(kw)...)
 		}
+	}
+	if *annotationsFile != "" {
+		var err error
+		if opts.annotations, err = loadAnnotations(*annotationsFile); err != nil {
+			log.Fatalf("read annotations: %v", err)
+		}
+	}
 
-		if w != nil {
-			if onlyBucket {
-				for _, b := range filtered {
-					w.Write([]string{b.Bucket})
-				}
-			} else {
-				for _, b := range filtered {
-					w.Write([]string{
-						fmt.Sprint(b.ID),
-						b.Bucket,
-						fmt.Sprintf("%d", b.FileCount),
-						b.Type,
-					})
-				}
-			}
-			w.Flush()
-		} else {
-			allBuckets = append(allBuckets, filtered...)
+	if flushPending {
+		paging.sendPending()
+		return
+	}
+	api := common.client("files")
+	if dryRun {
+		var urls []string
+		for _, s := range fileSearches(opts) {
+			q := filesQuery(opts)
+			q.Keywords, q.Bucket, q.Start, q.Limit = s.keywords, s.bucket, opts.start, pageLimit(opts.limit)
+			urls = append(urls, api.FilesURL(q))
 		}
+		printPlan(urls, opts.start, pageLimit(opts.limit), *paging.maxRequests)
+		return
+	}
+	defer telemetry.flush()
+	opts.preflight = paging.apply()
+	opts.columns = csvOut.apply()
+	opts.format = outputFormat(strings.ToLower(*paging.format), paging.output)
+	opts.output = *paging.output
+	handleFiles(api, newWebClient(), opts)
+}
 
-		atomic.AddInt64(&fetched, int64(len(filtered)))
-		if total == -1 {
-			total = resp.Meta.Results
+// fileFilterFlags select which files a files or download run keeps.
+type fileFilterFlags struct {
+	ext            *string
+	noext          *string
+	bucket         *string
+	perBucketMax   *int
+	prefix         *string
+	maxDepth       *int
+	fileType       *string
+	minSeverity    *string
+	suppress       *string
+	minSize        *string
+	maxSize        *string
+	after          *string
+	before         *string
+	nameRegex      *string
+	excludeRegex   *string
+	includeBuckets *string
+	excludeBuckets *string
+}
+
+func addFileFilterFlags(fs *flag.FlagSet) fileFilterFlags {
+	f := fileFilterFlags{
+		ext:            fs.String("ext", "", "comma separated extensions filter, e.g. pdf,docx or a preset like @documents"),
+		noext:          fs.String("noext", "", "comma separated extensions to exclude, presets allowed"),
+		bucket:         fs.String("bucket", "", "Bucket id or url; - reads one per line from stdin and lists the files of each"),
+		perBucketMax:   fs.Int("per-bucket-max", 0, "Keep at most N files per bucket, 0 means no limit"),
+		prefix:         fs.String("prefix", "", "Only keep files whose path inside the bucket starts with this, e.g. backups/"),
+		maxDepth:       fs.Int("max-depth", -1, "Only keep files at most N directories deep, -1 means no limit"),
+		fileType:       fs.String("file-type", "", "comma separated api file types to keep, e.g. document,archive"),
+		minSeverity:    fs.String("min-severity", "", "Drop files below this name-based severity: low|medium|high|critical"),
+		suppress:       fs.String("suppress", "", "Yaml list of known-benign results to drop: url, id or regex entries with reason and expires"),
+		minSize:        fs.String("min-size", "", "Only keep files of at least this size, e.g. 1 to skip empty ones or 10M"),
+		maxSize:        fs.String("max-size", "", "Only keep files of at most this size, e.g. 1G"),
+		after:          fs.String("modified-after", "", "Only keep files last modified after this: RFC3339, a date like 2024-01-31 or an age like 30d, 12h"),
+		before:         fs.String("modified-before", "", "Only keep files last modified before this: RFC3339, a date or an age like 1y"),
+		nameRegex:      fs.String("match", "", "Only keep files whose name or url matches this regex, e.g. '(?i)(backup|dump).*\\.sql$'"),
+		excludeRegex:   fs.String("exclude", "", "Drop files whose name or url matches this regex"),
+		includeBuckets: fs.String("include-buckets", "", "File of bucket names, one per line, or a noise preset like @public-datasets; only keep files in these buckets"),
+		excludeBuckets: fs.String("exclude-buckets", "", "File of bucket names, one per line, or a noise preset like @public-datasets, to drop the files of, e.g. cdn mirrors and public datasets"),
+	}
+	// the names of an earlier version, still used by the state files
+	fs.StringVar(f.nameRegex, "name-regex", "", "Same as -match")
+	fs.StringVar(f.excludeRegex, "exclude-regex", "", "Same as -exclude")
+	return f
+}
+
+// clientSide lists the filters applied client-side as given, name=value,
+// for a state file to tell whether a rerun filters the same way. -ext,
+// -noext and -bucket go into the api query instead.
+func (f fileFilterFlags) clientSide() []string {
+	var list []string
+	for _, v := range []struct {
+		name  string
+		value string
+	}{
+		{"per-bucket-max", strconv.Itoa(*f.perBucketMax)},
+		{"prefix", *f.prefix},
+		{"max-depth", strconv.Itoa(*f.maxDepth)},
+		{"file-type", *f.fileType},
+		{"min-severity", *f.minSeverity},
+		{"suppress", *f.suppress},
+		{"min-size", *f.minSize},
+		{"max-size", *f.maxSize},
+		{"modified-after", *f.after},
+		{"modified-before", *f.before},
+		{"name-regex", *f.nameRegex},
+		{"exclude-regex", *f.excludeRegex},
+		{"include-buckets", *f.includeBuckets},
+		{"exclude-buckets", *f.excludeBuckets},
+	} {
+		list = append(list, v.name+"="+v.value)
+	}
+	return list
+}
+
+// apply expands extension presets, loads the suppressions and sets the
+// filter fields of opts.
+func (f fileFilterFlags) apply(opts *filesOptions) {
+	presets, err := loadPresets()
+	if err != nil {
+		log.Fatalf("presets: %v", err)
+	}
+	if opts.ext, err = expandPresets(*f.ext, presets.Extensions); err != nil {
+		log.Fatalln(err)
+	}
+	if opts.noext, err = expandPresets(*f.noext, presets.Extensions); err != nil {
+		log.Fatalln(err)
+	}
+	setSuppressions(*f.suppress)
+	if *f.bucket == "-" {
+		list, err := readList("-")
+		if err != nil {
+			log.Fatalf("read buckets: %v", err)
 		}
-		if total > 0 {
-			fmt.Printf("\r已获取 %d / %d 条", fetched, total)
-		} else {
-			fmt.Printf("\r已获取 %d 条", fetched)
+		if len(list) == 0 {
+			log.Fatalln("no buckets on stdin")
+		}
+		opts.bucketList = []string{}
+		for _, b := range list {
+			opts.bucketList = append(opts.bucketList, bucketASCII(b))
+		}
+	} else {
+		opts.bucket = bucketASCII(*f.bucket)
+	}
+	opts.filters = f.clientSide()
+	opts.perBucketMax = *f.perBucketMax
+	opts.prefix = strings.TrimPrefix(*f.prefix, "/")
+	opts.maxDepth = *f.maxDepth
+	opts.fileTypes = splitSet(*f.fileType)
+	opts.minSeverity = strings.ToLower(*f.minSeverity)
+	if opts.minSize, err = parseByteSize(*f.minSize); err != nil {
+		log.Fatalf("min-size: %v", err)
+	}
+	if opts.maxSize, err = parseByteSize(*f.maxSize); err != nil {
+		log.Fatalf("max-size: %v", err)
+	}
+	if opts.modifiedAfter, err = parseTimeBound(*f.after); err != nil {
+		log.Fatalf("modified-after: %v", err)
+	}
+	if opts.modifiedBefore, err = parseTimeBound(*f.before); err != nil {
+		log.Fatalf("modified-before: %v", err)
+	}
+	if *f.nameRegex != "" {
+		if opts.nameRegex, err = regexp.Compile(*f.nameRegex); err != nil {
+			log.Fatalf("match: %v", err)
+		}
+	}
+	if *f.excludeRegex != "" {
+		if opts.excludeRegex, err = regexp.Compile(*f.excludeRegex); err != nil {
+			log.Fatalf("exclude: %v", err)
 		}
+	}
+	if opts.includeBuckets, err = bucketSet(*f.includeBuckets, presets.Noise); err != nil {
+		log.Fatalf("include-buckets: %v", err)
+	}
+	if opts.excludeBuckets, err = bucketSet(*f.excludeBuckets, presets.Noise); err != nil {
+		log.Fatalf("exclude-buckets: %v", err)
+	}
+}
 
-		if len(resp.Buckets) < pageSize || (total > 0 && offset+pageSize >= total) {
-			break
+// bucketSet reads a file of bucket names, or bucket urls, or the noise
+// preset @name into a set of lowercase ascii names. An empty path is a nil
+// set.
+func bucketSet(path string, noise map[string][]string) (map[string]bool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var list []string
+	var err error
+	if strings.HasPrefix(path, "@") {
+		var ok bool
+		if list, ok = noise[strings.ToLower(path[1:])]; !ok {
+			return nil, fmt.Errorf("unknown noise preset %s", path)
 		}
-		offset += pageSize
+	} else if list, err = readList(path); err != nil {
+		return nil, err
 	}
+	set := map[string]bool{}
+	for _, b := range list {
+		set[bucketKey(b)] = true
+	}
+	return set, nil
+}
 
-	fmt.Println()
-	if w != nil {
-		fmt.Printf("completed, saved to %s\n", output)
-	} else {
-		if onlyBucket {
-			for _, b := range allBuckets {
-				fmt.Println(b.Bucket)
+func bucketKey(bucket string) string {
+	bucket = strings.ToLower(bucket)
+	bucket = strings.TrimPrefix(strings.TrimPrefix(bucket, "https://"), "http://")
+	return bucketASCII(strings.TrimSuffix(bucket, "/"))
+}
+
+// parseTimeBound reads an RFC3339 time, a date, or an age back from now
+// in Go duration units plus d, w and y. An empty string is the zero time,
+// no bound.
+func parseTimeBound(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	unit := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}[v[len(v)-1]]
+	if unit > 0 {
+		n, err := strconv.ParseFloat(v[:len(v)-1], 64)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("bad age %q", v)
+		}
+		return time.Now().Add(-time.Duration(n * float64(unit))), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("want RFC3339, YYYY-MM-DD or an age like 30d, got %q", v)
+	}
+	return time.Now().Add(-d), nil
+}
+
+func runBuckets(args []string) {
+	fs := newFlagSet("buckets", "Search buckets and write them as json, or csv with -o.")
+	common := addAPIFlags(fs)
+	paging := addPagingFlags(fs)
+	csvOut := addCSVFlags(fs)
+	cloudType := fs.String("type", "", "Bucket cloud type filter: aws|azure|dos|gcp|ali")
+	onlyBucket := fs.Bool("onlybucket", false, "Output only bucket names (one per line or single column CSV)")
+	minFiles := fs.Int("min-files", 0, "Only keep buckets with at least this many files")
+	maxFiles := fs.Int("max-files", 0, "Only keep buckets with at most this many files, 0 means no limit")
+	sortBy := fs.String("sort", "", "Sort buckets across all pages: filecount|name")
+	order := fs.String("order", "", "Sort order: asc|desc (default desc for filecount, asc for name)")
+	region := fs.Bool("region", false, "Detect each bucket's provider region and add a region column")
+	seenFile := fs.String("seen-file", "", "Json state file recording when each bucket was first seen; adds a firstSeen column")
+	newBucketsOnly := fs.Bool("new-buckets-only", false, "Only output buckets not already in -seen-file")
+	addEncryptFlag(fs)
+	match := fs.String("match", "", "How keywords must match the bucket name, checked client-side: prefix|contains|exact")
+	attribution := fs.Bool("attribution", false, "Guess the owning organization from bucket name tokens and add attribution/attributionScore columns")
+	attributionFiles := fs.Int("attribution-files", 0, "Refine -attribution with this many files of each bucket: domains in their paths and the company in the metadata of up to 3 pdf and office documents; one more api request per bucket, implies -attribution")
+	suppress := fs.String("suppress", "", "Yaml list of known-benign results; regex entries drop matching bucket names")
+	permute := fs.String("permute", "", "Also search the names a permutation preset builds from each keyword, e.g. @environments for acme-dev, acme-prod...; adds a keyword column")
+	parseFlags(fs, args)
+	setSuppressions(*suppress)
+
+	if flushPending {
+		paging.sendPending()
+		return
+	}
+	keywordList := paging.keywordList()
+	if *permute != "" {
+		if keywordList == nil {
+			if *paging.keywords == "" {
+				log.Fatalln("-permute needs -keywords or -keywords-file")
 			}
-		} else {
-			out, _ := json.MarshalIndent(allBuckets, "", "  ")
-			os.Stdout.Write(out)
+			keywordList = []string{*paging.keywords}
+		}
+		presets, err := loadPresets()
+		if err != nil {
+			log.Fatalf("presets: %v", err)
+		}
+		if keywordList, err = permuteKeywords(keywordList, *permute, presets.Permutations); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	api := common.client("buckets")
+	if dryRun {
+		keywords := keywordList
+		if keywords == nil {
+			keywords = []string{*paging.keywords}
+		}
+		var urls []string
+		for _, kw := range keywords {
+			urls = append(urls, api.BucketsURL(ghw.BucketsQuery{Keywords: kw, Type: *cloudType, Start: *paging.start, Limit: pageLimit(*paging.limit)}))
+		}
+		printPlan(urls, *paging.start, pageLimit(*paging.limit), *paging.maxRequests)
+		return
+	}
+	defer telemetry.flush()
+	opts := bucketsOptions{
+		keywords:         *paging.keywords,
+		keywordList:      keywordList,
+		cloudType:        *cloudType,
+		minFiles:         *minFiles,
+		maxFiles:         *maxFiles,
+		sortBy:           strings.ToLower(*sortBy),
+		order:            strings.ToLower(*order),
+		region:           *region,
+		attribution:      *attribution || *attributionFiles > 0,
+		attributionFiles: *attributionFiles,
+		match:            strings.ToLower(*match),
+		seenFile:         *seenFile,
+		newOnly:          *newBucketsOnly,
+		limit:            *paging.limit,
+		start:            *paging.start,
+		output:           *paging.output,
+		onlyBucket:       *onlyBucket,
+		preflight:        paging.apply(),
+		columns:          csvOut.apply(),
+		stableSort:       *paging.stableSort,
+	}
+	opts.format = outputFormat(strings.ToLower(*paging.format), paging.output)
+	opts.output = *paging.output
+	handleBuckets(api, newWebClient(), opts)
+}
+
+// runClusters shares the bucket search and its filters with buckets, but
+// not the output flags: clusters are written as json or a plain csv.
+func runClusters(args []string) {
+	fs := newFlagSet("clusters", "Search buckets and group them by the organization their names point to.")
+	common := addAPIFlags(fs)
+	search := addSearchFlags(fs)
+	output := fs.String("o", "", "Output csv file path. If empty, print json")
+	cloudType := fs.String("type", "", "Bucket cloud type filter: aws|azure|dos|gcp|ali")
+	minFiles := fs.Int("min-files", 0, "Only keep buckets with at least this many files")
+	maxFiles := fs.Int("max-files", 0, "Only keep buckets with at most this many files, 0 means no limit")
+	match := fs.String("match", "", "How keywords must match the bucket name, checked client-side: prefix|contains|exact")
+	suppress := fs.String("suppress", "", "Yaml list of known-benign results; regex entries drop matching bucket names")
+	parseFlags(fs, args)
+	setSuppressions(*suppress)
+
+	api := common.client("clusters")
+	if dryRun {
+		keywords := search.keywordList()
+		if keywords == nil {
+			keywords = []string{*search.keywords}
 		}
+		var urls []string
+		for _, kw := range keywords {
+			urls = append(urls, api.BucketsURL(ghw.BucketsQuery{Keywords: kw, Type: *cloudType, Start: *search.start, Limit: pageLimit(*search.limit)}))
+		}
+		printPlan(urls, *search.start, pageLimit(*search.limit), *search.maxRequests)
+		return
 	}
+	defer telemetry.flush()
+	handleClusters(api, newWebClient(), bucketsOptions{
+		keywords:    *search.keywords,
+		keywordList: search.keywordList(),
+		cloudType:   *cloudType,
+		minFiles:    *minFiles,
+		maxFiles:    *maxFiles,
+		match:       strings.ToLower(*match),
+		limit:       *search.limit,
+		start:       *search.start,
+		output:      *output,
+		preflight:   search.apply(),
+	})
+}
+
+func runStats(args []string) {
+	fs := newFlagSet("stats", "Show how many files and buckets per provider the index holds.")
+	common := addAPIFlags(fs)
+	output := fs.String("o", "", "Output file path. If empty, print to stdout")
+	format := fs.String("format", "", "Output format: table|csv|json (default table on stdout, csv with -o)")
+	statsDiff := fs.String("diff", "", "Previous stats json snapshot to compare against")
+	parseFlags(fs, args)
+
+	api := common.client("stats")
+	defer telemetry.flush()
+	handleStats(api, strings.ToLower(*format), *statsDiff, *output)
+}
+
+func runRaw(args []string) {
+	fs := newFlagSet("raw", "Send an authenticated GET to any api path and print the response body.")
+	common := addAPIFlags(fs)
+	output := fs.String("o", "", "Write the response body to this file instead of stdout")
+	rawPath := fs.String("path", "", "API path, e.g. /files")
+	var rawParams paramList
+	fs.Var(&rawParams, "param", "Query parameter key=value (repeatable)")
+	parseFlags(fs, args)
+
+	api := common.client("raw")
+	defer telemetry.flush()
+	handleRaw(api, *rawPath, rawParams, *output)
+}
+
+func runDisclose(args []string) {
+	fs := newFlagSet("disclose", "Render a responsible-disclosure email draft for a bucket from its indexed files.")
+	common := addAPIFlags(fs)
+	bucket := fs.String("bucket", "", "Bucket id or url")
+	tmpl := fs.String("template", "", "Go text/template file to render instead of the built-in draft")
+	output := fs.String("o", "", "Write the draft to this file instead of stdout")
+	minSeverity := fs.String("min-severity", "medium", "Only list files at or above this severity: low|medium|high|critical")
+	maxFindings := fs.Int("max-findings", 20, "List at most N files, 0 means no limit")
+	noRedact := fs.Bool("no-redact", false, "Don't mask file names and drop urls in the draft")
+	suppress := fs.String("suppress", "", "Yaml list of known-benign results to drop: url, id or regex entries with reason and expires")
+	parseFlags(fs, args)
+	setSuppressions(*suppress)
+
+	api := common.client("disclose")
+	defer telemetry.flush()
+	handleDisclose(api, discloseOptions{
+		bucket:      *bucket,
+		template:    *tmpl,
+		output:      *output,
+		minSeverity: strings.ToLower(*minSeverity),
+		maxFindings: *maxFindings,
+		redact:      !*noRedact,
+	})
+}
+
+func runShare(args []string) {
+	fs := newFlagSet("share", "Summarize a files export as redacted markdown and print it, or upload it as a private gist.")
+	input := fs.String("in", "", "Files export to share: csv, json, ndjson, sqlite or parquet")
+	gist := fs.Bool("gist", false, "Upload the summary as a GitHub gist and print its url")
+	token := fs.String("token", os.Getenv("GITHUB_TOKEN"), "GitHub token with gist scope (or set env GITHUB_TOKEN)")
+	public := fs.Bool("public", false, "Make the gist public instead of secret")
+	noRedact := fs.Bool("no-redact", false, "Don't mask file names in the summary")
+	suppress := fs.String("suppress", "", "Yaml list of known-benign results to drop: url, id or regex entries with reason and expires")
+	parseFlags(fs, args)
+	setSuppressions(*suppress)
+
+	handleShare(shareOptions{
+		input:  *input,
+		gist:   *gist,
+		token:  *token,
+		public: *public,
+		redact: !*noRedact,
+	})
+}
+
+func runAnnotate(args []string) {
+	fs := newFlagSet("annotate", "Set the status, tags and note of a file url, show them, or list every annotation when no -url is given.")
+	path := fs.String("annotations", "annotations.json", "Annotations json file")
+	fileURL := fs.String("url", "", "File url to annotate")
+	status := fs.String("status", "", "Triage status: triaged|false-positive|reported")
+	clear := fs.Bool("clear", false, "Remove the annotation of -url")
+	var tags, note *string
+	fs.Func("tags", "comma separated tags, replacing the current ones", func(v string) error {
+		tags = &v
+		return nil
+	})
+	fs.Func("note", "Free text note, replacing the current one", func(v string) error {
+		note = &v
+		return nil
+	})
+	addEncryptFlag(fs)
+	parseFlags(fs, args)
+
+	handleAnnotate(annotateOptions{
+		path:   *path,
+		url:    *fileURL,
+		status: strings.ToLower(*status),
+		tags:   tags,
+		note:   note,
+		clear:  *clear,
+	})
 }
 
-func handleStats(client *http.Client, apiKey, output string) {
-	urlStr := baseURL + "/stats"
-	data, err := doGet(client, apiKey, urlStr)
+func runPresets(args []string) {
+	fs := newFlagSet("presets", "List presets, show <name> or update them from -presets-url; the action goes last.")
+	presetsURL := fs.String("presets-url", "", "Url of a team presets json for presets update")
+	parseFlags(fs, args)
+
+	presets, err := loadPresets()
 	if err != nil {
-		log.Fatalf("request error: %v", err)
+		log.Fatalf("presets: %v", err)
 	}
-	if output == "" {
-		os.Stdout.Write(data)
-		return
+	handlePresets(presets, fs.Args(), *presetsURL)
+}
+
+// runCtx bounds every api request of the run, ending at -deadline.
+var (
+	runCtx    = context.Background()
+	cancelRun context.CancelFunc
+)
+
+// runBudget bounds how long and how many requests the paging loops may
+// use; runCtx bounds the requests themselves.
+type runBudget struct {
+	deadline    time.Time
+	maxRequests int
+	requests    int
+}
+
+var budget runBudget
+
+// spend accounts for one more request and returns which limit, if any,
+// forbids it.
+func (b *runBudget) spend() string {
+	if interrupted.Load() {
+		return "interrupted"
 	}
-	if err := os.WriteFile(output, data, 0644); err != nil {
-		log.Fatalf("write file: %v", err)
+	if runCtx.Err() != nil {
+		return "deadline"
+	}
+	if sinkFailure() != nil {
+		return "sink-failure"
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return "max-runtime"
+	}
+	if b.maxRequests > 0 && b.requests >= b.maxRequests {
+		return "max-requests"
+	}
+	b.requests++
+	return ""
+}
+
+// stopReason words the limit spend stopped a loop on.
+func stopReason(stopped string) string {
+	if stopped == "interrupted" {
+		return tr(stopped)
+	}
+	if stopped == "sink-failure" {
+		return sinkFailure().Error()
+	}
+	return tr("%s reached", stopped)
+}
+
+// interrupted is set by the first SIGINT or SIGTERM of a paging loop,
+// when interruptCh is closed too.
+var (
+	interrupted   atomic.Bool
+	interruptCh   = make(chan struct{})
+	interruptOnce sync.Once
+)
+
+// catchInterrupt makes SIGINT and SIGTERM stop the paging loops like a
+// spent budget, after the pages in flight are written; a second one quits
+// at once. The returned func restores the default handling.
+func catchInterrupt() func() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ch:
+		case <-done:
+			return
+		}
+		interrupted.Store(true)
+		interruptOnce.Do(func() {
+			close(interruptCh)
+			status.message(tr("interrupted, finishing the pages in flight (again to quit now)"))
+		})
+		select {
+		case <-ch:
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// pauseRun waits d, or less when the run reaches -deadline or
+// -max-runtime or is interrupted, and reports whether the whole wait
+// passed.
+func pauseRun(d time.Duration) bool {
+	wait := d
+	if !budget.deadline.IsZero() {
+		if left := time.Until(budget.deadline); left < wait {
+			wait = left
+		}
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return wait == d
+	case <-runCtx.Done():
+	case <-interruptCh:
+	}
+	return false
+}
+
+// progress aggregates what the paging workers report and redraws a single
+// status line on stdout, or with -status-json streams one json object per
+// update to stderr.
+type progress struct {
+	mu      sync.Mutex
+	started time.Time
+	fetched int64
+	total   int
+	retries int
+	// workers holds the offset each worker is fetching, -1 while idle
+	workers []int
+	width   int
+	// line is whether the status line is drawn: not with -quiet or when
+	// stderr isn't a terminal
+	line bool
+	// api reports the remaining request quota, when the api sends one
+	api *ghw.Client
+
+	stopped chan struct{}
+	done    sync.WaitGroup
+}
+
+// statusJSON is set by -status-json.
+var statusJSON bool
+
+// statusOut takes the status line and paging messages, keeping stdout for
+// results.
+var statusOut io.Writer = os.Stderr
+
+// quiet is set by -quiet.
+var quiet bool
+
+func addQuietFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&quiet, "quiet", false, "Print no progress or summary, only results and errors")
+}
+
+// summary prints the closing line of a run, but not with -quiet.
+func summary(line string) {
+	if !quiet {
+		fmt.Println(line)
+	}
+}
+
+// isTerminal reports whether w is a terminal, where the status line can
+// redraw itself.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// status is the progress of the running paging loop, nil outside of one;
+// its methods are nil-safe.
+var status *progress
+
+func newProgress(workers int, api *ghw.Client) *progress {
+	if quiet {
+		statusOut = io.Discard
+	}
+	p := &progress{started: time.Now(), total: -1, workers: make([]int, workers), api: api, stopped: make(chan struct{}), line: isTerminal(statusOut)}
+	for i := range p.workers {
+		p.workers[i] = -1
+	}
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		tick := time.NewTicker(500 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				p.report()
+			case <-p.stopped:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) busy(worker, offset int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.workers[worker] = offset
+	p.mu.Unlock()
+}
+
+func (p *progress) idle(worker int) {
+	p.busy(worker, -1)
+}
+
+func (p *progress) retry() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.retries++
+	p.mu.Unlock()
+}
+
+// add counts rows handled from a page; total is the api's result count.
+func (p *progress) add(rows, total int) {
+	p.mu.Lock()
+	p.fetched += int64(rows)
+	p.total = total
+	p.mu.Unlock()
+	p.report()
+}
+
+// stop ends the refresh and draws the final state.
+func (p *progress) stop() {
+	close(p.stopped)
+	p.done.Wait()
+	p.report()
+	p.message("")
+}
+
+// message prints msg on a line of its own below the status line, nothing
+// for "".
+func (p *progress) message(msg string) {
+	if p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.width > 0 {
+			fmt.Fprintln(statusOut)
+			p.width = 0
+		}
+	}
+	if msg != "" {
+		fmt.Fprintln(statusOut, msg)
+	}
+}
+
+func (p *progress) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.total == -1 {
+		return
+	}
+	elapsed := time.Since(p.started)
+	rate := float64(p.fetched) / elapsed.Seconds()
+	quota, hasQuota := p.api.RateLimit()
+	if statusJSON {
+		// idle workers show as null
+		offsets := make([]any, len(p.workers))
+		for i, offset := range p.workers {
+			if offset >= 0 {
+				offsets[i] = offset
+			}
+		}
+		event := map[string]any{
+			"fetched":    p.fetched,
+			"total":      p.total,
+			"rowsPerSec": math.Round(rate*10) / 10,
+			"retries":    p.retries,
+			"workers":    offsets,
+			"elapsedMs":  elapsed.Milliseconds(),
+		}
+		if hasQuota {
+			event["quotaRemaining"] = quota.Remaining
+			event["quotaLimit"] = quota.Limit
+		}
+		line, _ := json.Marshal(event)
+		fmt.Fprintf(os.Stderr, "%s\n", line)
+		return
+	}
+	if !p.line {
+		return
+	}
+
+	line := "\r" + tr("fetched %d", p.fetched)
+	if p.total > 0 {
+		line = "\r" + tr("fetched %d / %d", p.fetched, p.total)
+	}
+	line += tr(" %.0f/s", rate)
+	if p.retries > 0 {
+		line += tr(" %d retries", p.retries)
+	}
+	if hasQuota {
+		line += tr(" quota left %d", quota.Remaining)
+		if quota.Limit > 0 {
+			line += fmt.Sprintf("/%d", quota.Limit)
+		}
+	}
+	if len(p.workers) > 1 {
+		states := make([]string, len(p.workers))
+		for i, offset := range p.workers {
+			states[i] = "-"
+			if offset >= 0 {
+				states[i] = strconv.Itoa(offset)
+			}
+		}
+		line += " [" + strings.Join(states, " ") + "]"
+	}
+	// pad over the rest of a longer previous line
+	width := len([]rune(line))
+	if width < p.width {
+		line += strings.Repeat(" ", p.width-width)
+	}
+	p.width = width
+	fmt.Fprint(statusOut, line)
+}
+
+type preflightOptions struct {
+	enabled bool
+	yes     bool
+}
+
+// preflight runs probe, a single row request that reports the total result
+// count, prints what the full run would cost and exits unless the user agrees.
+// All of it goes to stderr, keeping stdout for the results.
+func preflight(probe func() (int, error), start, pageSize int, yes bool) {
+	began := time.Now()
+	results, err := probe()
+	if err != nil {
+		requestFailed(err)
+	}
+	latency := time.Since(began)
+
+	rows := results - start
+	if rows < 0 {
+		rows = 0
+	}
+	requests := (rows + pageSize - 1) / pageSize
+	fmt.Fprintln(os.Stderr, tr("estimate: %d rows, %d requests, ~%s", rows, requests, (latency*time.Duration(requests)).Round(time.Second)))
+	if yes {
+		return
+	}
+	fmt.Fprint(os.Stderr, tr("continue? [y/N] "))
+	var answer string
+	fmt.Scanln(&answer)
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		fmt.Fprintln(os.Stderr, tr("aborted"))
+		os.Exit(1)
+	}
+}
+
+// dryRun is set by -dry-run.
+var dryRun bool
+
+// pageLimit is the page size a -limit gives: 1000 unless 1-1000.
+func pageLimit(limit int) int {
+	if limit <= 0 || limit > 1000 {
+		return 1000
+	}
+	return limit
+}
+
+// printPlan prints the first request of each search and how the run
+// pages on from there, for -dry-run. How many pages there are depends on
+// the result count, which only the api knows; the count of the local
+// cache, when it has the searches, gives an estimate, and otherwise the
+// one request per search is the minimum.
+func printPlan(urls []string, start, pageSize, maxRequests int) {
+	fmt.Println(tr("dry run, no request is sent"))
+	for _, u := range urls {
+		fmt.Println("GET " + u)
+	}
+	fmt.Println(tr("each search pages on with start=%d, %d, ... until the result count its first page reports", start+pageSize, start+2*pageSize))
+	if requests, results := cachedPlan(urls, start, pageSize); results > 0 {
+		fmt.Println(tr("requests: about %d, going by the %d results the local cache holds for these searches", requests, results))
+	} else {
+		fmt.Println(tr("requests: at least %d, one per search, and one more for every further %d results", len(urls), pageSize))
+	}
+	fmt.Println(tr("-estimate counts the results first with one request per search"))
+	if maxRequests > 0 {
+		fmt.Println(tr("at most %d requests with -max-requests", maxRequests))
+	}
+}
+
+// paramList collects repeated -param key=value flags.
+type paramList []string
+
+func (p *paramList) String() string { return strings.Join(*p, ",") }
+
+func (p *paramList) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("expected key=value, got %q", v)
+	}
+	*p = append(*p, v)
+	return nil
+}
+
+// requestFailed reports a fatal api error.
+func requestFailed(err error) {
+	telemetry.fail(err)
+	if runCtx.Err() != nil {
+		log.Fatalln("deadline reached")
+	}
+	if hint := errorHint(err); hint != "" {
+		log.Fatalf("request error: %v\n%s", err, hint)
+	}
+	log.Fatalf("request error: %v", err)
+}
+
+// errorHint says what to do about an api error.
+func errorHint(err error) string {
+	var se *ghw.StatusError
+	if !errors.As(err, &se) {
+		return ""
+	}
+	switch {
+	case se.StatusCode == http.StatusUnauthorized:
+		return "check the api key given with -apikey, -apikey-file or GHW_API_KEY"
+	case errors.Is(se, ghw.ErrUnauthorized):
+		return "the api key is valid but its plan doesn't cover this request"
+	case errors.Is(se, ghw.ErrQuotaExceeded):
+		hint := "the api key's quota is used up"
+		if se.RetryAfter > 0 {
+			hint += " until " + time.Now().Add(se.RetryAfter).Format("15:04")
+		}
+		return hint + "; pool more keys with -apikey key1,key2"
+	case errors.Is(se, ghw.ErrRateLimited):
+		return "the api is rate limiting this key; slow down with -rate or fewer -workers"
+	case errors.Is(se, ghw.ErrBadRequest):
+		return "the api rejected a parameter; check -keywords, -ext, -bucket and -limit"
+	case errors.Is(se, ghw.ErrNotFound):
+		return "no such api path; check -api-base"
+	case se.StatusCode >= 500:
+		return "the api is having trouble; try again later or raise -retries"
+	}
+	return ""
+}
+
+// telemetryTransport times api round trips for the telemetry reporter.
+type telemetryTransport struct {
+	next http.RoundTripper
+}
+
+func (t telemetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	began := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	telemetry.request(time.Since(began))
+	return resp, err
+}
+
+// telemetryReporter collects aggregate run metrics for the opt-in -telemetry
+// endpoint. Nothing identifying is sent: no keywords, urls, keys or results.
+type telemetryReporter struct {
+	endpoint string
+	command  string
+	started  time.Time
+
+	mu         sync.Mutex
+	requests   int
+	retries    int
+	latency    time.Duration
+	maxLatency time.Duration
+	errorClass string
+	sent       bool
+}
+
+// telemetry is nil unless -telemetry is given; its methods are nil-safe.
+var telemetry *telemetryReporter
+
+func (t *telemetryReporter) request(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	t.latency += d
+	if d > t.maxLatency {
+		t.maxLatency = d
+	}
+}
+
+func (t *telemetryReporter) retry() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.retries++
+	t.mu.Unlock()
+}
+
+// fail records the error class and reports right away, since request errors
+// end the run.
+func (t *telemetryReporter) fail(err error) {
+	if t == nil {
+		return
+	}
+	class := "network"
+	var ne net.Error
+	var se *ghw.StatusError
+	switch {
+	case errors.As(err, &se):
+		// the status alone; the api's message may name the key
+		class = fmt.Sprintf("http %d", se.StatusCode)
+	case errors.As(err, &ne) && ne.Timeout():
+		class = "timeout"
+	}
+	t.mu.Lock()
+	t.errorClass = class
+	t.mu.Unlock()
+	t.flush()
+}
+
+func (t *telemetryReporter) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.sent {
+		t.mu.Unlock()
+		return
+	}
+	t.sent = true
+	payload := map[string]any{
+		"command":      t.command,
+		"durationMs":   time.Since(t.started).Milliseconds(),
+		"requests":     t.requests,
+		"retries":      t.retries,
+		"maxLatencyMs": t.maxLatency.Milliseconds(),
+		"errorClass":   t.errorClass,
+	}
+	if t.requests > 0 {
+		payload["avgLatencyMs"] = (t.latency / time.Duration(t.requests)).Milliseconds()
+	}
+	t.mu.Unlock()
+
+	body, _ := json.Marshal(payload)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+type filesOptions struct {
+	keywords string
+	bucket   string
+	ext      string
+	noext    string
+	limit    int
+	start    int
+	output   string
+	onlyURL  bool
+
+	perBucketMax int
+	prefix       string
+	maxDepth     int
+	fileTypes    map[string]bool
+	minSeverity  string
+	minSize      int64
+	maxSize      int64
+	// modifiedAfter and modifiedBefore bound lastModified when not zero
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	// nameRegex and excludeRegex are checked against the name and the url
+	nameRegex    *regexp.Regexp
+	excludeRegex *regexp.Regexp
+	// includeBuckets, when set, and excludeBuckets hold bucketKey names
+	includeBuckets map[string]bool
+	excludeBuckets map[string]bool
+	verifySize     bool
+	splitBy        string
+	preflight      preflightOptions
+	columns        *columnMap
+	stableSort     bool
+	workers        int
+	resume         bool
+	// strict is -strict-schema
+	strict      bool
+	format      string
+	annotations map[string]annotation
+	// matchedOn holds the -keywords terms checked with -matched-on
+	matchedOn []string
+	// keywordList holds the -keywords-file searches and bucketList the
+	// buckets of -bucket -, run in turn
+	keywordList []string
+	bucketList  []string
+	// collect, when set, gets every page instead of it being written out
+	collect func([]File)
+	// filters lists the client-side filters as given, for the checkpoint
+	filters []string
+}
+
+// keepFile applies the client-side file filters.
+func keepFile(file File, opts filesOptions) bool {
+	if suppressions.file(file) {
+		return false
+	}
+	name := strings.TrimPrefix(file.Name, "/")
+	if opts.prefix != "" && !strings.HasPrefix(name, opts.prefix) {
+		return false
+	}
+	if opts.maxDepth >= 0 && strings.Count(name, "/") > opts.maxDepth {
+		return false
+	}
+	if len(opts.fileTypes) > 0 && !opts.fileTypes[strings.ToLower(file.Type)] {
+		return false
+	}
+	if opts.minSeverity != "" && severityRank[fileSeverity(file)] < severityRank[opts.minSeverity] {
+		return false
+	}
+	if file.Size < opts.minSize || (opts.maxSize > 0 && file.Size > opts.maxSize) {
+		return false
+	}
+	modified := time.Unix(file.LastModified, 0)
+	if !opts.modifiedAfter.IsZero() && !modified.After(opts.modifiedAfter) {
+		return false
+	}
+	if !opts.modifiedBefore.IsZero() && !modified.Before(opts.modifiedBefore) {
+		return false
+	}
+	if opts.nameRegex != nil && !opts.nameRegex.MatchString(file.Name) && !opts.nameRegex.MatchString(file.URL) {
+		return false
+	}
+	if opts.excludeRegex != nil && (opts.excludeRegex.MatchString(file.Name) || opts.excludeRegex.MatchString(file.URL)) {
+		return false
+	}
+	if opts.includeBuckets != nil || opts.excludeBuckets != nil {
+		bucket := bucketKey(file.Bucket)
+		if (opts.includeBuckets != nil && !opts.includeBuckets[bucket]) || opts.excludeBuckets[bucket] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSet turns a comma separated flag value into a lowercase set.
+func splitSet(v string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// suppression silences one known-benign result: a file by url or id, or
+// any file url or bucket name matching regex, until expires.
+type suppression struct {
+	URL     string `yaml:"url"`
+	ID      string `yaml:"id"`
+	Regex   string `yaml:"regex"`
+	Reason  string `yaml:"reason"`
+	Expires string `yaml:"expires"`
+
+	re *regexp.Regexp
+}
+
+type suppressionList struct {
+	entries []suppression
+	hits    int64
+}
+
+// suppressions is loaded from -suppress; nil suppresses nothing.
+var suppressions *suppressionList
+
+// loadSuppressions reads a yaml list of suppressions. Expired entries are
+// reported and left out so they get reviewed instead of silently lapsing.
+func loadSuppressions(path string) (*suppressionList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []suppression
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	today := time.Now().Format("2006-01-02")
+	l := &suppressionList{}
+	for i, e := range entries {
+		set := 0
+		for _, v := range []string{e.URL, e.ID, e.Regex} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("%s: entry %d needs exactly one of url, id or regex", path, i+1)
+		}
+		if e.Expires != "" {
+			if _, err := time.Parse("2006-01-02", e.Expires); err != nil {
+				return nil, fmt.Errorf("%s: entry %d: expires must look like 2006-01-02", path, i+1)
+			}
+			if e.Expires < today {
+				log.Printf("suppression %d (%s) expired on %s", i+1, e.Reason, e.Expires)
+				continue
+			}
+		}
+		if e.Regex != "" {
+			if e.re, err = regexp.Compile(e.Regex); err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %v", path, i+1, err)
+			}
+		}
+		l.entries = append(l.entries, e)
+	}
+	return l, nil
+}
+
+func (l *suppressionList) file(f File) bool {
+	if l == nil {
+		return false
+	}
+	id := fmt.Sprint(f.ID)
+	for _, e := range l.entries {
+		if e.URL != "" && e.URL == f.URL || e.ID != "" && e.ID == id || e.re != nil && e.re.MatchString(f.URL) {
+			atomic.AddInt64(&l.hits, 1)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *suppressionList) bucket(name string) bool {
+	if l == nil {
+		return false
+	}
+	for _, e := range l.entries {
+		if e.re != nil && e.re.MatchString(name) {
+			atomic.AddInt64(&l.hits, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// report prints how many results were suppressed, if any.
+func (l *suppressionList) report() {
+	if l == nil || l.hits == 0 {
+		return
+	}
+	fmt.Fprintln(statusOut, tr("%d results suppressed", l.hits))
+}
+
+// setSuppressions loads -suppress for the commands that honor it.
+func setSuppressions(path string) {
+	if path == "" {
+		return
+	}
+	var err error
+	if suppressions, err = loadSuppressions(path); err != nil {
+		log.Fatalf("suppress: %v", err)
+	}
+}
+
+func filesQuery(opts filesOptions) ghw.FilesQuery {
+	return ghw.FilesQuery{
+		Keywords:       opts.keywords,
+		Bucket:         opts.bucket,
+		Extensions:     opts.ext,
+		StopExtensions: opts.noext,
+	}
+}
+
+// fileSearch is one of the searches a files run makes in turn: every
+// -keywords-file line in every -bucket - bucket.
+type fileSearch struct {
+	keywords string
+	bucket   string
+}
+
+func (s fileSearch) String() string {
+	var parts []string
+	if s.keywords != "" {
+		parts = append(parts, fmt.Sprintf("keyword %q", s.keywords))
+	}
+	if s.bucket != "" {
+		parts = append(parts, "bucket "+s.bucket)
+	}
+	return strings.Join(parts, " in ")
+}
+
+func fileSearches(opts filesOptions) []fileSearch {
+	keywords := opts.keywordList
+	if keywords == nil {
+		keywords = []string{opts.keywords}
+	}
+	buckets := opts.bucketList
+	if buckets == nil {
+		buckets = []string{opts.bucket}
+	}
+	var searches []fileSearch
+	for _, b := range buckets {
+		for _, kw := range keywords {
+			searches = append(searches, fileSearch{kw, b})
+		}
+	}
+	return searches
+}
+
+func handleFiles(api *ghw.Client, web *http.Client, opts filesOptions) {
+	pageSize := opts.limit
+	if pageSize <= 0 || pageSize > 1000 {
+		pageSize = 1000
+	}
+	if _, ok := severityRank[opts.minSeverity]; opts.minSeverity != "" && !ok {
+		log.Fatalf("unknown severity %s\n", opts.minSeverity)
+	}
+	if err := opts.columns.bind(fileHeader(opts)); err != nil {
+		log.Fatalf("columns: %v", err)
+	}
+	// a checkpoint is kept for plain csv exports, the only output that can
+	// be appended to where a run stopped
+	searches := fileSearches(opts)
+	checkpointing := opts.format == "csv" && opts.output != "" && opts.splitBy == "" && !opts.stableSort && len(searches) == 1
+	cp := checkpoint{Query: filesQuery(opts), PageSize: pageSize, PerBucket: map[string]int{}, Delimiter: string(csvDelimiter), Header: opts.columns.header(fileHeader(opts)), Filters: opts.filters}
+	if opts.resume {
+		if !checkpointing {
+			log.Fatalln("-resume needs a csv export with -o, without -split-by, -stable-sort, -keywords-file and -bucket -")
+		}
+		prev, err := loadCheckpoint(checkpointPath(opts.output))
+		if err != nil {
+			log.Fatalf("read checkpoint: %v", err)
+		}
+		if prev.Query != cp.Query || prev.PageSize != cp.PageSize {
+			log.Fatalln("checkpoint was written for a different query or -limit")
+		}
+		if prev.Delimiter == "" {
+			prev.Delimiter = ","
+		}
+		if prev.Delimiter != cp.Delimiter {
+			log.Fatalf("the csv was written with -delimiter %q, resume with the same\n", prev.Delimiter)
+		}
+		if prev.Header != nil && strings.Join(prev.Header, "\n") != strings.Join(cp.Header, "\n") {
+			// a csv written by an older version or with fewer enrichment
+			// flags gets the new rows in its own columns
+			columns, dropped, err := resumeColumns(opts.columns, fileHeader(opts), prev.Header)
+			if err != nil || opts.strict {
+				log.Fatalf("the csv has the columns %s, resume with the -fields, -column-map and enrichment flags that wrote them\n", strings.Join(prev.Header, ","))
+			}
+			if len(dropped) > 0 {
+				log.Printf("the csv has the columns %s; appending without %s (-strict-schema stops instead)", strings.Join(prev.Header, ","), strings.Join(dropped, ","))
+			}
+			opts.columns = columns
+		}
+		if prev.Header != nil && strings.Join(prev.Filters, "\n") != strings.Join(cp.Filters, "\n") {
+			log.Fatalf("the csv was written with other filters (%s), resume with the same\n", strings.Join(changedFilters(prev.Filters, cp.Filters), ", "))
+		}
+		cp = prev
+		opts.start = cp.Offset
+	}
+	if opts.preflight.enabled {
+		if opts.bucketList != nil && !opts.preflight.yes {
+			log.Fatalln("-bucket - takes stdin, where -estimate asks to continue; add -yes")
+		}
+		preflight(func() (int, error) {
+			// several searches cost their sum
+			sum := 0
+			for _, s := range searches {
+				q := filesQuery(opts)
+				q.Keywords, q.Bucket, q.Start, q.Limit = s.keywords, s.bucket, opts.start, 1
+				resp, err := api.SearchFiles(runCtx, q)
+				if err != nil {
+					return 0, err
+				}
+				sum += resp.Meta.Results
+			}
+			return sum, nil
+		}, opts.start, pageSize, opts.preflight.yes)
+	}
+
+	var out *os.File
+	var w *csv.Writer
+	var flusher *csvFlusher
+	var enc *ndjsonWriter
+	var db *sql.DB
+	var pq *parquetWriter
+	var xw *xlsxWriter
+	var split *splitWriter
+	// json goes out element by element as pages arrive
+	var stdout *bufio.Writer
+	var arr *jsonArray
+	if opts.splitBy != "" {
+		if opts.format == "ndjson" || opts.format == "sqlite" || opts.format == "postgres" || opts.format == "parquet" || opts.format == "xlsx" {
+			log.Fatalf("-split-by writes csv, it can't be combined with -format %s\n", opts.format)
+		}
+		switch opts.splitBy {
+		case "bucket", "ext", "severity":
+		default:
+			log.Fatalf("unknown split-by %s\n", opts.splitBy)
+		}
+		dir := opts.output
+		if dir == "" {
+			dir = "out"
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("create dir: %v", err)
+		}
+		split = &splitWriter{dir: dir, header: opts.columns.header(fileHeader(opts)), created: map[string]bool{}}
+	} else if opts.format == "ndjson" {
+		enc = newNDJSONWriter(opts.output)
+	} else if opts.format == "sqlite" {
+		db = openSQLite(opts.output)
+		defer db.Close()
+	} else if opts.format == "postgres" {
+		db = openPostgres(opts.output)
+		defer db.Close()
+	} else if opts.format == "parquet" {
+		pq = createParquet(opts.output, parquetFileColumns)
+	} else if opts.format == "xlsx" {
+		xw = createXLSX(opts.output, opts.columns.header(fileHeader(opts)))
+	} else if opts.format == "json" {
+		stdout = bufio.NewWriter(os.Stdout)
+		arr = &jsonArray{w: stdout}
+	} else if opts.format == "csv" && opts.output == "" {
+		var done func() error
+		w, done = newCSVWriter(os.Stdout, true)
+		defer done()
+		defer w.Flush()
+		flusher = &csvFlusher{w: w}
+		w.Write(opts.columns.header(fileHeader(opts)))
+	} else if opts.format == "csv" {
+		var err error
+		if opts.resume {
+			// drop whatever was written after the last checkpointed page
+			if out, err = os.OpenFile(opts.output, os.O_RDWR, 0); err == nil {
+				if err = out.Truncate(cp.Position); err == nil {
+					_, err = out.Seek(cp.Position, io.SeekStart)
+				}
+			}
+		} else {
+			out, err = os.Create(opts.output)
+		}
+		if err != nil {
+			log.Fatalf("create csv: %v", err)
+		}
+		defer out.Close()
+		var done func() error
+		w, done = newCSVWriter(out, !opts.resume)
+		defer done()
+		defer w.Flush()
+		flusher = &csvFlusher{w: w, f: out}
+		if !opts.resume {
+			w.Write(opts.columns.header(fileHeader(opts)))
+		}
+	}
+
+	// write/collect; synced tells whether the csv is on disk up to the
+	// last page emitted
+	synced := false
+	emit := func(page []File) {
+		if opts.collect == nil {
+			sinkFiles(page)
+			elastic.addFiles(page)
+			for _, file := range page {
+				if opts.onlyURL {
+					webhook.add(map[string]string{"url": file.URL})
+				} else {
+					webhook.add(file)
+				}
+			}
+		}
+		if opts.collect != nil {
+			opts.collect(page)
+		} else if split != nil {
+			groups := map[string][][]string{}
+			for _, file := range page {
+				key := splitKey(file, opts.splitBy)
+				groups[key] = append(groups[key], opts.columns.row(fileRecord(file, opts)))
+			}
+			for key, rows := range groups {
+				if err := split.write(key, rows); err != nil {
+					log.Fatalf("write csv: %v", err)
+				}
+			}
+		} else if w != nil {
+			for _, file := range page {
+				w.Write(opts.columns.row(fileRecord(file, opts)))
+			}
+			var err error
+			if synced, err = flusher.wrote(len(page)); err != nil {
+				log.Fatalf("write csv: %v", err)
+			}
+		} else if db != nil {
+			if err := insertFiles(db, page); err != nil {
+				log.Fatalf("write database: %v", err)
+			}
+		} else if pq != nil {
+			writeParquetFiles(pq, page)
+		} else if xw != nil {
+			for _, file := range page {
+				xw.write(opts.columns.row(fileFields(file, opts, humanSize)))
+			}
+		} else if enc != nil {
+			for _, file := range page {
+				if opts.onlyURL {
+					enc.Encode(map[string]string{"url": file.URL})
+				} else {
+					enc.Encode(opts.columns.object(file))
+				}
+			}
+			if err := enc.wrote(len(page)); err != nil {
+				log.Fatalf("write ndjson: %v", err)
+			}
+		} else if opts.onlyURL {
+			for _, file := range page {
+				fmt.Fprintln(stdout, file.URL)
+			}
+			stdout.Flush()
+		} else {
+			for _, file := range page {
+				arr.write(opts.columns.object(file))
+			}
+			stdout.Flush()
+		}
+	}
+
+	type pageResult struct {
+		resp *ghw.FilesResponse
+		err  error
+	}
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	// at most workers pages are in flight, so a slot is always free
+	slots := make(chan int, workers)
+	for i := 0; i < workers; i++ {
+		slots <- i
+	}
+	fetch := func(offset int) chan pageResult {
+		ch := make(chan pageResult, 1)
+		q := filesQuery(opts)
+		q.Start, q.Limit = offset, pageSize
+		go func() {
+			slot := <-slots
+			status.busy(slot, offset)
+			resp, err := api.SearchFiles(runCtx, q)
+			status.idle(slot)
+			slots <- slot
+			ch <- pageResult{resp, err}
+		}()
+		return ch
+	}
+	status = newProgress(workers, api)
+	defer catchInterrupt()()
+	saveCP := func() {
+		var err error
+		if cp.Position, err = out.Seek(0, io.SeekCurrent); err == nil {
+			err = saveCheckpoint(checkpointPath(opts.output), cp)
+		}
+		if err != nil {
+			log.Fatalf("write checkpoint: %v", err)
+		}
+	}
+
+	var pending []File
+	// kept counts the files written, or held for -stable-sort
+	kept := 0
+	perBucket := cp.PerBucket
+	grown := 0
+	offset := opts.start
+	total := -1
+	// pages are requested up to workers ahead once the total is known, but
+	// always handled in offset order
+	var queue []chan pageResult
+	next := offset
+	stopped := ""
+	// with several searches the loop moves on to the next one once one is
+	// done; a file found by an earlier one is not repeated
+	si := 0
+	opts.keywords, opts.bucket = searches[si].keywords, searches[si].bucket
+	var found *dedupSet
+	if len(searches) > 1 {
+		found = newDedupSet()
+		defer found.close()
+	}
+	doneTotal := 0
+	for {
+		for stopped == "" && len(queue) < workers && (len(queue) == 0 || total > 0 && next < total) {
+			if stopped = budget.spend(); stopped == "" {
+				queue = append(queue, fetch(next))
+				next += pageSize
+			}
+		}
+		if len(queue) == 0 {
+			break
+		}
+		res := <-queue[0]
+		queue = queue[1:]
+		if res.err != nil && runCtx.Err() != nil {
+			// the page is fetched again by a rerun from this offset
+			stopped, queue = "deadline", nil
+			break
+		}
+		if res.err != nil {
+			requestFailed(res.err)
+		}
+		resp := res.resp
+
+		var page, fetched []File
+		for _, f := range resp.Files {
+			file := File{File: f}
+			file.Bucket = normalizeBucket(file.Bucket)
+			fetched = append(fetched, file)
+			if a, ok := opts.annotations[file.URL]; ok {
+				file.Annotation = &a
+			}
+			if opts.matchedOn != nil {
+				file.MatchedOn = matchedTerms(opts.matchedOn, file)
+			}
+			if !keepFile(file, opts) {
+				continue
+			}
+			if isNew, err := found.add(file.URL); err != nil {
+				log.Fatalf("drop repeats: %v", err)
+			} else if !isNew {
+				continue
+			}
+			if opts.keywordList != nil {
+				file.Keyword = opts.keywords
+			}
+			if opts.perBucketMax > 0 {
+				if perBucket[file.Bucket] >= opts.perBucketMax {
+					continue
+				}
+				perBucket[file.Bucket]++
+			}
+			page = append(page, file)
+		}
+		cache.addFiles(fetched)
+
+		if opts.verifySize {
+			grown += verifySizes(web, page)
+		}
+
+		// a stable order needs every page before anything is written
+		kept += len(page)
+		if opts.stableSort {
+			pending = append(pending, page...)
+		} else {
+			emit(page)
+		}
+
+		if total == -1 {
+			total = resp.Meta.Results
+		}
+		status.add(len(resp.Files), doneTotal+total)
+
+		if checkpointing {
+			// the checkpoint only moves past rows that are on disk
+			cp.Offset = offset + pageSize
+			if synced {
+				saveCP()
+			}
+		}
+
+		if len(resp.Files) < pageSize || (total > 0 && offset+pageSize >= total) {
+			if si++; si == len(searches) {
+				break
+			}
+			opts.keywords, opts.bucket = searches[si].keywords, searches[si].bucket
+			doneTotal += total
+			offset, next, total, queue = opts.start, opts.start, -1, nil
+			continue
+		}
+		offset += pageSize
+	}
+	status.stop()
+	if stopped != "" && checkpointing && !synced {
+		if err := flusher.sync(); err != nil {
+			log.Fatalf("write csv: %v", err)
+		}
+		saveCP()
+	}
+	reason := stopReason(stopped)
+	if opts.collect == nil {
+		reason += tr(", %d files saved", kept)
+	}
+	if stopped != "" && len(searches) > 1 {
+		fmt.Fprintln(statusOut, tr("%s, stopped at offset %d of %s (%d of %d)", reason, offset, searches[si], si+1, len(searches)))
+	} else if stopped != "" {
+		fmt.Fprint(statusOut, tr("%s, stopped at offset %d; rerun with -start %d to continue", reason, offset, offset))
+		if checkpointing {
+			fmt.Fprint(statusOut, tr(" or add -resume to append to the same csv"))
+		}
+		fmt.Fprintln(statusOut)
+	} else if checkpointing {
+		os.Remove(checkpointPath(opts.output))
+	}
+	if opts.stableSort {
+		sortFilesStable(pending)
+		emit(pending)
+	}
+	if pq != nil {
+		if err := pq.close(); err != nil {
+			log.Fatalf("write parquet: %v", err)
+		}
+	}
+	if xw != nil {
+		if err := xw.close(); err != nil {
+			log.Fatalf("write xlsx: %v", err)
+		}
+	}
+	if enc != nil {
+		if err := enc.close(); err != nil {
+			log.Fatalf("write ndjson: %v", err)
+		}
+	}
+
+	suppressions.report()
+	if opts.verifySize && grown > 0 {
+		fmt.Fprintln(statusOut, tr("%d files have grown since indexing", grown))
+	}
+	done := tr("completed")
+	if split != nil {
+		done = tr("completed, %d files saved to %s", len(split.created), split.dir)
+		summary(done)
+	} else if opts.output != "" {
+		done = tr("completed, saved to %s", outputName(opts.output))
+		if stopped != "" {
+			done = tr("saved to %s", outputName(opts.output))
+		}
+		summary(done)
+	} else if arr != nil && !opts.onlyURL {
+		arr.close()
+		stdout.Flush()
+	}
+	if stopped != "" {
+		done = tr("%s, stopped at offset %d", stopReason(stopped), offset)
+	}
+	// download notifies once its files are in
+	if opts.collect == nil {
+		webhook.flush()
+		elastic.flush()
+		notify("bucketsearch files", done)
+		flushSinks("bucketsearch files")
+	}
+}
+
+// jsonArray writes an indented json array one element at a time, so
+// large results never have to be held in memory.
+type jsonArray struct {
+	w     io.Writer
+	count int
+}
+
+func (a *jsonArray) write(v any) {
+	data, _ := json.MarshalIndent(v, "  ", "  ")
+	if a.count == 0 {
+		io.WriteString(a.w, "[\n  ")
+	} else {
+		io.WriteString(a.w, ",\n  ")
+	}
+	a.w.Write(data)
+	a.count++
+}
+
+func (a *jsonArray) close() {
+	if a.count == 0 {
+		io.WriteString(a.w, "[]")
+		return
+	}
+	io.WriteString(a.w, "\n]")
+}
+
+// checkpoint records how far a csv export got, so -resume can continue
+// it after the last complete page.
+type checkpoint struct {
+	Query     ghw.FilesQuery `json:"query"`
+	PageSize  int            `json:"pageSize"`
+	Offset    int            `json:"offset"`
+	Position  int64          `json:"position"`
+	PerBucket map[string]int `json:"perBucket"`
+	// Delimiter is the -delimiter of the csv; empty in checkpoints of
+	// older versions, which only wrote commas
+	Delimiter string `json:"delimiter,omitempty"`
+	// Header is the csv header after -fields, -column-map and the
+	// enrichment flags, which a resumed run must write rows of
+	Header []string `json:"header,omitempty"`
+	// Filters are the client-side filters, name=value; checkpoints of
+	// older versions have neither them nor Header
+	Filters []string `json:"filters,omitempty"`
+}
+
+func checkpointPath(output string) string {
+	return output + ".checkpoint"
+}
+
+func loadCheckpoint(path string) (checkpoint, error) {
+	var cp checkpoint
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(data, &cp)
+	if cp.PerBucket == nil {
+		cp.PerBucket = map[string]int{}
+	}
+	return cp, err
+}
+
+// saveCheckpoint replaces the checkpoint file in one rename so a crash
+// never leaves half of one behind.
+func saveCheckpoint(path string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// outputFormat resolves -format against -o for files and buckets: json
+// goes to stdout, sqlite, parquet and xlsx to the -o file and csv and
+// ndjson to either. -o - is stdout, which output is cleared to, and csv.
+func outputFormat(format string, output *string) string {
+	toStdout := *output == "-"
+	if toStdout {
+		*output = ""
+	}
+	if isPostgresURL(*output) && (format == "" || format == "postgres") {
+		return "postgres"
+	}
+	switch format {
+	case "":
+		if *output != "" || toStdout {
+			return "csv"
+		}
+		return "json"
+	case "json":
+		if *output != "" {
+			log.Fatalln("-format json prints to stdout, use csv or ndjson with -o")
+		}
+	case "sqlite", "parquet", "xlsx":
+		if *output == "" {
+			log.Fatalf("-format %s needs -o with a file\n", format)
+		}
+	case "csv", "ndjson":
+	default:
+		log.Fatalf("unknown format %s\n", format)
+	}
+	return format
+}
+
+// ndjsonWriter writes one json object per line to a file, or to stdout
+// without one. Like csvFlusher it fsyncs the file every -flush-every rows.
+// The first write error is kept for wrote and close to report.
+type ndjsonWriter struct {
+	enc  *json.Encoder
+	f    *os.File
+	rows int
+	err  error
+}
+
+func newNDJSONWriter(output string) *ndjsonWriter {
+	if output == "" {
+		return &ndjsonWriter{enc: json.NewEncoder(os.Stdout)}
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatalf("create file: %v", err)
+	}
+	return &ndjsonWriter{enc: json.NewEncoder(f), f: f}
+}
+
+func (n *ndjsonWriter) Encode(v any) {
+	if n.err == nil {
+		n.err = n.enc.Encode(v)
+	}
+}
+
+// wrote counts rows more lines, syncing once there are -flush-every.
+func (n *ndjsonWriter) wrote(rows int) error {
+	n.rows += rows
+	if n.err == nil && n.f != nil && n.rows >= csvFlushEvery {
+		n.rows = 0
+		n.err = n.f.Sync()
+	}
+	return n.err
+}
+
+func (n *ndjsonWriter) close() error {
+	if n.f == nil {
+		return n.err
+	}
+	if n.err == nil {
+		n.err = n.f.Sync()
+	}
+	if err := n.f.Close(); n.err == nil {
+		n.err = err
+	}
+	return n.err
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	url           TEXT PRIMARY KEY,
+	id            TEXT,
+	bucket        TEXT,
+	bucket_id     TEXT,
+	name          TEXT,
+	ext           TEXT,
+	size          INTEGER,
+	type          TEXT,
+	last_modified TEXT,
+	live_size     INTEGER,
+	size_delta    INTEGER,
+	keyword       TEXT,
+	matched_on    TEXT
+);
+CREATE INDEX IF NOT EXISTS files_bucket ON files (bucket);
+CREATE INDEX IF NOT EXISTS files_ext ON files (ext);
+CREATE INDEX IF NOT EXISTS files_last_modified ON files (last_modified);
+CREATE TABLE IF NOT EXISTS buckets (
+	bucket            TEXT PRIMARY KEY,
+	id                TEXT,
+	file_count        INTEGER,
+	type              TEXT,
+	region            TEXT,
+	first_seen        TEXT,
+	attribution       TEXT,
+	attribution_score REAL,
+	keyword           TEXT
+);
+CREATE INDEX IF NOT EXISTS buckets_type ON buckets (type);
+`
+
+// openSQLite opens or creates a results database. Both tables live in the
+// same file, so files and buckets runs can share one database; rows are
+// keyed by url and bucket name, a re-run replaces them.
+func openSQLite(path string) *sql.DB {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		log.Fatalf("open database: %v", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		log.Fatalf("create schema: %v", err)
+	}
+	if err := addLaterColumns(db); err != nil {
+		log.Fatalf("update schema: %v", err)
+	}
+	return db
+}
+
+// laterColumns were added to the tables after their first version; the
+// databases of earlier runs get them when opened.
+var laterColumns = []struct{ table, column string }{
+	{"files", "keyword"},
+	{"buckets", "keyword"},
+	{"files", "matched_on"},
+}
+
+// addLaterColumns adds the laterColumns db lacks, all of them text.
+func addLaterColumns(db *sql.DB) error {
+	for _, c := range laterColumns {
+		rows, err := db.Query("SELECT * FROM " + c.table + " LIMIT 0")
+		if err != nil {
+			return err
+		}
+		cols, err := rows.Columns()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if containsString(cols, c.column) {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + c.table + " ADD COLUMN " + c.column + " TEXT"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPage runs insert for every row in one transaction.
+func insertPage(db *sql.DB, query string, n int, args func(i int) []any) error {
+	tx, err := db.Begin()
+	if err == nil {
+		var stmt *sql.Stmt
+		if stmt, err = tx.Prepare(query); err == nil {
+			for i := 0; i < n && err == nil; i++ {
+				_, err = stmt.Exec(args(i)...)
+			}
+			stmt.Close()
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	return err
+}
+
+func insertFiles(db *sql.DB, files []File) error {
+	query := `INSERT OR REPLACE INTO files
+		(url, id, bucket, bucket_id, name, ext, size, type, last_modified, live_size, size_delta, keyword, matched_on)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if isPostgres(db) {
+		query = upsertQuery("files", "url", []string{"url", "id", "bucket", "bucket_id", "name", "ext", "size", "type", "last_modified", "live_size", "size_delta", "keyword", "matched_on"})
+	}
+	return insertPage(db, query, len(files), func(i int) []any {
+		f := files[i]
+		return []any{f.URL, fmt.Sprint(f.ID), f.Bucket, fmt.Sprint(f.BucketID), f.Name, fileExt(f.Name),
+			f.Size, f.Type, time.Unix(f.LastModified, 0).UTC().Format(time.RFC3339), f.LiveSize, f.SizeDelta, f.Keyword, strings.Join(f.MatchedOn, ";")}
+	})
+}
+
+func insertBuckets(db *sql.DB, buckets []Bucket) error {
+	query := `INSERT OR REPLACE INTO buckets
+		(bucket, id, file_count, type, region, first_seen, attribution, attribution_score, keyword)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if isPostgres(db) {
+		query = upsertQuery("buckets", "bucket", []string{"bucket", "id", "file_count", "type", "region", "first_seen", "attribution", "attribution_score", "keyword"})
+	}
+	return insertPage(db, query, len(buckets), func(i int) []any {
+		b := buckets[i]
+		return []any{b.Name, fmt.Sprint(b.ID), b.FileCount, b.Type, b.Region, b.FirstSeen, b.Attribution, b.AttributionScore, b.Keyword}
+	})
+}
+
+// csvEncoding, csvBOM, csvDelimiter and csvFlushEvery are set from
+// -encoding, -bom, -delimiter and -flush-every.
+var (
+	csvEncoding   = "utf8"
+	csvBOM        bool
+	csvDelimiter  = ','
+	csvFlushEvery = 1000
+)
+
+// csvFlusher flushes a csv writer and fsyncs its file once -flush-every
+// rows are buffered. It is only asked at page ends, so what reaches the
+// disk always ends with a whole page. One without a file writes to stdout
+// and flushes every page, for whatever reads the other end of the pipe.
+type csvFlusher struct {
+	w    *csv.Writer
+	f    *os.File
+	rows int
+}
+
+// wrote counts n more rows and reports whether they were synced.
+func (c *csvFlusher) wrote(n int) (bool, error) {
+	c.rows += n
+	if c.rows < csvFlushEvery && c.f != nil {
+		return false, nil
+	}
+	return true, c.sync()
+}
+
+func (c *csvFlusher) sync() error {
+	c.rows = 0
+	c.w.Flush()
+	if err := c.w.Error(); err != nil || c.f == nil {
+		return err
+	}
+	return c.f.Sync()
+}
+
+// newCSVWriter wraps out according to -encoding and -bom; fresh tells
+// whether out is at the start of a new file, the only place a BOM belongs.
+// done drains the encoder and must run after the last Flush.
+func newCSVWriter(out io.Writer, fresh bool) (w *csv.Writer, done func() error) {
+	if csvEncoding == "gbk" {
+		tw := transform.NewWriter(out, encoding.ReplaceUnsupported(simplifiedchinese.GBK.NewEncoder()))
+		w = csv.NewWriter(tw)
+		w.Comma = csvDelimiter
+		return w, tw.Close
+	}
+	if csvBOM && fresh {
+		io.WriteString(out, "\ufeff")
+	}
+	w = csv.NewWriter(out)
+	w.Comma = csvDelimiter
+	return w, func() error { return nil }
+}
+
+// sortFilesStable orders files by bucket, name and id so that repeated runs
+// produce diffable output whatever order the pages arrived in.
+func sortFilesStable(files []File) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return lessID(a.ID, b.ID)
+	})
+}
+
+// lessID compares api ids, which may be numbers or strings.
+func lessID(a, b any) bool {
+	fa, aNum := a.(float64)
+	fb, bNum := b.(float64)
+	if aNum && bNum {
+		return fa < fb
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func fileHeader(opts filesOptions) []string {
+	if opts.onlyURL {
+		return []string{"url"}
+	}
+	header := []string{"id", "bucket", "bucketId", "name", "url", "size", "type", "lastModified"}
+	if opts.verifySize {
+		header = append(header, "liveSize", "sizeDelta")
+	}
+	if opts.annotations != nil {
+		header = append(header, "status", "tags", "note")
+	}
+	if opts.matchedOn != nil {
+		header = append(header, "matchedOn")
+	}
+	if opts.keywordList != nil {
+		header = append(header, "keyword")
+	}
+	return header
+}
+
+func fileRecord(file File, opts filesOptions) []string {
+	return safeRow(fileFields(file, opts, func(n int64) string { return fmt.Sprintf("%d", n) }))
+}
+
+// fileFields is a file's row for fileHeader, with sizes written by size.
+func fileFields(file File, opts filesOptions, size func(int64) string) []string {
+	if opts.onlyURL {
+		return []string{file.URL}
+	}
+	row := []string{
+		fmt.Sprint(file.ID),
+		file.Bucket,
+		fmt.Sprint(file.BucketID),
+		file.Name,
+		file.URL,
+		size(file.Size),
+		file.Type,
+		time.Unix(file.LastModified, 0).Format(time.RFC3339),
+	}
+	if opts.verifySize {
+		live, delta := "", ""
+		if file.LiveSize != nil {
+			live = size(*file.LiveSize)
+			delta = size(*file.SizeDelta)
+		}
+		row = append(row, live, delta)
+	}
+	if opts.annotations != nil {
+		var a annotation
+		if file.Annotation != nil {
+			a = *file.Annotation
+		}
+		row = append(row, a.Status, strings.Join(a.Tags, ";"), a.Note)
+	}
+	if opts.matchedOn != nil {
+		row = append(row, strings.Join(file.MatchedOn, ";"))
+	}
+	if opts.keywordList != nil {
+		row = append(row, file.Keyword)
+	}
+	return row
+}
+
+// resumeColumns maps the columns of header, as m writes them, onto have,
+// the header of the csv a run appends to, when have has only columns the
+// run writes. It returns the columns left out.
+func resumeColumns(m *columnMap, header, have []string) (*columnMap, []string, error) {
+	names, from := m.header(header), header
+	if m != nil {
+		from = m.from
+	}
+	source := map[string]string{}
+	for i, name := range names {
+		source[name] = from[i]
+	}
+	out := &columnMap{to: have}
+	if m != nil {
+		out.json = m.json
+	}
+	kept := map[string]bool{}
+	for _, name := range have {
+		src, ok := source[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("no %s column", name)
+		}
+		out.from = append(out.from, src)
+		kept[name] = true
+	}
+	var dropped []string
+	for _, name := range names {
+		if !kept[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	return out, dropped, out.bind(header)
+}
+
+// columnMap renames and reorders csv columns for external schemas. Only
+// mapped columns are written, in the order they appear in the yaml file.
+// One from -fields picks the keys of json output too.
+type columnMap struct {
+	from []string
+	to   []string
+	idx  []int
+	json bool
+}
+
+func loadColumnMap(path string) (*columnMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping of column: new_name", path)
+	}
+	m := &columnMap{}
+	nodes := doc.Content[0].Content
+	for i := 0; i+1 < len(nodes); i += 2 {
+		m.from = append(m.from, nodes[i].Value)
+		to := nodes[i+1].Value
+		if to == "" {
+			to = nodes[i].Value
+		}
+		m.to = append(m.to, to)
+	}
+	return m, nil
+}
+
+// bind resolves the mapped columns against the header a command writes.
+func (m *columnMap) bind(header []string) error {
+	if m == nil {
+		return nil
+	}
+	pos := map[string]int{}
+	for i, h := range header {
+		pos[h] = i
+	}
+	m.idx = m.idx[:0]
+	for _, name := range m.from {
+		i, ok := pos[name]
+		if !ok {
+			return fmt.Errorf("unknown column %q, available: %s", name, strings.Join(header, ","))
+		}
+		m.idx = append(m.idx, i)
+	}
+	return nil
+}
+
+func (m *columnMap) header(header []string) []string {
+	if m == nil {
+		return header
+	}
+	return m.to
+}
+
+func (m *columnMap) row(row []string) []string {
+	if m == nil {
+		return row
+	}
+	out := make([]string, len(m.idx))
+	for i, j := range m.idx {
+		out[i] = row[j]
+	}
+	return out
+}
+
+// object is v as json with only the -fields keys, in their order. The
+// annotation columns come out of the nested annotation.
+func (m *columnMap) object(v any) any {
+	if m == nil || !m.json {
+		return v
+	}
+	data, _ := json.Marshal(v)
+	var all, annotation map[string]json.RawMessage
+	json.Unmarshal(data, &all)
+	json.Unmarshal(all["annotation"], &annotation)
+	obj := make(jsonObject, 0, len(m.from))
+	for _, key := range m.from {
+		val, ok := all[key]
+		if !ok {
+			val, ok = annotation[key]
+		}
+		if !ok {
+			val = json.RawMessage("null")
+		}
+		obj = append(obj, jsonField{key, val})
+	}
+	return obj
+}
+
+// jsonObject is a json object keeping its keys in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key string
+	val json.RawMessage
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(f.val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// escapeCSV guards spreadsheet users against formula injection through
+// attacker controlled names; turned off with -no-csv-escape.
+var escapeCSV = true
+
+// safeRow prefixes cells that a spreadsheet would evaluate as a formula
+// with a single quote. Plain numbers such as -5 are left alone.
+func safeRow(row []string) []string {
+	if !escapeCSV {
+		return row
+	}
+	for i, cell := range row {
+		if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			continue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err == nil {
+			continue
+		}
+		row[i] = "'" + cell
+	}
+	return row
+}
+
+// unguardCell undoes the formula guard safeRow adds to csv cells.
+func unguardCell(v string) string {
+	if strings.HasPrefix(v, "'") && len(v) > 1 && strings.ContainsRune("=+-@\t\r", rune(v[1])) {
+		return v[1:]
+	}
+	return v
+}
+
+func splitKey(file File, by string) string {
+	switch by {
+	case "ext":
+		if ext := fileExt(file.Name); ext != "" {
+			return ext
+		}
+		return "noext"
+	case "severity":
+		return fileSeverity(file)
+	}
+	return file.Bucket
+}
+
+func fileExt(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+var severityRank = map[string]int{"low": 0, "medium": 1, "high": 2, "critical": 3}
+
+var (
+	criticalExts = map[string]bool{
+		"sql": true, "bak": true, "dump": true, "db": true, "sqlite": true, "mdb": true,
+		"pem": true, "key": true, "p12": true, "pfx": true, "env": true, "kdbx": true, "ppk": true,
+	}
+	criticalNames = []string{"id_rsa", "id_dsa", "password", "passwd", "credential", "secret", ".env"}
+	highExts      = map[string]bool{
+		"csv": true, "xls": true, "xlsx": true, "json": true, "xml": true, "yml": true, "yaml": true,
+		"conf": true, "config": true, "ini": true, "log": true, "zip": true, "tar": true, "gz": true,
+		"tgz": true, "7z": true, "rar": true,
+	}
+	mediumExts = map[string]bool{
+		"doc": true, "docx": true, "pdf": true, "txt": true, "ppt": true, "pptx": true,
+		"rtf": true, "odt": true, "eml": true, "msg": true,
+	}
+)
+
+// fileSeverity is a name-based guess at how sensitive a file is likely to be.
+func fileSeverity(file File) string {
+	name := strings.ToLower(path.Base(file.Name))
+	ext := fileExt(name)
+	if criticalExts[ext] {
+		return "critical"
+	}
+	for _, n := range criticalNames {
+		if strings.Contains(name, n) {
+			return "critical"
+		}
+	}
+	if highExts[ext] {
+		return "high"
+	}
+	if mediumExts[ext] {
+		return "medium"
+	}
+	return "low"
+}
+
+// splitWriter appends rows to one csv per key inside dir. Files are opened
+// per write so a sweep over thousands of buckets never runs out of fds.
+type splitWriter struct {
+	dir     string
+	header  []string
+	created map[string]bool
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// file returns the file name key is written to and its path.
+func (s *splitWriter) file(key string) (name, path string) {
+	name = unsafeFileChars.ReplaceAllString(key, "_")
+	if name == "" || strings.Trim(name, ".") == "" {
+		name = "_"
+	}
+	return name, filepath.Join(s.dir, name+".csv")
+}
+
+func (s *splitWriter) write(key string, rows [][]string) error {
+	name, path := s.file(key)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !s.created[name] {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	w, done := newCSVWriter(f, !s.created[name])
+	if !s.created[name] {
+		w.Write(s.header)
+		s.created[name] = true
+	}
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := done(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type bucketsOptions struct {
+	keywords    string
+	keywordList []string
+	cloudType   string
+	minFiles    int
+	maxFiles    int
+	sortBy      string
+	order       string
+	region      bool
+	attribution bool
+	// attributionFiles is -attribution-files
+	attributionFiles int
+	match            string
+	seenFile         string
+	newOnly          bool
+	limit            int
+	start            int
+	output           string
+	onlyBucket       bool
+	preflight        preflightOptions
+	columns          *columnMap
+	stableSort       bool
+	format           string
+}
+
+func bucketsQuery(opts bucketsOptions) ghw.BucketsQuery {
+	return ghw.BucketsQuery{
+		Keywords: opts.keywords,
+		Type:     opts.cloudType,
+	}
+}
+
+func handleBuckets(api *ghw.Client, web *http.Client, opts bucketsOptions) {
+	switch opts.sortBy {
+	case "", "filecount", "name":
+	default:
+		log.Fatalf("unknown sort %s\n", opts.sortBy)
+	}
+	switch opts.order {
+	case "", "asc", "desc":
+	default:
+		log.Fatalf("unknown order %s\n", opts.order)
+	}
+
+	if opts.stableSort && opts.sortBy == "" {
+		opts.sortBy, opts.order = "name", "asc"
+	}
+	if err := opts.columns.bind(bucketHeader(opts)); err != nil {
+		log.Fatalf("columns: %v", err)
+	}
+
+	var allBuckets []Bucket
+	var w *csv.Writer
+	var flusher *csvFlusher
+	var enc *ndjsonWriter
+	var db *sql.DB
+	var pq *parquetWriter
+	var xw *xlsxWriter
+	if opts.format == "ndjson" {
+		enc = newNDJSONWriter(opts.output)
+	} else if opts.format == "sqlite" {
+		db = openSQLite(opts.output)
+		defer db.Close()
+	} else if opts.format == "postgres" {
+		db = openPostgres(opts.output)
+		defer db.Close()
+	} else if opts.format == "parquet" {
+		pq = createParquet(opts.output, parquetBucketColumns)
+	} else if opts.format == "xlsx" {
+		xw = createXLSX(opts.output, opts.columns.header(bucketHeader(opts)))
+	} else if opts.format == "csv" && opts.output == "" {
+		var done func() error
+		w, done = newCSVWriter(os.Stdout, true)
+		defer done()
+		defer w.Flush()
+		flusher = &csvFlusher{w: w}
+		w.Write(opts.columns.header(bucketHeader(opts)))
+	} else if opts.format == "csv" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			log.Fatalf("create csv: %v", err)
+		}
+		defer f.Close()
+		var done func() error
+		w, done = newCSVWriter(f, true)
+		defer done()
+		defer w.Flush()
+		flusher = &csvFlusher{w: w, f: f}
+		w.Write(opts.columns.header(bucketHeader(opts)))
+	}
+
+	fetchBuckets(api, web, opts, func(page []Bucket) {
+		elastic.addBuckets(page)
+		for _, b := range page {
+			if opts.onlyBucket {
+				webhook.add(map[string]string{"bucket": b.Name})
+			} else {
+				webhook.add(b)
+			}
+		}
+		// sorting needs every page, so only stream when unsorted
+		if db != nil {
+			// a table has no order to keep
+			if err := insertBuckets(db, page); err != nil {
+				log.Fatalf("write database: %v", err)
+			}
+		} else if w != nil && opts.sortBy == "" {
+			writeBuckets(w, page, opts)
+			if _, err := flusher.wrote(len(page)); err != nil {
+				log.Fatalf("write csv: %v", err)
+			}
+		} else if pq != nil && opts.sortBy == "" {
+			writeParquetBuckets(pq, page)
+		} else if xw != nil && opts.sortBy == "" {
+			writeXLSXBuckets(xw, page, opts)
+		} else if enc != nil && opts.sortBy == "" {
+			encodeBuckets(enc, page, opts)
+			if err := enc.wrote(len(page)); err != nil {
+				log.Fatalf("write ndjson: %v", err)
+			}
+		} else {
+			allBuckets = append(allBuckets, page...)
+		}
+	})
+
+	if opts.sortBy != "" {
+		sortBuckets(allBuckets, opts.sortBy, opts.order)
+	}
+
+	if w != nil || enc != nil || db != nil || pq != nil || xw != nil {
+		if w != nil && opts.sortBy != "" {
+			writeBuckets(w, allBuckets, opts)
+		}
+		if pq != nil {
+			if opts.sortBy != "" {
+				writeParquetBuckets(pq, allBuckets)
+			}
+			if err := pq.close(); err != nil {
+				log.Fatalf("write parquet: %v", err)
+			}
+		}
+		if xw != nil {
+			if opts.sortBy != "" {
+				writeXLSXBuckets(xw, allBuckets, opts)
+			}
+			if err := xw.close(); err != nil {
+				log.Fatalf("write xlsx: %v", err)
+			}
+		}
+		if enc != nil {
+			if opts.sortBy != "" {
+				encodeBuckets(enc, allBuckets, opts)
+			}
+			if err := enc.close(); err != nil {
+				log.Fatalf("write ndjson: %v", err)
+			}
+		}
+		if opts.output != "" {
+			summary(tr("completed, saved to %s", outputName(opts.output)))
+		}
+	} else {
+		if opts.onlyBucket {
+			for _, b := range allBuckets {
+				fmt.Println(b.Name)
+			}
+		} else {
+			var v any = allBuckets
+			if opts.columns != nil && opts.columns.json {
+				objs := make([]any, len(allBuckets))
+				for i, b := range allBuckets {
+					objs[i] = opts.columns.object(b)
+				}
+				v = objs
+			}
+			out, _ := json.MarshalIndent(v, "", "  ")
+			os.Stdout.Write(out)
+		}
+	}
+	webhook.flush()
+	elastic.flush()
+	if opts.output != "" {
+		notify("bucketsearch buckets", tr("completed, saved to %s", outputName(opts.output)))
+	} else {
+		notify("bucketsearch buckets", tr("completed"))
+	}
+}
+
+// fetchBuckets pages through /buckets, applies the client-side filters and
+// enrichments from opts and hands each resulting page to fn.
+func fetchBuckets(api *ghw.Client, web *http.Client, opts bucketsOptions, fn func(page []Bucket)) {
+	pageSize := opts.limit
+	if pageSize <= 0 || pageSize > 1000 {
+		pageSize = 1000
+	}
+	switch opts.match {
+	case "", "prefix", "contains", "exact":
+	default:
+		log.Fatalf("unknown match %s\n", opts.match)
+	}
+	if opts.newOnly && opts.seenFile == "" {
+		log.Fatalln("-new-buckets-only needs -seen-file")
+	}
+	keywords := opts.keywordList
+	if keywords == nil {
+		keywords = []string{opts.keywords}
+	}
+	if opts.preflight.enabled {
+		preflight(func() (int, error) {
+			sum := 0
+			for _, kw := range keywords {
+				q := bucketsQuery(opts)
+				q.Keywords, q.Start, q.Limit = kw, opts.start, 1
+				resp, err := api.SearchBuckets(runCtx, q)
+				if err != nil {
+					return 0, err
+				}
+				sum += resp.Meta.Results
+			}
+			return sum, nil
+		}, opts.start, pageSize, opts.preflight.yes)
+	}
+	var seen map[string]string
+	if opts.seenFile != "" {
+		var err error
+		if seen, err = loadSeen(opts.seenFile); err != nil {
+			log.Fatalf("read seen file: %v", err)
+		}
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	status = newProgress(1, api)
+	defer catchInterrupt()()
+	offset := opts.start
+	total := -1
+	stopped := ""
+	kept := 0
+	// -keywords-file searches run in turn, each bucket kept once
+	kw := 0
+	opts.keywords = keywords[kw]
+	var found *dedupSet
+	if opts.keywordList != nil {
+		found = newDedupSet()
+		defer found.close()
+	}
+	doneTotal := 0
+	for {
+		if stopped = budget.spend(); stopped != "" {
+			break
+		}
+		q := bucketsQuery(opts)
+		q.Start, q.Limit = offset, pageSize
+		status.busy(0, offset)
+		resp, err := api.SearchBuckets(runCtx, q)
+		status.idle(0)
+		if err != nil && runCtx.Err() != nil {
+			stopped = "deadline"
+			break
+		}
+		if err != nil {
+			requestFailed(err)
+		}
+
+		buckets := make([]Bucket, len(resp.Buckets))
+		for i, b := range resp.Buckets {
+			b.Name = normalizeBucket(b.Name)
+			buckets[i] = Bucket{Bucket: b}
+		}
+		cache.addBuckets(buckets)
+
+		// client-side filter if cloudType, fileCount range, match, suppressions
+		// or a keywords file specified
+		filtered := buckets
+		if opts.cloudType != "" || opts.minFiles > 0 || opts.maxFiles > 0 || opts.match != "" || suppressions != nil || found != nil {
+			var tmp []Bucket
+			for _, b := range buckets {
+				if suppressions.bucket(b.Name) {
+					continue
+				}
+				if opts.match != "" && !matchBucket(b.Name, opts.keywords, opts.match) {
+					continue
+				}
+				if opts.cloudType != "" && !strings.EqualFold(b.Type, opts.cloudType) {
+					continue
+				}
+				if opts.minFiles > 0 && b.FileCount < opts.minFiles {
+					continue
+				}
+				if opts.maxFiles > 0 && b.FileCount > opts.maxFiles {
+					continue
+				}
+				if found != nil {
+					if isNew, err := found.add(b.Name); err != nil {
+						log.Fatalf("drop repeats: %v", err)
+					} else if !isNew {
+						continue
+					}
+					b.Keyword = opts.keywords
+				}
+				tmp = append(tmp, b)
+			}
+			filtered = tmp
+		}
+		if seen != nil {
+			var tmp []Bucket
+			for _, b := range filtered {
+				first, ok := seen[b.Name]
+				if !ok {
+					first = now
+					seen[b.Name] = now
+				}
+				if opts.newOnly && ok {
+					continue
+				}
+				b.FirstSeen = first
+				tmp = append(tmp, b)
+			}
+			filtered = tmp
+		}
+		if opts.region {
+			detectRegions(web, filtered)
+		}
+		if opts.attribution {
+			for i := range filtered {
+				filtered[i].Attribution, filtered[i].AttributionScore = attributeBucket(filtered[i].Name)
+			}
+			if opts.attributionFiles > 0 {
+				attributeFromFiles(api, web, filtered, opts.attributionFiles)
+			}
+		}
+		fn(filtered)
+		kept += len(filtered)
+
+		if total == -1 {
+			total = resp.Meta.Results
+		}
+		status.add(len(filtered), doneTotal+total)
+
+		if len(resp.Buckets) < pageSize || (total > 0 && offset+pageSize >= total) {
+			if kw++; kw == len(keywords) {
+				break
+			}
+			opts.keywords = keywords[kw]
+			doneTotal += total
+			offset, total = opts.start, -1
+			continue
+		}
+		offset += pageSize
+	}
+	status.stop()
+	reason := tr("%s, %d buckets saved", stopReason(stopped), kept)
+	if stopped != "" && opts.keywordList != nil {
+		fmt.Fprintln(statusOut, tr("%s, stopped at offset %d of keyword %q (%d of %d)", reason, offset, opts.keywords, kw+1, len(keywords)))
+	} else if stopped != "" {
+		fmt.Fprintln(statusOut, tr("%s, stopped at offset %d; rerun with -start %d to continue", reason, offset, offset))
+	}
+	suppressions.report()
+	if seen != nil {
+		if err := saveSeen(opts.seenFile, seen); err != nil {
+			log.Fatalf("write seen file: %v", err)
+		}
+	}
+}
+
+// matchBucket checks keywords against the bare bucket name in both its
+// ascii and unicode spelling.
+func matchBucket(bucket, keywords, mode string) bool {
+	kw := strings.ToLower(strings.TrimSpace(keywords))
+	if kw == "" {
+		return true
+	}
+	ascii, uni := bucketForms(bucket)
+	for _, form := range []string{ascii, uni} {
+		name := bucketName(form)
+		switch mode {
+		case "prefix":
+			if strings.HasPrefix(name, kw) {
+				return true
+			}
+		case "exact":
+			if name == kw {
+				return true
+			}
+		default:
+			if strings.Contains(name, kw) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keywordTerms splits -keywords into its lowercase search terms; "quoted
+// phrases" stay whole and -excluded terms are left out.
+func keywordTerms(keywords string) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+	add := func(t string) {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !strings.HasPrefix(t, "-") && !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	for rest := keywords; rest != ""; {
+		rest = strings.TrimLeft(rest, " \t")
+		if strings.HasPrefix(rest, `"`) {
+			phrase, after, _ := strings.Cut(rest[1:], `"`)
+			add(phrase)
+			rest = after
+			continue
+		}
+		word, after, _ := strings.Cut(rest, " ")
+		add(word)
+		rest = after
+	}
+	return terms
+}
+
+// matchedTerms returns the terms found in the bucket, path or url of file.
+func matchedTerms(terms []string, file File) []string {
+	ascii, uni := bucketForms(file.Bucket)
+	haystack := strings.ToLower(ascii + " " + uni + " " + file.Name + " " + file.URL)
+	var matched []string
+	for _, t := range terms {
+		if strings.Contains(haystack, t) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+// annotation is an analyst's verdict on one file. Annotations are keyed by
+// file url in a json file so they survive re-runs.
+type annotation struct {
+	Status  string   `json:"status,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Note    string   `json:"note,omitempty"`
+	Updated string   `json:"updated"`
+}
+
+var annotationStatuses = map[string]bool{"triaged": true, "false-positive": true, "reported": true}
+
+// loadAnnotations reads the url -> annotation map; a missing file has none.
+func loadAnnotations(path string) (map[string]annotation, error) {
+	notes := map[string]annotation{}
+	data, err := readState(path)
+	if os.IsNotExist(err) {
+		return notes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return notes, json.Unmarshal(data, &notes)
+}
+
+type annotateOptions struct {
+	path   string
+	url    string
+	status string
+	tags   *string
+	note   *string
+	clear  bool
+}
+
+// handleAnnotate sets, clears or shows the annotation of one file url, or
+// lists them all when no url is given.
+func handleAnnotate(opts annotateOptions) {
+	notes, err := loadAnnotations(opts.path)
+	if err != nil {
+		log.Fatalf("read annotations: %v", err)
+	}
+	if opts.url == "" {
+		urls := make([]string, 0, len(notes))
+		for u := range notes {
+			urls = append(urls, u)
+		}
+		sort.Strings(urls)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, u := range urls {
+			a := notes[u]
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u, a.Status, strings.Join(a.Tags, ","), a.Note)
+		}
+		tw.Flush()
+		return
+	}
+
+	a, ok := notes[opts.url]
+	switch {
+	case opts.clear:
+		delete(notes, opts.url)
+	case opts.status == "" && opts.tags == nil && opts.note == nil:
+		if !ok {
+			log.Fatalf("no annotation for %s\n", opts.url)
+		}
+		out, _ := json.MarshalIndent(a, "", "  ")
+		fmt.Println(string(out))
+		return
+	default:
+		if opts.status != "" {
+			if !annotationStatuses[opts.status] {
+				log.Fatalf("unknown status %s\n", opts.status)
+			}
+			a.Status = opts.status
+		}
+		if opts.tags != nil {
+			a.Tags = nil
+			for _, t := range strings.Split(*opts.tags, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					a.Tags = append(a.Tags, t)
+				}
+			}
+		}
+		if opts.note != nil {
+			a.Note = *opts.note
+		}
+		a.Updated = time.Now().UTC().Format(time.RFC3339)
+		notes[opts.url] = a
+	}
+	if err := saveState(opts.path, notes); err != nil {
+		log.Fatalf("write annotations: %v", err)
+	}
+}
+
+// loadSeen reads the bucket -> first seen (RFC3339) map; a missing file is
+// an empty state.
+func loadSeen(path string) (map[string]string, error) {
+	seen := map[string]string{}
+	data, err := readState(path)
+	if os.IsNotExist(err) {
+		return seen, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return seen, json.Unmarshal(data, &seen)
+}
+
+func saveSeen(path string, seen map[string]string) error {
+	return saveState(path, seen)
+}
+
+// saveJSON replaces a json state file through a rename, so an interrupted
+// write never loses the previous state.
+func saveJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, data, 0644)
+}
+
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func bucketHeader(opts bucketsOptions) []string {
+	if opts.onlyBucket {
+		return []string{"bucket"}
+	}
+	header := []string{"id", "bucket", "fileCount", "type"}
+	if opts.seenFile != "" {
+		header = append(header, "firstSeen")
+	}
+	if opts.region {
+		header = append(header, "region")
+	}
+	if opts.attribution {
+		header = append(header, "attribution", "attributionScore")
+	}
+	if opts.keywordList != nil {
+		header = append(header, "keyword")
+	}
+	return header
+}
+
+func writeBuckets(w *csv.Writer, buckets []Bucket, opts bucketsOptions) {
+	for _, b := range buckets {
+		w.Write(opts.columns.row(safeRow(bucketRecord(b, opts))))
+	}
+}
+
+func writeXLSXBuckets(xw *xlsxWriter, buckets []Bucket, opts bucketsOptions) {
+	for _, b := range buckets {
+		xw.write(opts.columns.row(bucketRecord(b, opts)))
+	}
+}
+
+// bucketRecord is a bucket's row for bucketHeader.
+func bucketRecord(b Bucket, opts bucketsOptions) []string {
+	if opts.onlyBucket {
+		return []string{b.Name}
+	}
+	row := []string{
+		fmt.Sprint(b.ID),
+		b.Name,
+		fmt.Sprintf("%d", b.FileCount),
+		b.Type,
+	}
+	if opts.seenFile != "" {
+		row = append(row, b.FirstSeen)
+	}
+	if opts.region {
+		row = append(row, b.Region)
+	}
+	if opts.attribution {
+		row = append(row, b.Attribution, fmt.Sprintf("%.2f", b.AttributionScore))
+	}
+	if opts.keywordList != nil {
+		row = append(row, b.Keyword)
+	}
+	return row
+}
+
+func encodeBuckets(enc *ndjsonWriter, buckets []Bucket, opts bucketsOptions) {
+	for _, b := range buckets {
+		if opts.onlyBucket {
+			enc.Encode(map[string]string{"bucket": b.Name})
+		} else {
+			enc.Encode(opts.columns.object(b))
+		}
+	}
+}
+
+// sortBuckets sorts by fileCount (default desc) or name (default asc).
+func sortBuckets(buckets []Bucket, by, order string) {
+	desc := order == "desc" || (order == "" && by == "filecount")
+	sort.SliceStable(buckets, func(i, j int) bool {
+		a, b := buckets[i], buckets[j]
+		if desc {
+			a, b = b, a
+		}
+		if by == "filecount" && a.FileCount != b.FileCount {
+			return a.FileCount < b.FileCount
+		}
+		if an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name); an != bn {
+			return an < bn
+		}
+		return lessID(a.ID, b.ID)
+	})
+}
+
+// verifySizes HEADs every file url concurrently and records the live size
+// and its difference to the indexed size. It returns how many files grew.
+func verifySizes(client *http.Client, files []File) int {
+	var wg sync.WaitGroup
+	var grown int64
+	sem := make(chan struct{}, 8)
+	for i := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(f *File) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			size, ok := liveSize(client, f.URL)
+			if !ok {
+				return
+			}
+			delta := size - f.Size
+			f.LiveSize, f.SizeDelta = &size, &delta
+			if delta > 0 {
+				atomic.AddInt64(&grown, 1)
+			}
+		}(&files[i])
+	}
+	wg.Wait()
+	return int(grown)
+}
+
+func liveSize(client *http.Client, fileURL string) (int64, bool) {
+	// no Authorization header: file urls point at the storage provider
+	req, err := http.NewRequest("HEAD", fileURL, nil)
+	if err != nil {
+		return 0, false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 || resp.ContentLength < 0 {
+		return 0, false
+	}
+	return resp.ContentLength, true
+}
+
+// idnOutput is the -idn form bucket names are rewritten to, "" keeps them
+// as the api returned them.
+var idnOutput string
+
+// bucketForms returns the ascii (punycode) and unicode spelling of a bucket
+// hostname so filters can match either. Names that aren't valid IDNs come
+// back unchanged in both forms.
+func bucketForms(bucket string) (ascii, uni string) {
+	ascii, uni = bucket, bucket
+	if strings.IndexFunc(bucket, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		if a, err := idna.Lookup.ToASCII(bucket); err == nil {
+			ascii = a
+		}
+	}
+	if strings.Contains(strings.ToLower(bucket), "xn--") {
+		if u, err := idna.Lookup.ToUnicode(bucket); err == nil {
+			uni = u
+		}
+	}
+	return ascii, uni
+}
+
+// bucketASCII turns a bucket given on the command line into the punycode
+// form the index stores, leaving urls and plain names untouched.
+func bucketASCII(bucket string) string {
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return bucket
+	}
+	ascii, _ := bucketForms(bucket)
+	return ascii
+}
+
+func normalizeBucket(bucket string) string {
+	switch idnOutput {
+	case "ascii":
+		ascii, _ := bucketForms(bucket)
+		return ascii
+	case "unicode":
+		_, uni := bucketForms(bucket)
+		return uni
+	}
+	return bucket
+}
+
+var (
+	awsHostRegion = regexp.MustCompile(`\.s3[.-]([a-z]{2}(?:-gov)?-[a-z]+-\d)\.amazonaws\.com$`)
+	dosHostRegion = regexp.MustCompile(`\.([a-z]{3}\d)\.digitaloceanspaces\.com$`)
+	aliHostRegion = regexp.MustCompile(`\.oss-([a-z]{2}-[a-z0-9-]+?)(?:-internal)?\.aliyuncs\.com$`)
+)
+
+// detectRegions fills Region for each bucket, first from the endpoint
+// hostname and, for aws, from the x-amz-bucket-region header of a HEAD
+// request. Providers that expose neither are left empty.
+func detectRegions(client *http.Client, buckets []Bucket) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for i := range buckets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(b *Bucket) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			b.Region = bucketRegion(client, b.Name, b.Type)
+		}(&buckets[i])
+	}
+	wg.Wait()
+}
+
+func bucketRegion(client *http.Client, bucket, cloudType string) string {
+	host := strings.ToLower(bucket)
+	if u, err := url.Parse(bucket); err == nil && u.Host != "" {
+		host = strings.ToLower(u.Host)
+	}
+	for _, re := range []*regexp.Regexp{awsHostRegion, dosHostRegion, aliHostRegion} {
+		if m := re.FindStringSubmatch(host); m != nil {
+			return m[1]
+		}
+	}
+	if !strings.EqualFold(cloudType, "aws") {
+		return ""
+	}
+	if !strings.Contains(host, ".") {
+		host += ".s3.amazonaws.com"
+	}
+	// no Authorization header here: the api key must never leave for a third party host
+	req, err := http.NewRequest("HEAD", "https://"+host+"/", nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	return resp.Header.Get("x-amz-bucket-region")
+}
+
+// genericTokens are bucket name parts that say nothing about the owner.
+var genericTokens = map[string]bool{
+	"prod": true, "production": true, "dev": true, "develop": true, "development": true,
+	"stage": true, "staging": true, "test": true, "testing": true, "qa": true, "uat": true,
+	"backup": true, "backups": true, "bak": true, "log": true, "logs": true, "data": true,
+	"assets": true, "static": true, "media": true, "files": true, "uploads": true, "upload": true,
+	"images": true, "img": true, "public": true, "private": true, "cdn": true, "www": true,
+	"web": true, "app": true, "apps": true, "api": true, "s3": true, "bucket": true,
+	"storage": true, "archive": true, "tmp": true, "temp": true, "internal": true,
+	"release": true, "releases": true, "build": true, "builds": true, "website": true,
+	"east": true, "west": true, "north": true, "south": true, "central": true,
+	"com": true, "net": true, "org": true,
+}
+
+// providerSuffix matches the storage endpoint part of a virtual-hosted
+// bucket hostname.
+var providerSuffix = regexp.MustCompile(`\.(s3[.-]([a-z0-9-]+\.)?amazonaws\.com|blob\.core\.windows\.net|storage\.googleapis\.com|[a-z]{3}\d\.digitaloceanspaces\.com|oss-[a-z0-9-]+\.aliyuncs\.com)$`)
+
+// bucketName reduces a bucket hostname or url to the bare bucket name,
+// e.g. https://acme-prod.s3.amazonaws.com/ -> acme-prod.
+func bucketName(bucket string) string {
+	name := strings.ToLower(bucket)
+	if u, err := url.Parse(name); err == nil && u.Host != "" {
+		name = u.Host + u.Path
+	}
+	name = strings.Trim(name, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		// path-style endpoint, e.g. storage.googleapis.com/<bucket>
+		return name[i+1:]
+	}
+	return providerSuffix.ReplaceAllString(name, "")
+}
+
+// attributeBucket guesses the owning organization from the bucket name
+// alone: the first token that isn't generic wins, with more confidence
+// when it leads the name and is the only distinctive token.
+func attributeBucket(bucket string) (string, float64) {
+	tokens := strings.FieldsFunc(bucketName(bucket), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	var candidates []int
+	for i, t := range tokens {
+		if len(t) >= 3 && !genericTokens[t] {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", 0
+	}
+	score := 0.4
+	if candidates[0] == 0 {
+		score = 0.6
+	}
+	if len(candidates) == 1 {
+		score += 0.2
+	}
+	return tokens[candidates[0]], score
+}
+
+type BucketCluster struct {
+	Name      string   `json:"name"`
+	FileCount int      `json:"fileCount"`
+	Buckets   []string `json:"buckets"`
+}
+
+// handleClusters groups the matching buckets by their distinctive name token
+// (acme-prod, acme-logs and acmecorp-backup all land in "acme") and reports
+// each cluster with its aggregate file count, largest first.
+func handleClusters(api *ghw.Client, web *http.Client, opts bucketsOptions) {
+	var all []Bucket
+	fetchBuckets(api, web, opts, func(page []Bucket) {
+		all = append(all, page...)
+	})
+	clusters := clusterBuckets(all)
+
+	if opts.output == "" {
+		out, _ := json.MarshalIndent(clusters, "", "  ")
+		os.Stdout.Write(out)
+		return
+	}
+	f, err := os.Create(opts.output)
+	if err != nil {
+		log.Fatalf("create csv: %v", err)
+	}
+	defer f.Close()
+	w, done := newCSVWriter(f, true)
+	defer done()
+	w.Write([]string{"cluster", "bucketCount", "fileCount", "buckets"})
+	for _, c := range clusters {
+		w.Write(safeRow([]string{c.Name, fmt.Sprintf("%d", len(c.Buckets)), fmt.Sprintf("%d", c.FileCount), strings.Join(c.Buckets, ";")}))
+	}
+	w.Flush()
+	summary(tr("completed, saved to %s", opts.output))
+}
+
+func clusterBuckets(buckets []Bucket) []BucketCluster {
+	byToken := map[string][]Bucket{}
+	for _, b := range buckets {
+		token, _ := attributeBucket(b.Name)
+		if token == "" {
+			token = "(other)"
+		}
+		byToken[token] = append(byToken[token], b)
+	}
+
+	// fold longer tokens into a shorter one they start with: acmecorp -> acme
+	tokens := make([]string, 0, len(byToken))
+	for t := range byToken {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if len(tokens[i]) != len(tokens[j]) {
+			return len(tokens[i]) < len(tokens[j])
+		}
+		return tokens[i] < tokens[j]
+	})
+	var roots []string
+	root := map[string]string{}
+	for _, t := range tokens {
+		root[t] = t
+		for _, r := range roots {
+			if len(r) >= 4 && r != "(other)" && strings.HasPrefix(t, r) {
+				root[t] = r
+				break
+			}
+		}
+		if root[t] == t {
+			roots = append(roots, t)
+		}
+	}
+
+	merged := map[string]*BucketCluster{}
+	for _, t := range tokens {
+		r := root[t]
+		c := merged[r]
+		if c == nil {
+			c = &BucketCluster{Name: r}
+			merged[r] = c
+		}
+		for _, b := range byToken[t] {
+			c.FileCount += b.FileCount
+			c.Buckets = append(c.Buckets, b.Name)
+		}
+	}
+
+	clusters := make([]BucketCluster, 0, len(merged))
+	for _, c := range merged {
+		sort.Strings(c.Buckets)
+		clusters = append(clusters, *c)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].FileCount != clusters[j].FileCount {
+			return clusters[i].FileCount > clusters[j].FileCount
+		}
+		return clusters[i].Name < clusters[j].Name
+	})
+	return clusters
+}
+
+type providerStat struct {
+	Provider string  `json:"provider"`
+	Buckets  int     `json:"buckets"`
+	Percent  float64 `json:"percent"`
+}
+
+type statsSnapshot struct {
+	FilesCount   int64          `json:"filesCount"`
+	BucketsCount int            `json:"bucketsCount"`
+	Providers    []providerStat `json:"providers"`
+}
+
+func newStatsSnapshot(resp ghw.StatsResponse) statsSnapshot {
+	st := resp.Stats
+	snap := statsSnapshot{
+		FilesCount: st.FilesCount,
+		Providers: []providerStat{
+			{Provider: "aws", Buckets: st.AwsCount},
+			{Provider: "azure", Buckets: st.AzureCount},
+			{Provider: "dos", Buckets: st.DosCount},
+			{Provider: "gcp", Buckets: st.GcpCount},
+			{Provider: "ali", Buckets: st.AliCount},
+		},
+	}
+	for _, p := range snap.Providers {
+		snap.BucketsCount += p.Buckets
+	}
+	for i := range snap.Providers {
+		if snap.BucketsCount > 0 {
+			snap.Providers[i].Percent = math.Round(float64(snap.Providers[i].Buckets)*10000/float64(snap.BucketsCount)) / 100
+		}
+	}
+	return snap
+}
+
+// loadStatsSnapshot reads either a `-format json` stats file or a raw
+// /stats response saved by an older version.
+func loadStatsSnapshot(path string) (statsSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return statsSnapshot{}, err
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return statsSnapshot{}, err
+	}
+	if _, ok := probe["stats"]; ok {
+		var resp ghw.StatsResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return statsSnapshot{}, err
+		}
+		return newStatsSnapshot(resp), nil
+	}
+	var snap statsSnapshot
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+func handleStats(api *ghw.Client, format, diffPath, output string) {
+	if format == "" {
+		format = "table"
+		if output != "" {
+			format = "csv"
+		}
+	}
+	switch format {
+	case "table", "csv", "json":
+	default:
+		log.Fatalf("unknown format %s\n", format)
+	}
+	var prev statsSnapshot
+	if diffPath != "" {
+		var err error
+		if prev, err = loadStatsSnapshot(diffPath); err != nil {
+			log.Fatalf("read snapshot: %v", err)
+		}
+	}
+
+	resp, err := api.Stats(runCtx)
+	if err != nil {
+		requestFailed(err)
+	}
+	snap := newStatsSnapshot(*resp)
+
+	out := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("create file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if diffPath != "" {
+		writeStatsDiff(out, format, prev, snap)
+	} else {
+		writeStats(out, format, snap)
+	}
+	if output != "" {
+		summary(tr("stats saved to %s", output))
+	}
+}
+
+func writeStats(out io.Writer, format string, snap statsSnapshot) {
+	switch format {
+	case "json":
+		b, _ := json.MarshalIndent(snap, "", "  ")
+		out.Write(append(b, '\n'))
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"provider", "buckets", "percent", "files"})
+		for _, p := range snap.Providers {
+			w.Write([]string{p.Provider, fmt.Sprintf("%d", p.Buckets), fmt.Sprintf("%.2f", p.Percent), ""})
+		}
+		w.Write([]string{"all", fmt.Sprintf("%d", snap.BucketsCount), "100.00", fmt.Sprintf("%d", snap.FilesCount)})
+		w.Flush()
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "provider\tbuckets\tpercent\t")
+		for _, p := range snap.Providers {
+			fmt.Fprintf(tw, "%s\t%s\t%.2f%%\t\n", p.Provider, humanCount(int64(p.Buckets)), p.Percent)
+		}
+		fmt.Fprintf(tw, "all\t%s\t100.00%%\t\n", humanCount(int64(snap.BucketsCount)))
+		tw.Flush()
+		fmt.Fprintf(out, "files: %s\n", humanCount(snap.FilesCount))
+	}
+}
+
+type statsDelta struct {
+	Name     string  `json:"name"`
+	Previous int64   `json:"previous"`
+	Current  int64   `json:"current"`
+	Delta    int64   `json:"delta"`
+	Percent  float64 `json:"percent"`
+}
+
+// writeStatsDiff reports per-provider bucket growth plus total buckets and
+// files between prev and cur; percent is relative to prev.
+func writeStatsDiff(out io.Writer, format string, prev, cur statsSnapshot) {
+	prevBuckets := map[string]int{}
+	for _, p := range prev.Providers {
+		prevBuckets[p.Provider] = p.Buckets
+	}
+	var rows []statsDelta
+	add := func(name string, before, after int64) {
+		d := statsDelta{Name: name, Previous: before, Current: after, Delta: after - before}
+		if before > 0 {
+			d.Percent = math.Round(float64(d.Delta)*10000/float64(before)) / 100
+		}
+		rows = append(rows, d)
+	}
+	for _, p := range cur.Providers {
+		add(p.Provider, int64(prevBuckets[p.Provider]), int64(p.Buckets))
+	}
+	add("all", int64(prev.BucketsCount), int64(cur.BucketsCount))
+	add("files", prev.FilesCount, cur.FilesCount)
+
+	switch format {
+	case "json":
+		b, _ := json.MarshalIndent(rows, "", "  ")
+		out.Write(append(b, '\n'))
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"name", "previous", "current", "delta", "percent"})
+		for _, d := range rows {
+			w.Write([]string{d.Name, fmt.Sprintf("%d", d.Previous), fmt.Sprintf("%d", d.Current), fmt.Sprintf("%d", d.Delta), fmt.Sprintf("%.2f", d.Percent)})
+		}
+		w.Flush()
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "\tprevious\tcurrent\tdelta\tchange\t")
+		for _, d := range rows {
+			sign := ""
+			if d.Delta > 0 {
+				sign = "+"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s%s\t%s%.2f%%\t\n", d.Name, humanCount(d.Previous), humanCount(d.Current), sign, humanCount(d.Delta), sign, d.Percent)
+		}
+		tw.Flush()
+	}
+}
+
+// humanCount formats n with thousands separators, e.g. 1234567 -> 1,234,567.
+func humanCount(n int64) string {
+	s := fmt.Sprintf("%d", n)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if neg {
+		return "-" + b.String()
+	}
+	return b.String()
+}
+
+func handleRaw(api *ghw.Client, path string, params paramList, output string) {
+	if !strings.HasPrefix(path, "/") {
+		log.Fatalf("raw needs -path starting with /, e.g. /files")
+	}
+	path, rawQuery, _ := strings.Cut(path, "?")
+	q, _ := url.ParseQuery(rawQuery)
+	for _, p := range params {
+		k, v, _ := strings.Cut(p, "=")
+		q.Add(k, v)
+	}
+
+	data, err := api.Get(runCtx, path, q)
+	if err != nil {
+		requestFailed(err)
+	}
+	if output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		log.Fatalf("write file: %v", err)
+	}
+	summary(tr("response saved to %s", output))
+}
+
+// abuseContacts are where each provider takes reports about exposed
+// storage, for when the owner can't be reached directly.
+var abuseContacts = map[string]string{
+	"aws":   "abuse@amazonaws.com, https://repost.aws/knowledge-center/report-aws-abuse",
+	"azure": "https://msrc.microsoft.com/report/abuse",
+	"gcp":   "https://support.google.com/code/contact/cloud_platform_report",
+	"dos":   "abuse@digitalocean.com",
+	"ali":   "https://report.aliyun.com",
+}
+
+// bucketProvider tells the cloud type from a bucket hostname.
+func bucketProvider(bucket string) string {
+	host := strings.ToLower(bucket)
+	switch {
+	case strings.Contains(host, "amazonaws.com"):
+		return "aws"
+	case strings.Contains(host, ".windows.net"):
+		return "azure"
+	case strings.Contains(host, "googleapis.com"):
+		return "gcp"
+	case strings.Contains(host, "digitaloceanspaces.com"):
+		return "dos"
+	case strings.Contains(host, "aliyuncs.com"):
+		return "ali"
+	}
+	return ""
+}
+
+// redactPath keeps the first character of every path segment and the
+// extension, so the owner recognizes the file without the draft leaking it.
+func redactPath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		ext := ""
+		if i == len(parts)-1 {
+			if j := strings.LastIndex(p, "."); j > 0 {
+				p, ext = p[:j], p[j:]
+			}
+		}
+		r := []rune(p)
+		if len(r) > 1 {
+			p = string(r[0]) + strings.Repeat("*", len(r)-1)
+		}
+		parts[i] = p + ext
+	}
+	return strings.Join(parts, "/")
+}
+
+type disclosureFinding struct {
+	Name     string
+	URL      string
+	Size     int64
+	Severity string
+}
+
+// disclosure is the data a disclose template is rendered with.
+type disclosure struct {
+	Bucket   string
+	Org      string
+	Provider string
+	Abuse    string
+	Total    int
+	Findings []disclosureFinding
+	Redacted bool
+	Date     string
+}
+
+const defaultDisclosureTemplate = `To: {{if .Org}}security contact of {{.Org}}{{else}}bucket owner{{end}}{{with .Abuse}} (provider abuse contact: {{.}}){{end}}
+Subject: Publicly accessible storage bucket {{.Bucket}}
+
+Hello,
+
+while looking into publicly indexed cloud storage we noticed that the
+bucket {{.Bucket}}{{with .Provider}} ({{.}}){{end}} can be listed and read without authentication.
+{{.Total}} files are indexed, among them:
+
+{{range .Findings}}  - [{{.Severity}}] {{.Name}}{{if .URL}} {{.URL}}{{end}} ({{.Size}} bytes)
+{{end}}{{if .Redacted}}
+File names are partly masked in this message.
+{{end}}
+We have not kept copies of the contents. Please restrict public access to
+the bucket and review whether the exposed data needs further action.
+
+Regards
+{{.Date}}
+`
+
+type discloseOptions struct {
+	bucket      string
+	template    string
+	output      string
+	minSeverity string
+	maxFindings int
+	redact      bool
+}
+
+// handleDisclose renders a disclosure email draft for one bucket from its
+// indexed files, most severe first.
+func handleDisclose(api *ghw.Client, opts discloseOptions) {
+	if opts.bucket == "" {
+		log.Fatalln("disclose needs -bucket")
+	}
+	if _, ok := severityRank[opts.minSeverity]; opts.minSeverity != "" && !ok {
+		log.Fatalf("unknown severity %s\n", opts.minSeverity)
+	}
+	text := defaultDisclosureTemplate
+	if opts.template != "" {
+		data, err := os.ReadFile(opts.template)
+		if err != nil {
+			log.Fatalf("read template: %v", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("disclose").Parse(text)
+	if err != nil {
+		log.Fatalf("parse template: %v", err)
+	}
+
+	// every page, so the draft counts and ranks all the bucket's files
+	var files []File
+	total := 0
+	it := api.Files(runCtx, ghw.FilesQuery{Bucket: bucketASCII(opts.bucket)}).Iter()
+	for it.Next() {
+		total++
+		file := File{File: it.Value()}
+		if !suppressions.file(file) && severityRank[fileSeverity(file)] >= severityRank[opts.minSeverity] {
+			files = append(files, file)
+		}
+	}
+	if err := it.Err(); err != nil {
+		requestFailed(err)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return severityRank[fileSeverity(files[i])] > severityRank[fileSeverity(files[j])]
+	})
+	if opts.maxFindings > 0 && len(files) > opts.maxFindings {
+		files = files[:opts.maxFindings]
+	}
+
+	_, uni := bucketForms(opts.bucket)
+	org, _ := attributeBucket(uni)
+	d := disclosure{
+		Bucket:   uni,
+		Org:      org,
+		Provider: bucketProvider(opts.bucket),
+		Total:    total,
+		Redacted: opts.redact,
+		Date:     time.Now().Format("2006-01-02"),
+	}
+	d.Abuse = abuseContacts[d.Provider]
+	for _, f := range files {
+		finding := disclosureFinding{Name: f.Name, URL: f.URL, Size: f.Size, Severity: fileSeverity(f)}
+		if opts.redact {
+			// the url spells out the full path, so it goes too
+			finding.Name, finding.URL = redactPath(f.Name), ""
+		}
+		d.Findings = append(d.Findings, finding)
+	}
+
+	out := os.Stdout
+	if opts.output != "" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			log.Fatalf("create file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := tmpl.Execute(out, d); err != nil {
+		log.Fatalf("render template: %v", err)
+	}
+	if opts.output != "" {
+		summary(tr("draft saved to %s", opts.output))
+	}
+}
+
+const gistAPI = "https://api.github.com/gists"
+
+// loadFindings reads a files export in any of the formats loadTable knows.
+func loadFindings(path string) ([]File, error) {
+	t, err := loadTable(path)
+	if err != nil {
+		return nil, err
+	}
+	return t.files()
+}
+
+var markdownEscaper = strings.NewReplacer(`|`, `\|`, "`", "\\`", "\n", " ", "\r", " ")
+
+// findingsMarkdown renders files as a markdown table, most severe first.
+func findingsMarkdown(files []File, redact bool) string {
+	files = append([]File(nil), files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return severityRank[fileSeverity(files[i])] > severityRank[fileSeverity(files[j])]
+	})
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bucketsearch findings\n\n%d files", len(files))
+	if redact {
+		b.WriteString(", names partly masked")
+	}
+	b.WriteString(".\n\n| severity | bucket | file | size |\n|---|---|---|---|\n")
+	for _, f := range files {
+		name := f.Name
+		if redact {
+			name = redactPath(name)
+		}
+		_, bucket := bucketForms(f.Bucket)
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", fileSeverity(f), markdownEscaper.Replace(bucket),
+			markdownEscaper.Replace(name), humanCount(f.Size))
+	}
+	return b.String()
+}
+
+// createGist uploads content as a single file gist and returns its url.
+func createGist(token, description, filename, content string, public bool) (string, error) {
+	body, _ := json.Marshal(map[string]any{
+		"description": description,
+		"public":      public,
+		"files":       map[string]any{filename: map[string]string{"content": content}},
+	})
+	req, err := http.NewRequest("POST", gistAPI, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http %d", resp.StatusCode)
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("decode: %w", err)
+	}
+	return created.HTMLURL, nil
+}
+
+type shareOptions struct {
+	input  string
+	gist   bool
+	token  string
+	public bool
+	redact bool
+}
+
+// handleShare turns a files export into a markdown summary and prints it,
+// or with -gist uploads it and prints the gist url.
+func handleShare(opts shareOptions) {
+	if opts.input == "" {
+		log.Fatalln("share needs -in, a files csv or json export")
+	}
+	loaded, err := loadFindings(opts.input)
+	if err != nil {
+		log.Fatalf("read findings: %v", err)
+	}
+	var files []File
+	for _, f := range loaded {
+		if !suppressions.file(f) {
+			files = append(files, f)
+		}
+	}
+	md := findingsMarkdown(files, opts.redact)
+	if !opts.gist {
+		fmt.Print(md)
+		return
+	}
+	if opts.token == "" {
+		log.Fatalln("share -gist needs -token or env GITHUB_TOKEN")
+	}
+	u, err := createGist(opts.token, "bucketsearch findings", "findings.md", md, opts.public)
+	if err != nil {
+		log.Fatalf("create gist: %v", err)
+	}
+	fmt.Println(u)
+}
+
+// presetSet holds the presets of each kind by name: extension lists for
+// -ext and -noext, noise lists of bucket names for -include-buckets and
+// -exclude-buckets, and permutation patterns for buckets -permute.
+type presetSet struct {
+	Extensions   map[string][]string `json:"extensions,omitempty"`
+	Noise        map[string][]string `json:"noise,omitempty"`
+	Permutations map[string][]string `json:"permutations,omitempty"`
+}
+
+type presetKind struct {
+	name string
+	sets map[string][]string
+}
+
+// kinds lists the presets of p by kind, in the order list shows them.
+func (p presetSet) kinds() []presetKind {
+	return []presetKind{{"extensions", p.Extensions}, {"noise", p.Noise}, {"permutations", p.Permutations}}
+}
+
+// merge adds the presets of o to p, replacing those of the same name.
+func (p *presetSet) merge(o presetSet) {
+	p.Extensions = mergePresets(p.Extensions, o.Extensions)
+	p.Noise = mergePresets(p.Noise, o.Noise)
+	p.Permutations = mergePresets(p.Permutations, o.Permutations)
+}
+
+func mergePresets(dst, src map[string][]string) map[string][]string {
+	if dst == nil {
+		dst = map[string][]string{}
+	}
+	for k, v := range src {
+		dst[strings.ToLower(k)] = v
+	}
+	return dst
+}
+
+// defaultPresets are the bundled presets.
+var defaultPresets = presetSet{
+	Extensions: map[string][]string{
+		"documents":    {"pdf", "doc", "docx", "odt", "rtf", "txt", "ppt", "pptx"},
+		"spreadsheets": {"xls", "xlsx", "ods", "csv"},
+		"databases":    {"sql", "db", "sqlite", "sqlite3", "mdb", "accdb", "dump", "bak"},
+		"secrets":      {"pem", "key", "p12", "pfx", "ppk", "kdbx", "env", "jks"},
+		"configs":      {"conf", "config", "ini", "yml", "yaml", "json", "xml", "properties"},
+		"archives":     {"zip", "tar", "gz", "tgz", "7z", "rar", "bz2"},
+		"mail":         {"eml", "msg", "pst", "mbox"},
+	},
+	// buckets of the AWS open data program, public on purpose
+	Noise: map[string][]string{
+		"public-datasets": {"commoncrawl", "landsat-pds", "sentinel-s2-l1c", "sentinel-s2-l2a", "noaa-goes16", "noaa-goes17",
+			"noaa-ghcn-pds", "nyc-tlc", "gdelt-open-data", "openaq-fetches", "1000genomes", "sra-pub-run-odp",
+			"irs-form-990", "aws-publicdatasets", "osm-pds", "elevation-tiles-prod", "spacenet-dataset"},
+	},
+	// {keyword} stands for each -keywords search
+	Permutations: map[string][]string{
+		"environments": {"{keyword}-dev", "{keyword}-test", "{keyword}-qa", "{keyword}-uat", "{keyword}-staging",
+			"{keyword}-stage", "{keyword}-prod", "{keyword}-production", "dev-{keyword}", "prod-{keyword}"},
+		"backups": {"{keyword}-backup", "{keyword}-backups", "{keyword}-bak", "{keyword}-archive", "{keyword}-dump",
+			"{keyword}-db", "{keyword}-logs", "backup-{keyword}"},
+		"assets": {"{keyword}-assets", "{keyword}-static", "{keyword}-media", "{keyword}-uploads", "{keyword}-files",
+			"{keyword}-public", "{keyword}-cdn", "{keyword}-images"},
+	},
+}
+
+func presetsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bucketsearch", "presets.json"), nil
+}
+
+// loadPresets returns the bundled presets overlaid with the ones synced by
+// presets update.
+func loadPresets() (presetSet, error) {
+	var presets presetSet
+	presets.merge(defaultPresets)
+	p, err := presetsPath()
+	if err != nil {
+		return presets, nil
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return presets, nil
+	}
+	if err != nil {
+		return presets, err
+	}
+	synced, err := parsePresets(data)
+	if err != nil {
+		return presets, fmt.Errorf("%s: %v", p, err)
+	}
+	presets.merge(synced)
+	return presets, nil
+}
+
+// parsePresets reads a presets json: an object with extensions, noise and
+// permutations maps, or of earlier versions a flat map of extension
+// presets.
+func parsePresets(data []byte) (presetSet, error) {
+	var set presetSet
+	if err := json.Unmarshal(data, &set); err == nil && (set.Extensions != nil || set.Noise != nil || set.Permutations != nil) {
+		for name, patterns := range set.Permutations {
+			for _, p := range patterns {
+				if !strings.Contains(p, "{keyword}") {
+					return set, fmt.Errorf("permutation %s: pattern %q has no {keyword}", name, p)
+				}
+			}
+		}
+		return set, nil
+	}
+	var flat map[string][]string
+	if err := json.Unmarshal(data, &flat); err != nil {
+		return set, err
+	}
+	return presetSet{Extensions: flat}, nil
+}
+
+// expandPresets replaces @name items in a comma separated extension list.
+func expandPresets(list string, presets map[string][]string) (string, error) {
+	if !strings.Contains(list, "@") {
+		return list, nil
+	}
+	var out []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.HasPrefix(item, "@") {
+			out = append(out, item)
+			continue
+		}
+		exts, ok := presets[strings.ToLower(item[1:])]
+		if !ok {
+			return "", fmt.Errorf("unknown preset %s", item)
+		}
+		out = append(out, exts...)
+	}
+	return strings.Join(out, ","), nil
+}
+
+// permuteKeywords returns every keyword followed by the names the
+// permutation preset name builds from it, without repeats as
+// uniqueKeywords sees them.
+func permuteKeywords(keywords []string, name string, presets map[string][]string) ([]string, error) {
+	patterns, ok := presets[strings.ToLower(strings.TrimPrefix(name, "@"))]
+	if !ok {
+		return nil, fmt.Errorf("unknown permutation preset %s", name)
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, kw := range keywords {
+		for _, p := range append([]string{"{keyword}"}, patterns...) {
+			if v := strings.ReplaceAll(p, "{keyword}", kw); !seen[keywordKey(v)] {
+				seen[keywordKey(v)] = true
+				out = append(out, v)
+			}
+		}
+	}
+	return out, nil
+}
+
+func handlePresets(presets presetSet, args []string, presetsURL string) {
+	action := "list"
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+	switch action {
+	case "list":
+		for _, kind := range presets.kinds() {
+			names := make([]string, 0, len(kind.sets))
+			for name := range kind.sets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("@%s\t%s\t%d entries\n", name, kind.name, len(kind.sets[name]))
+			}
+		}
+	case "show":
+		if len(args) < 2 {
+			log.Fatalln("usage: bucketsearch presets show <name>")
+		}
+		name := strings.ToLower(strings.TrimPrefix(args[1], "@"))
+		found := false
+		for _, kind := range presets.kinds() {
+			if list, ok := kind.sets[name]; ok {
+				fmt.Printf("%s\t%s\n", kind.name, strings.Join(list, ","))
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("unknown preset %s\n", args[1])
+		}
+	case "update":
+		if presetsURL == "" {
+			log.Fatalln("presets update needs -presets-url")
+		}
+		client := &http.Client{Timeout: 15 * time.Second}
+		resp, err := client.Get(presetsURL)
+		if err != nil {
+			log.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("request error: http %d", resp.StatusCode)
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Fatalf("request error: %v", err)
+		}
+		synced, err := parsePresets(data)
+		if err != nil {
+			log.Fatalf("decode: %v", err)
+		}
+		p, err := presetsPath()
+		if err != nil {
+			log.Fatalf("config dir: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			log.Fatalf("create dir: %v", err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			log.Fatalf("write file: %v", err)
+		}
+		n := 0
+		for _, kind := range synced.kinds() {
+			n += len(kind.sets)
+		}
+		summary(tr("%d presets saved to %s", n, p))
+	default:
+		log.Fatalf("unknown presets action %s\n", action)
 	}
-	fmt.Printf("stats saved to %s\n", output)
 }
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+	"github.com/dogadmin/bucketsearch/ghw/ghwtest"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		bad  bool
+	}{
+		{"", 0, false},
+		{"2", 2, false},
+		{"2/s", 2, false},
+		{" 120/m ", 2, false},
+		{"3600/h", 1, false},
+		{"0.5/s", 0.5, false},
+		{"0/s", 0, true},
+		{"-1/s", 0, true},
+		{"2/d", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.in)
+		if (err != nil) != tt.bad || got != tt.want {
+			t.Errorf("parseRate(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.bad)
+		}
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	tests := []struct {
+		in  string
+		abs time.Time
+		age time.Duration
+		bad bool
+	}{
+		{in: ""},
+		{in: "2024-03-01", abs: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "2024-03-01T12:30:00Z", abs: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{in: "30d", age: 30 * 24 * time.Hour},
+		{in: "2w", age: 14 * 24 * time.Hour},
+		{in: "1y", age: 365 * 24 * time.Hour},
+		{in: "1.5d", age: 36 * time.Hour},
+		{in: "12h", age: 12 * time.Hour},
+		{in: " 90m ", age: 90 * time.Minute},
+		{in: "-1d", bad: true},
+		{in: "-1h", bad: true},
+		{in: "xd", bad: true},
+		{in: "yesterday", bad: true},
+		{in: "2024-13-01", bad: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeBound(tt.in)
+		if (err != nil) != tt.bad {
+			t.Errorf("parseTimeBound(%q) error %v, want error %v", tt.in, err, tt.bad)
+			continue
+		}
+		switch {
+		case tt.bad:
+		case tt.age > 0:
+			if d := time.Since(got) - tt.age; d < 0 || d > time.Minute {
+				t.Errorf("parseTimeBound(%q) = %s, want %s ago", tt.in, got, tt.age)
+			}
+		case !got.Equal(tt.abs):
+			t.Errorf("parseTimeBound(%q) = %s, want %s", tt.in, got, tt.abs)
+		}
+	}
+}
+
+func TestFileRegexFilters(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	files := []File{
+		{File: ghw.File{Name: "db/dump.sql", URL: "https://a.s3.amazonaws.com/db/dump.sql"}},
+		{File: ghw.File{Name: "db/dump-test.sql", URL: "https://a.s3.amazonaws.com/db/dump-test.sql"}},
+		{File: ghw.File{Name: "notes.txt", URL: "https://a.s3.amazonaws.com/notes.txt"}},
+	}
+	for _, args := range [][]string{
+		{"-match", `\.sql$`, "-exclude", "test"},
+		{"-name-regex", `\.sql$`, "-exclude-regex", "test"},
+	} {
+		fs := flag.NewFlagSet("files", flag.ContinueOnError)
+		filters := addFileFilterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		opts := filesOptions{maxDepth: -1}
+		filters.apply(&opts)
+		var kept []string
+		for _, f := range files {
+			if keepFile(f, opts) {
+				kept = append(kept, f.Name)
+			}
+		}
+		if len(kept) != 1 || kept[0] != "db/dump.sql" {
+			t.Errorf("%v kept %v, want db/dump.sql", args, kept)
+		}
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	tests := []struct {
+		format, output string
+		want, wantOut  string
+	}{
+		{"", "", "json", ""},
+		{"", "out.csv", "csv", "out.csv"},
+		{"", "-", "csv", ""},
+		{"json", "", "json", ""},
+		{"ndjson", "", "ndjson", ""},
+		{"ndjson", "-", "ndjson", ""},
+		{"ndjson", "out.ndjson", "ndjson", "out.ndjson"},
+		{"parquet", "out.parquet", "parquet", "out.parquet"},
+		{"sqlite", "out.db", "sqlite", "out.db"},
+		{"", "postgres://localhost/db", "postgres", "postgres://localhost/db"},
+		{"postgres", "postgresql://localhost/db", "postgres", "postgresql://localhost/db"},
+	}
+	for _, tt := range tests {
+		output := tt.output
+		if got := outputFormat(tt.format, &output); got != tt.want || output != tt.wantOut {
+			t.Errorf("outputFormat(%q, %q) = %q, -o %q, want %q, -o %q", tt.format, tt.output, got, output, tt.want, tt.wantOut)
+		}
+	}
+}
+
+func TestSQLiteAddsLaterColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.db")
+	// a database of a version before the keyword columns
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(`CREATE TABLE files (url TEXT PRIMARY KEY, id TEXT, bucket TEXT, bucket_id TEXT, name TEXT,
+		ext TEXT, size INTEGER, type TEXT, last_modified TEXT, live_size INTEGER, size_delta INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db := openSQLite(path)
+	defer db.Close()
+	f := File{File: ghw.File{ID: "1", Bucket: "a", Name: "dump.sql", URL: "https://a.s3.amazonaws.com/dump.sql"},
+		Keyword: "dump", MatchedOn: []string{"dump", "sql"}}
+	if err := insertFiles(db, []File{f}); err != nil {
+		t.Fatal(err)
+	}
+	var keyword, matchedOn string
+	if err := db.QueryRow("SELECT keyword, matched_on FROM files WHERE url = ?", f.URL).Scan(&keyword, &matchedOn); err != nil {
+		t.Fatal(err)
+	}
+	if keyword != "dump" || matchedOn != "dump;sql" {
+		t.Errorf("got keyword %q and matched_on %q, want dump and dump;sql", keyword, matchedOn)
+	}
+	// opening it again finds the columns in place
+	if err := addLaterColumns(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := checkpointPath(filepath.Join(t.TempDir(), "out.csv"))
+	if _, err := loadCheckpoint(path); err == nil {
+		t.Fatal("loaded a checkpoint that was never saved")
+	}
+	want := checkpoint{
+		Query:     ghw.FilesQuery{Keywords: "backup", Extensions: "sql,zip", Limit: 1000},
+		PageSize:  1000,
+		Offset:    3000,
+		Position:  123456,
+		PerBucket: map[string]int{"a": 2, "b": 1},
+		Delimiter: ";",
+		Header:    []string{"url", "bucket", "size"},
+		Filters:   []string{"prefix=backups/", "match="},
+	}
+	if err := saveCheckpoint(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %+v, want %+v", got, want)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp"); len(matches) > 0 {
+		t.Errorf("left %s behind", matches[0])
+	}
+}
+
+func TestCheckpointOlderVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv.checkpoint")
+	// checkpoints written before -delimiter and the header were recorded
+	if err := os.WriteFile(path, []byte(`{"query":{"keywords":"backup"},"pageSize":100,"offset":200,"position":99}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Offset != 200 || cp.Position != 99 || cp.Delimiter != "" || cp.Header != nil || cp.Filters != nil || cp.PerBucket == nil {
+		t.Errorf("loaded %+v", cp)
+	}
+}
+
+// runTestFiles runs a files export against s with opts on top of the
+// defaults of the flags.
+func runTestFiles(t *testing.T, s *ghwtest.Server, opts filesOptions) {
+	t.Helper()
+	if opts.format == "" {
+		opts.format = "csv"
+	}
+	if opts.maxDepth == 0 {
+		opts.maxDepth = -1
+	}
+	if opts.workers == 0 {
+		opts.workers = 1
+	}
+	handleFiles(s.Client(), nil, opts)
+}
+
+func readTestCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestFilesResume(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	out := filepath.Join(t.TempDir(), "out.csv")
+	opts := filesOptions{output: out, limit: 10, filters: []string{"prefix="}}
+
+	budget = runBudget{maxRequests: 1}
+	defer func() { budget = runBudget{} }()
+	runTestFiles(t, s, opts)
+	cp, err := loadCheckpoint(checkpointPath(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Offset != 10 || !reflect.DeepEqual(cp.Filters, opts.filters) {
+		t.Fatalf("checkpoint %+v after one page", cp)
+	}
+
+	budget = runBudget{}
+	opts.resume = true
+	runTestFiles(t, s, opts)
+	if rows := readTestCSV(t, out); len(rows) != len(s.Files)+1 {
+		t.Errorf("got %d rows, want a header and %d files", len(rows), len(s.Files))
+	}
+	if _, err := os.Stat(checkpointPath(out)); err == nil {
+		t.Error("the checkpoint outlived the complete export")
+	}
+}
+
+func TestFilesResumeWithFewerColumns(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	out := filepath.Join(t.TempDir(), "out.csv")
+	opts := filesOptions{output: out, limit: 10, columns: &columnMap{from: []string{"url", "size"}, to: []string{"file_url", "size"}}}
+
+	budget = runBudget{maxRequests: 1}
+	defer func() { budget = runBudget{} }()
+	runTestFiles(t, s, opts)
+
+	// the resumed run writes more columns, in another order
+	budget = runBudget{}
+	opts.resume = true
+	opts.matchedOn = []string{"x"}
+	opts.columns = &columnMap{from: []string{"matchedOn", "size", "url"}, to: []string{"matchedOn", "size", "file_url"}}
+	runTestFiles(t, s, opts)
+	rows := readTestCSV(t, out)
+	if len(rows) != len(s.Files)+1 {
+		t.Fatalf("got %d rows, want a header and %d files", len(rows), len(s.Files))
+	}
+	for i, row := range rows[1:] {
+		if f := s.Files[i]; !reflect.DeepEqual(row, []string{f.URL, strconv.FormatInt(f.Size, 10)}) {
+			t.Errorf("row %d is %q, want the url and size of %s", i+1, row, f.URL)
+		}
+	}
+}
+
+func TestSplitBy(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	tests := []struct {
+		by   string
+		keys func(File) string
+	}{
+		{"bucket", func(f File) string { return f.Bucket }},
+		{"ext", func(f File) string {
+			if ext := fileExt(f.Name); ext != "" {
+				return ext
+			}
+			return "noext"
+		}},
+		{"severity", fileSeverity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "out")
+			runTestFiles(t, s, filesOptions{output: dir, splitBy: tt.by, limit: 10})
+
+			want := map[string]int{}
+			for _, f := range s.Files {
+				name, _ := (&splitWriter{}).file(tt.keys(File{File: f}))
+				want[name+".csv"]++
+			}
+			got := map[string]int{}
+			paths, _ := filepath.Glob(filepath.Join(dir, "*.csv"))
+			for _, path := range paths {
+				records := readTestCSV(t, path)
+				if !reflect.DeepEqual(records[0], fileHeader(filesOptions{})) {
+					t.Errorf("%s has header %q", path, records[0])
+				}
+				got[filepath.Base(path)] = len(records) - 1
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got rows per file %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSplitFileNames(t *testing.T) {
+	s := &splitWriter{dir: "out"}
+	for key, want := range map[string]string{
+		"my-bucket.prod": "my-bucket.prod",
+		"a/b:c":          "a_b_c",
+		"..":             "_",
+		"":               "_",
+		"存储桶":            "_",
+	} {
+		if name, path := s.file(key); name != want || path != filepath.Join("out", want+".csv") {
+			t.Errorf("file(%q) = %s, %s, want %s", key, name, path, want)
+		}
+	}
+}
+
+func TestColumnMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "columns.yaml")
+	os.WriteFile(path, []byte("size: file_size\nurl: file_url\nbucket:\n"), 0644)
+	m, err := loadColumnMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.bind([]string{"bucket", "url", "name", "size"}); err != nil {
+		t.Fatal(err)
+	}
+	if h := m.header(nil); !reflect.DeepEqual(h, []string{"file_size", "file_url", "bucket"}) {
+		t.Errorf("got header %q", h)
+	}
+	if row := m.row([]string{"a", "https://a/1", "1", "10"}); !reflect.DeepEqual(row, []string{"10", "https://a/1", "a"}) {
+		t.Errorf("got row %q", row)
+	}
+	if err := m.bind([]string{"bucket", "url"}); err == nil {
+		t.Error("bound size to a header without it")
+	}
+
+	os.WriteFile(path, []byte("- url\n- size\n"), 0644)
+	if _, err := loadColumnMap(path); err == nil {
+		t.Error("loaded a list as a column map")
+	}
+	var none *columnMap
+	if none.bind([]string{"url"}) != nil || !reflect.DeepEqual(none.row([]string{"x"}), []string{"x"}) {
+		t.Error("a nil column map changed the output")
+	}
+}
+
+func TestColumnMapExport(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	path := filepath.Join(t.TempDir(), "columns.yaml")
+	os.WriteFile(path, []byte("url: file_url\nsize: bytes\n"), 0644)
+	m, err := loadColumnMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.csv")
+	runTestFiles(t, s, filesOptions{output: out, columns: m})
+
+	records := readTestCSV(t, out)
+	if !reflect.DeepEqual(records[0], []string{"file_url", "bytes"}) || len(records) != len(s.Files)+1 {
+		t.Fatalf("got header %q and %d rows", records[0], len(records)-1)
+	}
+	for i, f := range s.Files {
+		if r := records[i+1]; r[0] != f.URL || r[1] != strconv.FormatInt(f.Size, 10) {
+			t.Errorf("row %d is %q, want %s and %d", i, r, f.URL, f.Size)
+		}
+	}
+}
+
+func TestLoadPresets(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	tests := []struct {
+		name, synced string
+		check        func(presetSet) bool
+	}{
+		{"bundled only", "", func(p presetSet) bool {
+			return len(p.Extensions["documents"]) > 0 && len(p.Noise["public-datasets"]) > 0 && len(p.Permutations["environments"]) > 0
+		}},
+		{"earlier flat file", `{"Finance": ["xls", "qbw"]}`, func(p presetSet) bool {
+			return reflect.DeepEqual(p.Extensions["finance"], []string{"xls", "qbw"}) && len(p.Extensions["documents"]) > 0
+		}},
+		{"kinds", `{"noise": {"cdn": ["a"]}, "permutations": {"environments": ["{keyword}-x"]}}`, func(p presetSet) bool {
+			return reflect.DeepEqual(p.Noise["cdn"], []string{"a"}) && reflect.DeepEqual(p.Permutations["environments"], []string{"{keyword}-x"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := presetsPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.Remove(path)
+			if tt.synced != "" {
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := os.WriteFile(path, []byte(tt.synced), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p, err := loadPresets()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(p) {
+				t.Errorf("loaded %+v", p)
+			}
+		})
+	}
+	if _, err := parsePresets([]byte(`{"permutations": {"bad": ["dev"]}}`)); err == nil {
+		t.Error("took a permutation pattern without {keyword}")
+	}
+}
+
+func TestPermuteKeywords(t *testing.T) {
+	presets := map[string][]string{"envs": {"{keyword}-dev", "dev-{keyword}", "{keyword}-dev"}}
+	got, err := permuteKeywords([]string{"acme", "globex"}, "@envs", presets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"acme", "acme-dev", "dev-acme", "globex", "globex-dev", "dev-globex"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := permuteKeywords([]string{"acme"}, "@nope", presets); err == nil {
+		t.Error("permuted with an unknown preset")
+	}
+}
+
+func TestUniqueKeywords(t *testing.T) {
+	got := uniqueKeywords([]string{"Acme", "acme ", "acme  corp", "ACME Corp", "acme-corp"})
+	want := []string{"Acme", "acme  corp", "acme-corp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBucketSetNoise(t *testing.T) {
+	noise := map[string][]string{"datasets": {"Open-Data", "https://landsat.s3.amazonaws.com/"}}
+	set, err := bucketSet("@datasets", noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set["open-data"] || len(set) != 2 {
+		t.Errorf("got %v", set)
+	}
+	if _, err := bucketSet("@nope", noise); err == nil {
+		t.Error("read an unknown noise preset")
+	}
+}
+
+func TestStateKeyKeepsOnlyAKeyFound(t *testing.T) {
+	defer func() { stateKeyBytes = nil }()
+	// no keychain command to find a key with
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("BUCKETSEARCH_STATE_KEY", "")
+	if _, err := stateKey(false); err == nil {
+		t.Fatal("found a state key with none set")
+	}
+	want := strings.Repeat("ab", 32)
+	t.Setenv("BUCKETSEARCH_STATE_KEY", want)
+	key, err := stateKey(true)
+	if err != nil || hex.EncodeToString(key) != want {
+		t.Fatalf("got %x, %v after a failed read, want %s", key, err, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// notifyDesktop is set by -notify-desktop.
+var notifyDesktop bool
+
+func addNotifyFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&notifyDesktop, "notify-desktop", false, "Raise a desktop notification when the run finishes (watch: when new critical files show up)")
+}
+
+var notifyFailed sync.Once
+
+// notify raises a desktop notification with -notify-desktop, using
+// notify-send on Linux, osascript on macOS and PowerShell on Windows. A
+// notification that can't be shown is reported once and otherwise ignored.
+func notify(title, message string) {
+	if !notifyDesktop {
+		return
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", "display notification "+appleScriptString(message)+" with title "+appleScriptString(title))
+	case "windows":
+		script := `Add-Type -AssemblyName System.Windows.Forms;` +
+			`$n = New-Object System.Windows.Forms.NotifyIcon;` +
+			`$n.Icon = [System.Drawing.SystemIcons]::Information;` +
+			`$n.Visible = $true;` +
+			`$n.ShowBalloonTip(10000, $env:BS_TITLE, $env:BS_MESSAGE, 'Info');` +
+			`Start-Sleep -Seconds 5; $n.Dispose()`
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		// passed through the environment to stay clear of quoting
+		cmd.Env = append(cmd.Environ(), "BS_TITLE="+title, "BS_MESSAGE="+message)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=bucketsearch", title, message)
+	}
+	if err := cmd.Run(); err != nil {
+		notifyFailed.Do(func() { log.Printf("desktop notification: %v", err) })
+	}
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
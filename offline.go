@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+// offline is set by -offline.
+var offline bool
+
+func addOfflineFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&offline, "offline", false, "Serve the pages from the local cache earlier -cache runs kept, sending no api requests and needing no api key")
+}
+
+// offlineTransport answers the /files and /buckets requests of a files or
+// buckets run from the cache, paging and filtering like the api, so the
+// rest of the run works as online. Keywords match the file url or bucket
+// name, as with query.
+type offlineTransport struct {
+	db *sql.DB
+}
+
+func (t offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	q := req.URL.Query()
+	start, _ := strconv.Atoi(q.Get("start"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	limit = pageLimit(limit)
+	var body any
+	var err error
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/files"):
+		body, err = t.files(q.Get("keywords"), q.Get("bucket"), q.Get("extensions"), q.Get("stopextensions"), start, limit)
+	case strings.HasSuffix(req.URL.Path, "/buckets"):
+		body, err = t.buckets(q.Get("keywords"), q.Get("type"), start, limit)
+	default:
+		return offlineResponse(req, http.StatusNotFound, map[string]string{"error": "Not Found", "message": "-offline only serves files and buckets"}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return offlineResponse(req, http.StatusOK, body), nil
+}
+
+func offlineResponse(req *http.Request, status int, body any) *http.Response {
+	data, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: status,
+		Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}
+}
+
+func (t offlineTransport) files(keywords, bucket, extensions, stopExtensions string, start, limit int) (*ghw.FilesResponse, error) {
+	where, args := likeTerms("url", keywords)
+	if bucket != "" {
+		where = append(where, "(bucket = ? OR bucket_id = ?)")
+		args = append(args, bucket, bucket)
+	}
+	for _, filter := range []struct {
+		list string
+		op   string
+	}{{extensions, "IN"}, {stopExtensions, "NOT IN"}} {
+		set := splitSet(filter.list)
+		if len(set) == 0 {
+			continue
+		}
+		var marks []string
+		for e := range set {
+			marks = append(marks, "?")
+			args = append(args, strings.TrimPrefix(e, "."))
+		}
+		where = append(where, "ext "+filter.op+" ("+strings.Join(marks, ", ")+")")
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	resp := &ghw.FilesResponse{Files: []ghw.File{}}
+	if err := t.db.QueryRow("SELECT count(*) FROM files"+cond, args...).Scan(&resp.Meta.Results); err != nil {
+		return nil, err
+	}
+	rows, err := t.db.Query("SELECT url, id, bucket, bucket_id, name, size, type, last_modified FROM files"+cond+" ORDER BY bucket, name, url LIMIT ? OFFSET ?", append(args, limit, start)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f ghw.File
+		var id, bucketID, modified string
+		if err := rows.Scan(&f.URL, &id, &f.Bucket, &bucketID, &f.Name, &f.Size, &f.Type, &modified); err != nil {
+			return nil, err
+		}
+		f.ID, f.BucketID = cachedID(id), cachedID(bucketID)
+		if ts, err := time.Parse(time.RFC3339, modified); err == nil {
+			f.LastModified = ts.Unix()
+		}
+		resp.Files = append(resp.Files, f)
+	}
+	return resp, rows.Err()
+}
+
+func (t offlineTransport) buckets(keywords, cloudType string, start, limit int) (*ghw.BucketsResponse, error) {
+	where, args := likeTerms("bucket", keywords)
+	if cloudType != "" {
+		where = append(where, "type = ?")
+		args = append(args, cloudType)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	resp := &ghw.BucketsResponse{Buckets: []ghw.Bucket{}}
+	if err := t.db.QueryRow("SELECT count(*) FROM buckets"+cond, args...).Scan(&resp.Meta.Results); err != nil {
+		return nil, err
+	}
+	rows, err := t.db.Query("SELECT bucket, id, file_count, type FROM buckets"+cond+" ORDER BY file_count DESC, bucket LIMIT ? OFFSET ?", append(args, limit, start)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b ghw.Bucket
+		var id string
+		if err := rows.Scan(&b.Name, &id, &b.FileCount, &b.Type); err != nil {
+			return nil, err
+		}
+		b.ID = cachedID(id)
+		resp.Buckets = append(resp.Buckets, b)
+	}
+	return resp, rows.Err()
+}
+
+// cachedID gives back the number the api sent for an id the cache keeps
+// as text.
+func cachedID(id string) any {
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return n
+	}
+	return id
+}
+
+// cachedPlan estimates the requests of the -dry-run searches urls from
+// the results the cache holds for them; results is 0 without a cache or
+// when it has none.
+func cachedPlan(urls []string, start, pageSize int) (requests, results int) {
+	path, err := cachePath()
+	if err != nil {
+		return 0, 0
+	}
+	if _, err := os.Stat(path); err != nil {
+		return 0, 0
+	}
+	db, err := openCacheDB(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer db.Close()
+	t := offlineTransport{db: db}
+	for _, u := range urls {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return 0, 0
+		}
+		resp, err := t.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return 0, 0
+		}
+		var page struct {
+			Meta ghw.Meta `json:"meta"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return 0, 0
+		}
+		results += page.Meta.Results
+		// the first page always goes out, then one per further page
+		requests++
+		if left := page.Meta.Results - start - pageSize; left > 0 {
+			requests += (left + pageSize - 1) / pageSize
+		}
+	}
+	return requests, results
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+	"github.com/dogadmin/bucketsearch/ghw/ghwtest"
+)
+
+// newOfflineClient returns a client served from a cache holding the
+// fixtures of ghwtest.
+func newOfflineClient(t *testing.T) *ghw.Client {
+	t.Helper()
+	db, err := openCacheDB(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var files []File
+	for _, f := range ghwtest.Files() {
+		files = append(files, File{File: f})
+	}
+	var buckets []Bucket
+	for _, b := range ghwtest.Buckets() {
+		buckets = append(buckets, Bucket{Bucket: b})
+	}
+	if err := insertFiles(db, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := insertBuckets(db, buckets); err != nil {
+		t.Fatal(err)
+	}
+	c := ghw.NewClient("")
+	c.HTTPClient = &http.Client{Transport: offlineTransport{db: db}}
+	return c
+}
+
+func TestOfflineFilesLikeTheAPI(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	online, offline := s.Client(), newOfflineClient(t)
+	for _, q := range []ghw.FilesQuery{
+		{},
+		{Keywords: "report"},
+		{Keywords: "backup", Extensions: "sql,zip"},
+		{StopExtensions: "pdf,txt"},
+		{Bucket: "acme-dev.s3.amazonaws.com", Limit: 3},
+	} {
+		want, got := allFileURLs(t, online, q), allFileURLs(t, offline, q)
+		if len(want) == 0 || !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: offline found %q, the api %q", q, got, want)
+		}
+	}
+
+	resp, err := offline.SearchFiles(context.Background(), ghw.FilesQuery{Start: 20, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Meta.Results != 25 || len(resp.Files) != 5 {
+		t.Errorf("page from 20 has %d of %d files, want 5 of 25", len(resp.Files), resp.Meta.Results)
+	}
+	f := resp.Files[0]
+	if f.ID == nil || f.LastModified == 0 || f.Size == 0 || f.Bucket == "" {
+		t.Errorf("file came back as %+v", f)
+	}
+}
+
+func TestOfflineBuckets(t *testing.T) {
+	offline := newOfflineClient(t)
+	resp, err := offline.SearchBuckets(context.Background(), ghw.BucketsQuery{Keywords: "backup", Type: "aws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// acme-backup-3, -7 and -11 are aws, azure and gcp in turn
+	if resp.Meta.Results != 1 || resp.Buckets[0].Name != "acme-backup-3" || resp.Buckets[0].FileCount != 400 {
+		t.Errorf("got %+v", resp)
+	}
+	resp, err = offline.SearchBuckets(context.Background(), ghw.BucketsQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the biggest first, like the api
+	if resp.Meta.Results != 12 || len(resp.Buckets) != 2 || resp.Buckets[0].FileCount != 1200 {
+		t.Errorf("got %+v", resp)
+	}
+}
+
+func TestOfflineOtherEndpoints(t *testing.T) {
+	_, err := newOfflineClient(t).Stats(context.Background())
+	if !errors.Is(err, ghw.ErrNotFound) {
+		t.Errorf("stats gave %v, want not found", err)
+	}
+}
+
+func allFileURLs(t *testing.T, c *ghw.Client, q ghw.FilesQuery) []string {
+	t.Helper()
+	var urls []string
+	it := c.Files(context.Background(), q).Iter()
+	for it.Next() {
+		urls = append(urls, it.Value().URL)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(urls)
+	return urls
+}
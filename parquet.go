@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// A minimal parquet writer for -format parquet: flat schemas, plain
+// encoding, one gzip data page per column chunk and a row group every
+// parquetRowGroupRows rows. Only what the exports need is implemented.
+
+const parquetRowGroupRows = 100000
+
+// physical types and converted types from parquet.thrift
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+	parquetNone            = -1
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	optional  bool
+}
+
+type parquetChunk struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+type parquetWriter struct {
+	w       io.Writer
+	file    *os.File
+	offset  int64
+	columns []parquetColumn
+
+	// per column: plain encoded values and, for optional columns, one
+	// definition level per row
+	values [][]byte
+	levels [][]bool
+	rows   int64
+
+	groups []parquetRowGroup
+	total  int64
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, columns: columns, values: make([][]byte, len(columns)), levels: make([][]bool, len(columns))}
+	return p, p.put([]byte("PAR1"))
+}
+
+// createParquet starts a parquet file at path; close finishes it.
+func createParquet(path string, columns []parquetColumn) *parquetWriter {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("create file: %v", err)
+	}
+	p, err := newParquetWriter(bufio.NewWriter(f), columns)
+	if err != nil {
+		log.Fatalf("write parquet: %v", err)
+	}
+	p.file = f
+	return p
+}
+
+func (p *parquetWriter) put(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// write adds one row; values are string, int64, float64 or nil for a null
+// in an optional column.
+func (p *parquetWriter) write(row []any) error {
+	for i, c := range p.columns {
+		v := row[i]
+		if c.optional {
+			p.levels[i] = append(p.levels[i], v != nil)
+		}
+		if v == nil {
+			if !c.optional {
+				return fmt.Errorf("parquet: null in required column %s", c.name)
+			}
+			continue
+		}
+		switch c.typ {
+		case parquetByteArray:
+			s := v.(string)
+			p.values[i] = binary.LittleEndian.AppendUint32(p.values[i], uint32(len(s)))
+			p.values[i] = append(p.values[i], s...)
+		case parquetInt64:
+			p.values[i] = binary.LittleEndian.AppendUint64(p.values[i], uint64(v.(int64)))
+		case parquetDouble:
+			p.values[i] = binary.LittleEndian.AppendUint64(p.values[i], math.Float64bits(v.(float64)))
+		}
+	}
+	p.rows++
+	if p.rows >= parquetRowGroupRows {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	g := parquetRowGroup{rows: p.rows}
+	for i, c := range p.columns {
+		var page []byte
+		if c.optional {
+			page = rleLevels(p.levels[i])
+		}
+		page = append(page, p.values[i]...)
+
+		var zipped bytes.Buffer
+		zw := gzip.NewWriter(&zipped)
+		zw.Write(page)
+		zw.Close()
+
+		var t thriftWriter
+		t.i32(1, 0) // DATA_PAGE
+		t.i32(2, int32(len(page)))
+		t.i32(3, int32(zipped.Len()))
+		t.beginStruct(5)
+		t.i32(1, int32(p.rows))
+		t.i32(2, 0) // PLAIN
+		t.i32(3, 3) // RLE
+		t.i32(4, 3)
+		t.endStruct()
+		t.stop()
+
+		chunk := parquetChunk{
+			offset:       p.offset,
+			uncompressed: int64(t.buf.Len() + len(page)),
+			compressed:   int64(t.buf.Len() + zipped.Len()),
+		}
+		if err := p.put(t.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.put(zipped.Bytes()); err != nil {
+			return err
+		}
+		g.chunks = append(g.chunks, chunk)
+		g.size += chunk.uncompressed
+		p.values[i], p.levels[i] = p.values[i][:0], p.levels[i][:0]
+	}
+	p.groups = append(p.groups, g)
+	p.total += p.rows
+	p.rows = 0
+	return nil
+}
+
+// rleLevels encodes definition levels of bit width 1 as RLE runs, prefixed
+// by their length as data page v1 expects.
+func rleLevels(levels []bool) []byte {
+	var runs []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		runs = binary.AppendUvarint(runs, uint64(j-i)<<1)
+		if levels[i] {
+			runs = append(runs, 1)
+		} else {
+			runs = append(runs, 0)
+		}
+		i = j
+	}
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(runs))), runs...)
+}
+
+// close writes the last row group and the footer.
+func (p *parquetWriter) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	var t thriftWriter
+	t.i32(1, 1)
+	t.listBegin(2, thriftStruct, len(p.columns)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.endStruct()
+	for _, c := range p.columns {
+		t.elemBegin()
+		t.i32(1, c.typ)
+		repetition := int32(0) // REQUIRED
+		if c.optional {
+			repetition = 1
+		}
+		t.i32(3, repetition)
+		t.binary(4, c.name)
+		if c.converted != parquetNone {
+			t.i32(6, c.converted)
+		}
+		t.endStruct()
+	}
+	t.i64(3, p.total)
+	t.listBegin(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(g.chunks))
+		for i, ch := range g.chunks {
+			c := p.columns[i]
+			t.elemBegin()
+			t.i64(2, ch.offset)
+			t.beginStruct(3)
+			t.i32(1, c.typ)
+			t.listBegin(2, thriftI32, 2)
+			t.varint(zigzag(0)) // PLAIN
+			t.varint(zigzag(3)) // RLE
+			t.listBegin(3, thriftBinary, 1)
+			t.varint(uint64(len(c.name)))
+			t.buf.WriteString(c.name)
+			t.i32(4, 2) // GZIP
+			t.i64(5, g.rows)
+			t.i64(6, ch.uncompressed)
+			t.i64(7, ch.compressed)
+			t.i64(9, ch.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+	t.binary(6, "bucketsearch")
+	t.stop()
+
+	footer := t.buf.Bytes()
+	if err := p.put(footer); err != nil {
+		return err
+	}
+	if err := p.put(binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))); err != nil {
+		return err
+	}
+	if err := p.put([]byte("PAR1")); err != nil || p.file == nil {
+		return err
+	}
+	if err := p.w.(*bufio.Writer).Flush(); err != nil {
+		return err
+	}
+	return p.file.Close()
+}
+
+// The parquet exports use the same columns as the sqlite tables, with
+// last_modified as a timestamp.
+var (
+	parquetFileColumns = []parquetColumn{
+		{"url", parquetByteArray, parquetUTF8, false},
+		{"id", parquetByteArray, parquetUTF8, false},
+		{"bucket", parquetByteArray, parquetUTF8, false},
+		{"bucket_id", parquetByteArray, parquetUTF8, false},
+		{"name", parquetByteArray, parquetUTF8, false},
+		{"ext", parquetByteArray, parquetUTF8, false},
+		{"size", parquetInt64, parquetNone, false},
+		{"type", parquetByteArray, parquetUTF8, false},
+		{"last_modified", parquetInt64, parquetTimestampMillis, false},
+		{"live_size", parquetInt64, parquetNone, true},
+		{"size_delta", parquetInt64, parquetNone, true},
+		{"keyword", parquetByteArray, parquetUTF8, false},
+		{"matched_on", parquetByteArray, parquetUTF8, false},
+	}
+	parquetBucketColumns = []parquetColumn{
+		{"bucket", parquetByteArray, parquetUTF8, false},
+		{"id", parquetByteArray, parquetUTF8, false},
+		{"file_count", parquetInt64, parquetNone, false},
+		{"type", parquetByteArray, parquetUTF8, false},
+		{"region", parquetByteArray, parquetUTF8, false},
+		{"first_seen", parquetByteArray, parquetUTF8, false},
+		{"attribution", parquetByteArray, parquetUTF8, false},
+		{"attribution_score", parquetDouble, parquetNone, false},
+		{"keyword", parquetByteArray, parquetUTF8, false},
+	}
+)
+
+func writeParquetFiles(p *parquetWriter, files []File) {
+	for _, f := range files {
+		var liveSize, sizeDelta any
+		if f.LiveSize != nil {
+			liveSize = *f.LiveSize
+		}
+		if f.SizeDelta != nil {
+			sizeDelta = *f.SizeDelta
+		}
+		err := p.write([]any{f.URL, fmt.Sprint(f.ID), f.Bucket, fmt.Sprint(f.BucketID), f.Name, fileExt(f.Name),
+			f.Size, f.Type, f.LastModified * 1000, liveSize, sizeDelta, f.Keyword, strings.Join(f.MatchedOn, ";")})
+		if err != nil {
+			log.Fatalf("write parquet: %v", err)
+		}
+	}
+}
+
+func writeParquetBuckets(p *parquetWriter, buckets []Bucket) {
+	for _, b := range buckets {
+		err := p.write([]any{b.Name, fmt.Sprint(b.ID), int64(b.FileCount), b.Type, b.Region, b.FirstSeen,
+			b.Attribution, b.AttributionScore, b.Keyword})
+		if err != nil {
+			log.Fatalf("write parquet: %v", err)
+		}
+	}
+}
+
+// thriftWriter writes the thrift compact protocol, enough of it for the
+// parquet page headers and footer.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+	id   int16
+}
+
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.id = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.varint(uint64(n))
+}
+
+// beginStruct starts a struct valued field, elemBegin a struct inside a
+// list; both are closed by endStruct.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) elemBegin() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.id = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func TestParquetFilesRoundTrip(t *testing.T) {
+	liveSize, sizeDelta := int64(2048), int64(-10)
+	want := []File{
+		{File: ghw.File{ID: "1", Bucket: "a", BucketID: "10", Name: "dump/db.sql", URL: "https://a.s3.amazonaws.com/dump/db.sql",
+			Size: 1 << 40, Type: "aws", LastModified: 1700000000}, Keyword: "db dump", MatchedOn: []string{"db", "dump"}},
+		{File: ghw.File{ID: "2", Bucket: "b", BucketID: "20", Name: "备份.zip", URL: "https://b.s3.amazonaws.com/备份.zip",
+			Size: 0, Type: "gcp", LastModified: 0}, LiveSize: &liveSize, SizeDelta: &sizeDelta},
+	}
+	path := filepath.Join(t.TempDir(), "files.parquet")
+	p := createParquet(path, parquetFileColumns)
+	writeParquetFiles(p, want)
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tab, err := loadTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tab.kind != "files" {
+		t.Fatalf("got a %s table", tab.kind)
+	}
+	if tab.rows[0]["ext"] != "sql" || tab.rows[0]["lastModified"] != "2023-11-14T22:13:20Z" {
+		t.Errorf("got row %q", tab.rows[0])
+	}
+	got, err := tab.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %+v, want %+v", got, want)
+	}
+}
+
+func TestParquetBucketsRoundTrip(t *testing.T) {
+	buckets := []Bucket{
+		{Bucket: ghw.Bucket{Name: "a", ID: "1", FileCount: 12, Type: "aws"}, Region: "us-east-1", Attribution: "acme", AttributionScore: 0.75, Keyword: "acme"},
+		{Bucket: ghw.Bucket{Name: "b", ID: "2", FileCount: 0, Type: "azure"}, FirstSeen: "2024-01-02T03:04:05Z"},
+	}
+	path := filepath.Join(t.TempDir(), "buckets.parquet")
+	p := createParquet(path, parquetBucketColumns)
+	writeParquetBuckets(p, buckets)
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tab, err := loadTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"bucket": "a", "id": "1", "fileCount": "12", "type": "aws", "region": "us-east-1", "firstSeen": "",
+			"attribution": "acme", "attributionScore": "0.75", "keyword": "acme"},
+		{"bucket": "b", "id": "2", "fileCount": "0", "type": "azure", "region": "", "firstSeen": "2024-01-02T03:04:05Z",
+			"attribution": "", "attributionScore": "0", "keyword": ""},
+	}
+	if tab.kind != "buckets" || !reflect.DeepEqual(tab.rows, want) {
+		t.Errorf("got a %s table with rows %q, want %q", tab.kind, tab.rows, want)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	columns := []parquetColumn{
+		{"n", parquetInt64, parquetNone, false},
+		{"s", parquetByteArray, parquetUTF8, true},
+	}
+	path := filepath.Join(t.TempDir(), "rows.parquet")
+	p := createParquet(path, columns)
+	rows := parquetRowGroupRows + 3
+	for i := 0; i < rows; i++ {
+		var s any
+		if i%3 == 0 {
+			s = strconv.Itoa(i)
+		}
+		if err := p.write([]any{int64(i), s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, got, err := readParquet(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"n", "s"}) || len(got) != rows {
+		t.Fatalf("got columns %q and %d rows, want n, s and %d", names, len(got), rows)
+	}
+	for i, row := range got {
+		s := ""
+		if i%3 == 0 {
+			s = strconv.Itoa(i)
+		}
+		if row[0] != strconv.Itoa(i) || row[1] != s {
+			t.Fatalf("row %d is %q", i, row)
+		}
+	}
+}
+
+func TestParquetNullInRequiredColumn(t *testing.T) {
+	p := createParquet(filepath.Join(t.TempDir(), "bad.parquet"), []parquetColumn{{"n", parquetInt64, parquetNone, false}})
+	defer p.close()
+	if err := p.write([]any{nil}); err == nil {
+		t.Error("wrote a null into a required column")
+	}
+}
+
+func TestReadParquetRejectsOtherFiles(t *testing.T) {
+	for _, data := range []string{"", "PAR1", "PAR1\x00\x00\x00\x00PAR1", "PAR1\xff\xff\xff\x7fPAR1"} {
+		if _, _, err := readParquet([]byte(data)); err == nil {
+			t.Errorf("read %q as parquet", data)
+		}
+	}
+}
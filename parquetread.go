@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// readParquet reads a flat parquet file into string cells, enough for the
+// files written by -format parquet and for most flat tables written by
+// other tools: data page v1, plain and dictionary encodings, uncompressed
+// or gzip. Anything else is reported as unsupported.
+func readParquet(data []byte) (columns []string, rows [][]string, err error) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, nil, errors.New("not a parquet file")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if size > len(data)-12 {
+		return nil, nil, errors.New("parquet: bad footer length")
+	}
+	footer, err := (&thriftReader{b: data[len(data)-8-size : len(data)-8]}).readStruct()
+	if err != nil {
+		return nil, nil, fmt.Errorf("parquet footer: %v", err)
+	}
+
+	schema := footer.list(2)
+	if len(schema) == 0 {
+		return nil, nil, errors.New("parquet: no schema")
+	}
+	var leaves []thriftFields
+	for _, el := range schema[1:] {
+		s := el.(thriftFields)
+		if s.int(5) > 0 {
+			return nil, nil, errors.New("parquet: nested columns are not supported")
+		}
+		leaves = append(leaves, s)
+		columns = append(columns, string(s.bytes(4)))
+	}
+
+	for _, g := range footer.list(4) {
+		group := g.(thriftFields)
+		n := int(group.int(3))
+		cells := make([][]string, len(leaves))
+		for i, c := range group.list(1) {
+			if i >= len(leaves) {
+				break
+			}
+			meta := c.(thriftFields).strct(3)
+			if cells[i], err = readParquetChunk(data, meta, leaves[i], n); err != nil {
+				return nil, nil, fmt.Errorf("parquet column %s: %v", columns[i], err)
+			}
+		}
+		for r := 0; r < n; r++ {
+			row := make([]string, len(leaves))
+			for i := range leaves {
+				if r < len(cells[i]) {
+					row[i] = cells[i][r]
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return columns, rows, nil
+}
+
+// readParquetChunk decodes the pages of one column chunk into n cells.
+func readParquetChunk(data []byte, meta, leaf thriftFields, n int) ([]string, error) {
+	typ := meta.int(1)
+	codec := meta.int(4)
+	optional := leaf.int(3) == 1
+	pos := meta.int(9)
+	if dict, ok := meta[11]; ok && dict.(int64) > 0 && dict.(int64) < pos {
+		pos = dict.(int64)
+	}
+	var dict []string
+	var cells []string
+	for len(cells) < n {
+		if pos < 0 || pos >= int64(len(data)) {
+			return nil, errors.New("page offset out of range")
+		}
+		r := &thriftReader{b: data[pos:]}
+		header, err := r.readStruct()
+		if err != nil {
+			return nil, err
+		}
+		compressed := int(header.int(3))
+		if r.pos+compressed > len(r.b) {
+			return nil, errors.New("truncated page")
+		}
+		page, err := parquetDecompress(codec, r.b[r.pos:r.pos+compressed])
+		if err != nil {
+			return nil, err
+		}
+		pos += int64(r.pos + compressed)
+
+		switch header.int(1) {
+		case 2: // DICTIONARY_PAGE
+			d := header.strct(7)
+			if dict, err = parquetPlain(page, typ, int(d.int(1))); err != nil {
+				return nil, err
+			}
+		case 0: // DATA_PAGE
+			h := header.strct(5)
+			count := int(h.int(1))
+			defined := make([]bool, count)
+			present := count
+			if optional {
+				if len(page) < 4 {
+					return nil, errors.New("truncated levels")
+				}
+				l := int(binary.LittleEndian.Uint32(page))
+				if 4+l > len(page) {
+					return nil, errors.New("truncated levels")
+				}
+				levels, err := rleHybrid(page[4:4+l], 1, count)
+				if err != nil {
+					return nil, err
+				}
+				present = 0
+				for i, v := range levels {
+					defined[i] = v == 1
+					if defined[i] {
+						present++
+					}
+				}
+				page = page[4+l:]
+			} else {
+				for i := range defined {
+					defined[i] = true
+				}
+			}
+			var values []string
+			switch h.int(2) {
+			case 0: // PLAIN
+				if values, err = parquetPlain(page, typ, present); err != nil {
+					return nil, err
+				}
+			case 2, 8: // PLAIN_DICTIONARY, RLE_DICTIONARY
+				if len(page) < 1 {
+					return nil, errors.New("truncated dictionary indices")
+				}
+				idx, err := rleHybrid(page[1:], int(page[0]), present)
+				if err != nil {
+					return nil, err
+				}
+				for _, i := range idx {
+					if int(i) >= len(dict) {
+						return nil, errors.New("dictionary index out of range")
+					}
+					values = append(values, dict[i])
+				}
+			default:
+				return nil, fmt.Errorf("encoding %d is not supported", h.int(2))
+			}
+			for _, d := range defined {
+				if d && len(values) > 0 {
+					cells = append(cells, values[0])
+					values = values[1:]
+				} else {
+					cells = append(cells, "")
+				}
+			}
+		default:
+			return nil, fmt.Errorf("page type %d is not supported", header.int(1))
+		}
+	}
+	if leaf.int(6) == parquetTimestampMillis {
+		for i, c := range cells {
+			if ms, err := strconv.ParseInt(c, 10, 64); err == nil {
+				cells[i] = time.UnixMilli(ms).UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	return cells, nil
+}
+
+func parquetDecompress(codec int64, b []byte) ([]byte, error) {
+	switch codec {
+	case 0:
+		return b, nil
+	case 2:
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("compression codec %d is not supported", codec)
+}
+
+// parquetPlain decodes n plain encoded values of a physical type.
+func parquetPlain(b []byte, typ int64, n int) ([]string, error) {
+	values := make([]string, 0, n)
+	pos := 0
+	need := func(k int) error {
+		if pos+k > len(b) {
+			return errors.New("truncated values")
+		}
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		switch typ {
+		case 0: // BOOLEAN, bit packed
+			if i/8 >= len(b) {
+				return nil, errors.New("truncated values")
+			}
+			values = append(values, strconv.FormatBool(b[i/8]>>(i%8)&1 == 1))
+		case 1: // INT32
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b[pos:]))), 10))
+			pos += 4
+		case parquetInt64:
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatInt(int64(binary.LittleEndian.Uint64(b[pos:])), 10))
+			pos += 8
+		case 4: // FLOAT
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b[pos:]))), 'g', -1, 32))
+			pos += 4
+		case parquetDouble:
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			values = append(values, strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b[pos:])), 'g', -1, 64))
+			pos += 8
+		case parquetByteArray:
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			l := int(binary.LittleEndian.Uint32(b[pos:]))
+			pos += 4
+			if err := need(l); err != nil {
+				return nil, err
+			}
+			values = append(values, string(b[pos:pos+l]))
+			pos += l
+		default:
+			return nil, fmt.Errorf("physical type %d is not supported", typ)
+		}
+	}
+	return values, nil
+}
+
+// rleHybrid decodes n values of the RLE / bit packed hybrid encoding used
+// for levels and dictionary indices.
+func rleHybrid(b []byte, width, n int) ([]uint32, error) {
+	var out []uint32
+	bytesPer := (width + 7) / 8
+	for len(out) < n {
+		header, k := binary.Uvarint(b)
+		if k <= 0 {
+			return nil, errors.New("truncated rle run")
+		}
+		b = b[k:]
+		if header&1 == 0 {
+			if len(b) < bytesPer {
+				return nil, errors.New("truncated rle run")
+			}
+			var v uint32
+			for i := 0; i < bytesPer; i++ {
+				v |= uint32(b[i]) << (8 * i)
+			}
+			b = b[bytesPer:]
+			for i := uint64(0); i < header>>1; i++ {
+				out = append(out, v)
+			}
+			continue
+		}
+		count := int(header>>1) * 8
+		if len(b) < count*width/8 {
+			return nil, errors.New("truncated bit packed run")
+		}
+		for i := 0; i < count; i++ {
+			var v uint32
+			for j := 0; j < width; j++ {
+				bit := i*width + j
+				v |= uint32(b[bit/8]>>(bit%8)&1) << j
+			}
+			out = append(out, v)
+		}
+		b = b[count*width/8:]
+	}
+	return out[:n], nil
+}
+
+// thriftFields holds the fields of a decoded thrift struct by id: int64,
+// bool, float64, []byte, []any or thriftFields.
+type thriftFields map[int16]any
+
+func (s thriftFields) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftFields) bytes(id int16) []byte {
+	v, _ := s[id].([]byte)
+	return v
+}
+
+func (s thriftFields) list(id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+func (s thriftFields) strct(id int16) thriftFields {
+	v, _ := s[id].(thriftFields)
+	return v
+}
+
+// thriftReader reads the thrift compact protocol.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+var errThriftShort = errors.New("truncated thrift data")
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, errThriftShort
+	}
+	r.pos++
+	return r.b[r.pos-1], nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, k := binary.Uvarint(r.b[r.pos:])
+	if k <= 0 {
+		return 0, errThriftShort
+	}
+	r.pos += k
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	u, err := r.uvarint()
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	s := thriftFields{}
+	var id int16
+	for {
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if h == 0 {
+			return s, nil
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		switch h & 0x0f {
+		case thriftTrue:
+			s[id] = true
+		case thriftFalse:
+			s[id] = false
+		default:
+			if s[id], err = r.value(h & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) value(typ byte) (any, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// booleans inside lists take a byte each
+		b, err := r.byte()
+		return b == 1, err
+	case 3: // byte
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case 4, thriftI32, thriftI64:
+		return r.varint()
+	case 7: // double
+		if r.pos+8 > len(r.b) {
+			return nil, errThriftShort
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos-8:])), nil
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil || r.pos+int(n) > len(r.b) {
+			return nil, errThriftShort
+		}
+		r.pos += int(n)
+		return r.b[r.pos-int(n) : r.pos], nil
+	case thriftList, 10: // list, set
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := int(h >> 4)
+		if n == 15 {
+			u, err := r.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			n = int(u)
+		}
+		list := make([]any, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := r.value(h & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case 11: // map, skipped
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		kv, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.value(kv >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := r.value(kv & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
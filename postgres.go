@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// The postgres tables mirror the sqlite ones with native types. Rows are
+// upserted on url and bucket name, so analysts can share one database and
+// repeated runs update rows instead of adding them.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	url           TEXT PRIMARY KEY,
+	id            TEXT,
+	bucket        TEXT,
+	bucket_id     TEXT,
+	name          TEXT,
+	ext           TEXT,
+	size          BIGINT,
+	type          TEXT,
+	last_modified TIMESTAMPTZ,
+	live_size     BIGINT,
+	size_delta    BIGINT,
+	keyword       TEXT,
+	matched_on    TEXT,
+	updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS files_bucket ON files (bucket);
+CREATE INDEX IF NOT EXISTS files_ext ON files (ext);
+CREATE INDEX IF NOT EXISTS files_last_modified ON files (last_modified);
+CREATE TABLE IF NOT EXISTS buckets (
+	bucket            TEXT PRIMARY KEY,
+	id                TEXT,
+	file_count        BIGINT,
+	type              TEXT,
+	region            TEXT,
+	first_seen        TEXT,
+	attribution       TEXT,
+	attribution_score DOUBLE PRECISION,
+	keyword           TEXT,
+	updated_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS buckets_type ON buckets (type);
+`
+
+// isPostgresURL tells an -o postgres connection url from a file path.
+func isPostgresURL(output string) bool {
+	return strings.HasPrefix(output, "postgres://") || strings.HasPrefix(output, "postgresql://")
+}
+
+func openPostgres(dsn string) *sql.DB {
+	db, err := sql.Open("postgres", dsn)
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		log.Fatalf("open database %s: %v", outputName(dsn), err)
+	}
+	if _, err := db.Exec(postgresSchema); err != nil {
+		log.Fatalf("create schema: %v", err)
+	}
+	if err := addLaterColumns(db); err != nil {
+		log.Fatalf("update schema: %v", err)
+	}
+	return db
+}
+
+func isPostgres(db *sql.DB) bool {
+	_, ok := db.Driver().(*pq.Driver)
+	return ok
+}
+
+// upsertQuery builds a postgres insert of cols that updates the row when
+// key exists already.
+func upsertQuery(table, key string, cols []string) string {
+	params := make([]string, len(cols))
+	var set []string
+	for i, c := range cols {
+		params[i] = fmt.Sprintf("$%d", i+1)
+		if c != key {
+			set = append(set, c+" = EXCLUDED."+c)
+		}
+	}
+	set = append(set, "updated_at = now()")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(cols, ", "), strings.Join(params, ", "), key, strings.Join(set, ", "))
+}
+
+// outputName is -o as shown in messages, without the password of a
+// connection url.
+func outputName(output string) string {
+	if !isPostgresURL(output) {
+		return output
+	}
+	u, err := url.Parse(output)
+	if err != nil {
+		return "postgres database"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func TestUpsertQuery(t *testing.T) {
+	got := upsertQuery("buckets", "bucket", []string{"bucket", "id", "file_count"})
+	want := "INSERT INTO buckets (bucket, id, file_count) VALUES ($1, $2, $3) ON CONFLICT (bucket) DO UPDATE SET " +
+		"id = EXCLUDED.id, file_count = EXCLUDED.file_count, updated_at = now()"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"out.csv", "out.csv"},
+		{"postgres://me:secret@db/results", "postgres://me:xxxxx@db/results"},
+		{"postgresql://db/results", "postgresql://db/results"},
+	}
+	for _, tt := range tests {
+		if got := outputName(tt.in); got != tt.want {
+			t.Errorf("outputName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestPostgresUpsert needs a scratch database in BUCKETSEARCH_TEST_POSTGRES,
+// e.g. postgres://postgres@localhost/test?sslmode=disable.
+func TestPostgresUpsert(t *testing.T) {
+	dsn := os.Getenv("BUCKETSEARCH_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("BUCKETSEARCH_TEST_POSTGRES not set")
+	}
+	db := openPostgres(dsn)
+	defer db.Close()
+	const u = "https://upsert-test.s3.amazonaws.com/a.sql"
+	defer db.Exec("DELETE FROM files WHERE url = $1", u)
+
+	f := File{File: ghw.File{ID: 1, Bucket: "upsert-test", Name: "a.sql", URL: u, Size: 10, LastModified: 1700000000}}
+	if err := insertFiles(db, []File{f}); err != nil {
+		t.Fatal(err)
+	}
+	f.Size = 20
+	if err := insertFiles(db, []File{f}); err != nil {
+		t.Fatal(err)
+	}
+	var n, size int64
+	if err := db.QueryRow("SELECT count(*), max(size) FROM files WHERE url = $1", u).Scan(&n, &size); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || size != 20 {
+		t.Errorf("got %d rows of size %d, want one updated to 20", n, size)
+	}
+}
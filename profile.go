@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func runProfile(args []string) {
+	fs := newFlagSet("profile", "Sample files of one bucket and print a quick risk profile: content mix, recency, sensitivity and how much is still reachable.")
+	common := addAPIFlags(fs)
+	bucket := fs.String("bucket", "", "Bucket id or url")
+	sample := fs.Int("sample", 200, "Number of files to sample")
+	pages := fs.Int("pages", 4, "Spread the sample over this many requests at random offsets")
+	noHead := fs.Bool("no-head", false, "Skip the HEAD checks of the sampled file urls")
+	format := fs.String("format", "table", "Output format: table|json")
+	parseFlags(fs, args)
+	if *bucket == "" {
+		log.Fatalln("profile needs -bucket")
+	}
+
+	api := common.client("profile")
+	defer telemetry.flush()
+	handleProfile(api, newWebClient(), profileOptions{
+		bucket: bucketASCII(*bucket),
+		sample: *sample,
+		pages:  *pages,
+		head:   !*noHead,
+		format: *format,
+	})
+}
+
+type profileOptions struct {
+	bucket string
+	sample int
+	pages  int
+	head   bool
+	format string
+}
+
+type profileCount struct {
+	Name    string  `json:"name"`
+	Files   int     `json:"files"`
+	Percent float64 `json:"percent"`
+}
+
+type bucketProfile struct {
+	Bucket     string         `json:"bucket"`
+	Indexed    int            `json:"indexed"`
+	Sampled    int            `json:"sampled"`
+	Severity   []profileCount `json:"severity"`
+	Extensions []profileCount `json:"extensions"`
+	Types      []profileCount `json:"types"`
+	Newest     string         `json:"newest,omitempty"`
+	Oldest     string         `json:"oldest,omitempty"`
+	// percent of the sample modified in the last 90 days and year
+	Recent90d  float64 `json:"recent90d"`
+	RecentYear float64 `json:"recentYear"`
+	Checked    int     `json:"checked"`
+	Reachable  int     `json:"reachable"`
+	Grown      int     `json:"grown"`
+	Score      int     `json:"score"`
+	Risk       string  `json:"risk"`
+}
+
+func handleProfile(api *ghw.Client, web *http.Client, opts profileOptions) {
+	if opts.sample < 1 {
+		opts.sample = 1
+	}
+	if opts.pages < 1 {
+		opts.pages = 1
+	}
+	per := (opts.sample + opts.pages - 1) / opts.pages
+	if per > 1000 {
+		per = 1000
+	}
+	get := func(start int) *ghw.FilesResponse {
+		resp, err := api.SearchFiles(runCtx, ghw.FilesQuery{Bucket: opts.bucket, Start: start, Limit: per})
+		if err != nil {
+			requestFailed(err)
+		}
+		return resp
+	}
+
+	// the first page tells how many files there are; when they don't fit
+	// in the sample the remaining pages come from random offsets
+	first := get(0)
+	total := first.Meta.Results
+	files := first.Files
+	var offsets []int
+	if total <= opts.sample {
+		for start := per; start < total; start += per {
+			offsets = append(offsets, start)
+		}
+	} else {
+		taken := map[int]bool{0: true}
+		slots := (total - 1) / per
+		for len(offsets) < opts.pages-1 && len(offsets) < slots {
+			if start := (1 + rand.Intn(slots)) * per; !taken[start] {
+				taken[start] = true
+				offsets = append(offsets, start)
+			}
+		}
+	}
+	for _, start := range offsets {
+		files = append(files, get(start).Files...)
+	}
+
+	seen := map[string]bool{}
+	var sample []File
+	for _, f := range files {
+		if len(sample) < opts.sample && !seen[f.URL] {
+			seen[f.URL] = true
+			file := File{File: f}
+			file.Bucket = normalizeBucket(file.Bucket)
+			sample = append(sample, file)
+		}
+	}
+	p := profileBucket(opts.bucket, total, sample)
+	if opts.head && len(sample) > 0 {
+		p.Grown = verifySizes(web, sample)
+		p.Checked = len(sample)
+		for _, f := range sample {
+			if f.LiveSize != nil {
+				p.Reachable++
+			}
+		}
+	}
+	p.Score, p.Risk = profileRisk(p)
+	writeProfile(os.Stdout, opts.format, p)
+}
+
+// profileBucket tallies the content mix, recency and severity of sample.
+func profileBucket(bucket string, indexed int, sample []File) bucketProfile {
+	p := bucketProfile{Bucket: bucket, Indexed: indexed, Sampled: len(sample)}
+	severity := map[string]int{}
+	exts := map[string]int{}
+	types := map[string]int{}
+	var newest, oldest int64
+	var recent90, recentYear int
+	now := time.Now()
+	for _, f := range sample {
+		severity[fileSeverity(f)]++
+		ext := fileExt(f.Name)
+		if ext == "" {
+			ext = "(none)"
+		}
+		exts[ext]++
+		types[f.Type]++
+		if f.LastModified <= 0 {
+			continue
+		}
+		if f.LastModified > newest {
+			newest = f.LastModified
+		}
+		if oldest == 0 || f.LastModified < oldest {
+			oldest = f.LastModified
+		}
+		modified := time.Unix(f.LastModified, 0)
+		if now.Sub(modified) <= 90*24*time.Hour {
+			recent90++
+		}
+		if now.Sub(modified) <= 365*24*time.Hour {
+			recentYear++
+		}
+	}
+	for _, level := range []string{"critical", "high", "medium", "low"} {
+		p.Severity = append(p.Severity, profileCount{level, severity[level], percentOf(severity[level], len(sample))})
+	}
+	p.Extensions = topCounts(exts, len(sample), 10)
+	p.Types = topCounts(types, len(sample), 5)
+	if newest > 0 {
+		p.Newest = time.Unix(newest, 0).UTC().Format("2006-01-02")
+		p.Oldest = time.Unix(oldest, 0).UTC().Format("2006-01-02")
+	}
+	p.Recent90d = percentOf(recent90, len(sample))
+	p.RecentYear = percentOf(recentYear, len(sample))
+	return p
+}
+
+// profileRisk scores a profile from 0 to 100: sensitive names weigh most,
+// recent activity and files still being served add to it.
+func profileRisk(p bucketProfile) (int, string) {
+	var critical, high float64
+	for _, s := range p.Severity {
+		switch s.Name {
+		case "critical":
+			critical = s.Percent
+		case "high":
+			high = s.Percent
+		}
+	}
+	score := 2*critical + high + 0.2*p.Recent90d
+	if p.Checked > 0 {
+		score += 0.2 * percentOf(p.Reachable, p.Checked)
+	}
+	if score > 100 {
+		score = 100
+	}
+	switch {
+	case score >= 60:
+		return int(score), "critical"
+	case score >= 35:
+		return int(score), "high"
+	case score >= 15:
+		return int(score), "medium"
+	}
+	return int(score), "low"
+}
+
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int(float64(n)*1000/float64(total)+0.5)) / 10
+}
+
+// topCounts returns the n most common names, the rest summed up as other.
+func topCounts(counts map[string]int, total, n int) []profileCount {
+	var list []profileCount
+	for name, c := range counts {
+		list = append(list, profileCount{Name: name, Files: c})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Files != list[j].Files {
+			return list[i].Files > list[j].Files
+		}
+		return list[i].Name < list[j].Name
+	})
+	if len(list) > n {
+		other := profileCount{Name: "other"}
+		for _, c := range list[n:] {
+			other.Files += c.Files
+		}
+		list = append(list[:n], other)
+	}
+	for i := range list {
+		list[i].Percent = percentOf(list[i].Files, total)
+	}
+	return list
+}
+
+func writeProfile(out io.Writer, format string, p bucketProfile) {
+	if format == "json" {
+		b, _ := json.MarshalIndent(p, "", "  ")
+		out.Write(append(b, '\n'))
+		return
+	}
+	fmt.Fprintf(out, "bucket:    %s\n", p.Bucket)
+	fmt.Fprintf(out, "files:     %s indexed, %d sampled\n", humanCount(int64(p.Indexed)), p.Sampled)
+	if p.Newest != "" {
+		fmt.Fprintf(out, "modified:  %s to %s, %.1f%% in the last 90 days, %.1f%% in the last year\n", p.Oldest, p.Newest, p.Recent90d, p.RecentYear)
+	}
+	if p.Checked > 0 {
+		fmt.Fprintf(out, "reachable: %d of %d sampled urls answer HEAD, %d grew since indexing\n", p.Reachable, p.Checked, p.Grown)
+	}
+	fmt.Fprintf(out, "risk:      %s (score %d)\n", p.Risk, p.Score)
+	for _, section := range []struct {
+		title  string
+		counts []profileCount
+	}{{"severity", p.Severity}, {"extension", p.Extensions}, {"type", p.Types}} {
+		fmt.Fprintln(out)
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "%s\tfiles\tpercent\t\n", section.title)
+		for _, c := range section.counts {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t\n", c.Name, c.Files, c.Percent)
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyURL is set by -proxy; without it the proxy environment variables
+// apply.
+var proxyURL *url.URL
+
+func addProxyFlag(fs *flag.FlagSet) {
+	fs.Func("proxy", "Send requests through this proxy: http://, https:// or socks5://[user:pass@]host:port (default from HTTPS_PROXY, HTTP_PROXY or ALL_PROXY)", func(v string) error {
+		u, err := parseProxy(v)
+		proxyURL = u
+		return err
+	})
+	fs.Func("proxy-list", "File of proxies, one per line, to spread requests over; unreachable ones are dropped and failing ones evicted", func(path string) error {
+		list, err := readList(path)
+		if err != nil {
+			return err
+		}
+		pool := &proxyPool{}
+		for _, line := range list {
+			u, err := parseProxy(line)
+			if err != nil {
+				return errors.New(line + ": " + err.Error())
+			}
+			pool.proxies = append(pool.proxies, &poolProxy{url: u})
+		}
+		if len(pool.proxies) == 0 {
+			return errors.New("no proxies in " + path)
+		}
+		proxies = pool
+		return nil
+	})
+	fs.Func("proxy-rotate", "When to move to the next -proxy-list proxy: request|failure (default request)", func(v string) error {
+		if v != "request" && v != "failure" {
+			return errors.New("want request or failure")
+		}
+		proxyRotate = v
+		return nil
+	})
+}
+
+func parseProxy(v string) (*url.URL, error) {
+	v = strings.TrimSpace(v)
+	if !strings.Contains(v, "://") {
+		v = "http://" + v
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		// the transport resolves names on the proxy for socks5 anyway
+		u.Scheme = "socks5"
+	default:
+		return nil, errors.New("want an http://, https:// or socks5:// url")
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// envProxy is the proxy of the environment: HTTPS_PROXY and HTTP_PROXY as
+// usual, with ALL_PROXY, as curl reads it, filling in for both. NO_PROXY
+// applies to all of them.
+var envProxy = func() func(*url.URL) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+	all := os.Getenv("ALL_PROXY")
+	if all == "" {
+		all = os.Getenv("all_proxy")
+	}
+	if cfg.HTTPSProxy == "" {
+		cfg.HTTPSProxy = all
+	}
+	if cfg.HTTPProxy == "" {
+		cfg.HTTPProxy = all
+	}
+	return cfg.ProxyFunc()
+}()
+
+func proxyFor(req *http.Request) (*url.URL, error) {
+	if p, ok := req.Context().Value(poolProxyKey{}).(*poolProxy); ok {
+		return p.url, nil
+	}
+	if proxyURL != nil {
+		return proxyURL, nil
+	}
+	return envProxy(req.URL)
+}
+
+// newTransport returns a transport that goes through the proxy, or the
+// -proxy-list proxies, and logs at -v. headerTimeout, when set, bounds the
+// wait for response headers.
+func newTransport(headerTimeout time.Duration) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxyFor
+	t.ResponseHeaderTimeout = headerTimeout
+	if proxies == nil {
+		return debugTransport{t}
+	}
+	if proxyURL != nil {
+		log.Fatalf("use either -proxy or -proxy-list\n")
+	}
+	proxies.once.Do(proxies.check)
+	return debugTransport{rotatingTransport{next: t, pool: proxies}}
+}
+
+// proxies is the pool of -proxy-list, shared by every client of the run.
+var proxies *proxyPool
+
+var proxyRotate = "request"
+
+// proxyMaxFails is how many failures in a row evict a proxy.
+const proxyMaxFails = 3
+
+type proxyPool struct {
+	once    sync.Once
+	mu      sync.Mutex
+	proxies []*poolProxy
+	next    int
+}
+
+type poolProxy struct {
+	url   *url.URL
+	fails int
+}
+
+type poolProxyKey struct{}
+
+// check drops the proxies that don't accept a connection.
+func (p *proxyPool) check() {
+	live := make([]bool, len(p.proxies))
+	var wg sync.WaitGroup
+	for i, px := range p.proxies {
+		wg.Add(1)
+		go func(i int, px *poolProxy) {
+			defer wg.Done()
+			port := px.url.Port()
+			if port == "" {
+				port = map[string]string{"http": "80", "https": "443", "socks5": "1080"}[px.url.Scheme]
+			}
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(px.url.Hostname(), port), 5*time.Second)
+			if err != nil {
+				log.Printf("proxy %s dropped: %v", px.url.Redacted(), err)
+				return
+			}
+			conn.Close()
+			live[i] = true
+		}(i, px)
+	}
+	wg.Wait()
+	var kept []*poolProxy
+	for i, px := range p.proxies {
+		if live[i] {
+			kept = append(kept, px)
+		}
+	}
+	if len(kept) == 0 {
+		log.Fatalf("none of the -proxy-list proxies is reachable\n")
+	}
+	p.proxies = kept
+}
+
+// pick returns the proxy for the next request: the next one in turn, or
+// with -proxy-rotate failure the current one until it fails.
+func (p *proxyPool) pick() *poolProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.proxies) == 0 {
+		return nil
+	}
+	p.next %= len(p.proxies)
+	px := p.proxies[p.next]
+	if proxyRotate == "request" {
+		p.next++
+	}
+	return px
+}
+
+func (p *proxyPool) ok(px *poolProxy) {
+	p.mu.Lock()
+	px.fails = 0
+	p.mu.Unlock()
+}
+
+// fail counts a failure of px, moving on from it and evicting it after
+// proxyMaxFails in a row.
+func (p *proxyPool) fail(px *poolProxy, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	px.fails++
+	for i, q := range p.proxies {
+		if q != px {
+			continue
+		}
+		if px.fails >= proxyMaxFails {
+			log.Printf("proxy %s evicted after %d failures: %v", px.url.Redacted(), px.fails, err)
+			p.proxies = append(p.proxies[:i], p.proxies[i+1:]...)
+			if i < p.next {
+				p.next--
+			}
+		} else if proxyRotate == "failure" && p.next == i {
+			p.next++
+		}
+		return
+	}
+}
+
+func (p *proxyPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.proxies)
+}
+
+// rotatingTransport sends each request through a proxy of the pool,
+// retrying a failed request without a body on the next one.
+type rotatingTransport struct {
+	next *http.Transport
+	pool *proxyPool
+}
+
+func (t rotatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tries := t.pool.size()
+	for {
+		px := t.pool.pick()
+		if px == nil {
+			return nil, errors.New("every -proxy-list proxy was evicted")
+		}
+		resp, err := t.next.RoundTrip(req.WithContext(context.WithValue(req.Context(), poolProxyKey{}, px)))
+		if err == nil && resp.StatusCode != http.StatusProxyAuthRequired {
+			t.pool.ok(px)
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		failure := err
+		if err == nil {
+			failure = errors.New(resp.Status)
+		}
+		t.pool.fail(px, failure)
+		tries--
+		if tries <= 0 || (req.Body != nil && req.Body != http.NoBody) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The full-text index over the cached files is an fts4 table on the name
+// and url columns, with files as its content. It is built by the first
+// search-local and then kept up to date by triggers as runs cache files.
+const fullTextSchema = `
+CREATE VIRTUAL TABLE files_fts USING fts4(content="files", name, url, tokenize=unicode61);
+CREATE VIRTUAL TABLE files_fts_terms USING fts4aux(files_fts);
+CREATE TRIGGER files_fts_bd BEFORE DELETE ON files BEGIN
+	DELETE FROM files_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER files_fts_bu BEFORE UPDATE ON files BEGIN
+	DELETE FROM files_fts WHERE docid = old.rowid;
+END;
+CREATE TRIGGER files_fts_au AFTER UPDATE ON files BEGIN
+	INSERT INTO files_fts (docid, name, url) VALUES (new.rowid, new.name, new.url);
+END;
+CREATE TRIGGER files_fts_ai AFTER INSERT ON files BEGIN
+	INSERT INTO files_fts (docid, name, url) VALUES (new.rowid, new.name, new.url);
+END;
+INSERT INTO files_fts (files_fts) VALUES ('rebuild');
+`
+
+// fuzzyExpansions caps how many index terms one fuzzy term stands for.
+const fuzzyExpansions = 50
+
+func runSearchLocal(args []string) {
+	fs := newFlagSet("search-local", `Full-text search the names and urls of the files earlier runs cached, without using the api. Every word must match a word of the name or url: backup matches exactly, back* by prefix, bakup~ with one typo and bakcup~2 with two.`)
+	filters := addFileFilterFlags(fs)
+	output := fs.String("o", "", "Output file path. If empty, print to stdout")
+	format := fs.String("format", "", "Output format: json|csv|ndjson (default json on stdout, csv with -o)")
+	limit := fs.Int("limit", 100, "Output at most N results, 0 means all")
+	reindex := fs.Bool("reindex", false, "Rebuild the full-text index from the cache first")
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := openQueryCache()
+	defer db.Close()
+	if err := ensureFullText(db, *reindex); err != nil {
+		log.Fatalf("full-text index: %v", err)
+	}
+	match, err := fullTextQuery(db, fs.Args())
+	if err != nil {
+		log.Fatalf("full-text index: %v", err)
+	}
+	opts := filesOptions{}
+	filters.apply(&opts)
+	w := newQueryWriter(*output, *format)
+	var n int
+	if match != "" {
+		where := []string{"rowid IN (SELECT docid FROM files_fts WHERE files_fts MATCH ?)"}
+		if n, err = queryFiles(db, where, []any{match}, opts, *limit, w); err != nil {
+			log.Fatalf("search cache: %v", err)
+		}
+	} else if w.format == "csv" {
+		// a fuzzy word close to nothing in the index matches no file
+		w.csvHeader(fileHeader(opts))
+	}
+	w.done(n)
+}
+
+// ensureFullText creates the index on first use, or rebuilds it.
+func ensureFullText(db *sql.DB, rebuild bool) error {
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'files_fts'`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 && !rebuild {
+		return nil
+	}
+	if n > 0 {
+		_, err := db.Exec(`INSERT INTO files_fts (files_fts) VALUES ('rebuild')`)
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fullTextSchema); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// fullTextQuery turns the search words into an fts MATCH expression. A
+// fuzzy word becomes the index terms within its edit distance, OR-ed; it
+// returns "" when one of them has none.
+func fullTextQuery(db *sql.DB, words []string) (string, error) {
+	var parts []string
+	for _, word := range strings.Fields(strings.Join(words, " ")) {
+		word = strings.ToLower(strings.ReplaceAll(word, `"`, ""))
+		base, dist, fuzzy := strings.Cut(word, "~")
+		if !fuzzy {
+			if word != "" {
+				// quoted, a word like backup.sql is a phrase of its tokens
+				parts = append(parts, `"`+word+`"`)
+			}
+			continue
+		}
+		edits := 1
+		if dist != "" {
+			var err error
+			if edits, err = strconv.Atoi(dist); err != nil || edits < 0 {
+				log.Fatalf("bad fuzzy word %s, use word~ or word~2\n", word)
+			}
+		}
+		terms, err := fuzzyTerms(db, base, edits)
+		if err != nil {
+			return "", err
+		}
+		if len(terms) == 0 {
+			return "", nil
+		}
+		parts = append(parts, "("+strings.Join(terms, " OR ")+")")
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// fuzzyTerms lists the most frequent index terms at most edits away from
+// word.
+func fuzzyTerms(db *sql.DB, word string, edits int) ([]string, error) {
+	word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	n := len([]rune(word))
+	rows, err := db.Query(`SELECT term, documents FROM files_fts_terms
+		WHERE col = '*' AND length(term) BETWEEN ? AND ?`, n-edits, n+edits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type match struct {
+		term string
+		docs int
+	}
+	var matches []match
+	for rows.Next() {
+		var m match
+		if err := rows.Scan(&m.term, &m.docs); err != nil {
+			return nil, err
+		}
+		if editDistance(word, m.term) <= edits {
+			matches = append(matches, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].docs > matches[j].docs })
+	var terms []string
+	for i, m := range matches {
+		if i == fuzzyExpansions {
+			break
+		}
+		terms = append(terms, `"`+m.term+`"`)
+	}
+	return terms, nil
+}
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sinkConfig is one entry of the sinks list of the config file.
+type sinkConfig struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Webhook     string   `yaml:"webhook"`
+	Token       string   `yaml:"token"`
+	Chat        string   `yaml:"chat"`
+	MinSeverity string   `yaml:"min-severity"`
+	Ext         []string `yaml:"ext"`
+	Bucket      string   `yaml:"bucket"`
+	MinFiles    int      `yaml:"min-files"`
+}
+
+// sinks holds the configured channels of this run; setupSinks fills it
+// with -notify-sinks.
+var sinks []*sink
+
+// notifySinks is set by -notify-sinks; the config file alone posts nothing.
+var notifySinks bool
+
+func addNotifySinksFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&notifySinks, "notify-sinks", false, "Post a summary of matching results to the sinks of the config file")
+}
+
+type sink struct {
+	sinkConfig
+	bucket *regexp.Regexp
+	exts   map[string]bool
+
+	files   int
+	buckets map[string]int
+	samples []string
+}
+
+const sinkSamples = 5
+
+func setupSinks() {
+	if !notifySinks {
+		return
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("read config: %v", err)
+	}
+	if len(cfg.Sinks) == 0 {
+		log.Fatalln("-notify-sinks: the config file has no sinks")
+	}
+	for i, c := range cfg.Sinks {
+		c.Type = strings.ToLower(c.Type)
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("%s sink %d", c.Type, i+1)
+		}
+		switch {
+		case c.Type == "slack" || c.Type == "discord":
+			if c.Webhook == "" {
+				log.Fatalf("config: %s needs a webhook\n", c.Name)
+			}
+		case c.Type == "telegram":
+			if c.Token == "" || c.Chat == "" {
+				log.Fatalf("config: %s needs a token and a chat\n", c.Name)
+			}
+		default:
+			log.Fatalf("config: %s has unknown type %q, use slack, discord or telegram\n", c.Name, c.Type)
+		}
+		if _, ok := severityRank[c.MinSeverity]; c.MinSeverity != "" && !ok {
+			log.Fatalf("config: %s has unknown min-severity %s\n", c.Name, c.MinSeverity)
+		}
+		s := &sink{sinkConfig: c, buckets: map[string]int{}}
+		if c.Bucket != "" {
+			if s.bucket, err = regexp.Compile(c.Bucket); err != nil {
+				log.Fatalf("config: %s: %v", c.Name, err)
+			}
+		}
+		if len(c.Ext) > 0 {
+			s.exts = map[string]bool{}
+			for _, e := range c.Ext {
+				s.exts[strings.ToLower(strings.TrimPrefix(e, "."))] = true
+			}
+		}
+		sinks = append(sinks, s)
+	}
+}
+
+// sinkFiles counts files against the filters of every sink.
+func sinkFiles(files []File) {
+	for _, s := range sinks {
+		for _, f := range files {
+			if s.MinSeverity != "" && severityRank[fileSeverity(f)] < severityRank[s.MinSeverity] {
+				continue
+			}
+			if s.exts != nil && !s.exts[fileExt(f.Name)] {
+				continue
+			}
+			if s.bucket != nil && !s.bucket.MatchString(f.Bucket) {
+				continue
+			}
+			s.files++
+			s.buckets[f.Bucket]++
+			if len(s.samples) < sinkSamples {
+				s.samples = append(s.samples, f.URL)
+			}
+		}
+	}
+}
+
+// flushSinks posts what each sink collected since the last flush, if it
+// reaches its min-files, and starts over. Failures are logged; they never
+// fail the run.
+func flushSinks(title string) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	for _, s := range sinks {
+		if s.files > 0 && s.files >= s.MinFiles {
+			if err := s.post(client, s.message(title)); err != nil {
+				log.Printf("%s: %v", s.Name, err)
+			}
+		}
+		s.files, s.buckets, s.samples = 0, map[string]int{}, nil
+	}
+}
+
+func (s *sink) message(title string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d matching files in %d buckets\n", title, s.files, len(s.buckets))
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s.buckets[names[i]] != s.buckets[names[j]] {
+			return s.buckets[names[i]] > s.buckets[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > 5 {
+		names = names[:5]
+	}
+	b.WriteString("top buckets:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s (%d)\n", name, s.buckets[name])
+	}
+	b.WriteString("sample urls:\n")
+	for _, u := range s.samples {
+		fmt.Fprintf(&b, "  %s\n", u)
+	}
+	return b.String()
+}
+
+func (s *sink) post(client *http.Client, text string) error {
+	var endpoint string
+	var payload any
+	switch s.Type {
+	case "slack":
+		endpoint, payload = s.Webhook, map[string]string{"text": text}
+	case "discord":
+		// discord rejects messages over 2000 characters, and invalid utf8
+		if r := []rune(text); len(r) > 2000 {
+			text = string(r[:1997]) + "..."
+		}
+		endpoint, payload = s.Webhook, map[string]string{"content": text}
+	case "telegram":
+		endpoint = "https://api.telegram.org/bot" + s.Token + "/sendMessage"
+		payload = map[string]any{"chat_id": s.Chat, "text": text, "disable_web_page_preview": true}
+	}
+	body, _ := json.Marshal(payload)
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error repeats the url, which holds the webhook or bot secret
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return fmt.Errorf("post failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post failed: %s", resp.Status)
+	}
+	return nil
+}
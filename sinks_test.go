@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func TestSinkFilters(t *testing.T) {
+	var mu sync.Mutex
+	posts := map[string][]map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		posts[r.URL.Path] = append(posts[r.URL.Path], payload)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	configFile = filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configFile, []byte(`
+sinks:
+  - name: critical
+    type: slack
+    webhook: `+srv.URL+`/critical
+    min-severity: critical
+  - name: prod archives
+    type: Discord
+    webhook: `+srv.URL+`/prod
+    ext: [.zip, gz]
+    bucket: ^prod-
+  - name: busy
+    type: slack
+    webhook: `+srv.URL+`/busy
+    min-files: 10
+`), 0644)
+	notifySinks = true
+	defer func() { configFile, notifySinks, sinks = "", false, nil }()
+	setupSinks()
+
+	file := func(bucket, name string) File {
+		return File{File: ghw.File{Bucket: bucket, Name: name, URL: "https://" + bucket + "/" + name}}
+	}
+	sinkFiles([]File{
+		file("prod-a", "db.sql"),
+		file("prod-a", "site.zip"),
+		file("prod-b", "logs.gz"),
+		file("dev-a", "site.zip"),
+		file("dev-a", "readme.txt"),
+	})
+	flushSinks("bucketsearch files")
+
+	critical := posts["/critical"]
+	if len(critical) != 1 || !strings.HasPrefix(critical[0]["text"], "bucketsearch files: 1 matching files in 1 buckets\n") ||
+		!strings.Contains(critical[0]["text"], "https://prod-a/db.sql") {
+		t.Errorf("critical sink got %q", critical)
+	}
+	prod := posts["/prod"]
+	if len(prod) != 1 || !strings.HasPrefix(prod[0]["content"], "bucketsearch files: 2 matching files in 2 buckets\n") ||
+		strings.Contains(prod[0]["content"], "dev-a") {
+		t.Errorf("prod sink got %q", prod)
+	}
+	// five files don't reach min-files
+	if len(posts["/busy"]) != 0 {
+		t.Errorf("busy sink got %q", posts["/busy"])
+	}
+	// a flush starts over
+	flushSinks("bucketsearch files")
+	if len(posts["/critical"]) != 1 {
+		t.Errorf("posted again with nothing new")
+	}
+}
+
+func TestSinkDiscordLimit(t *testing.T) {
+	var content string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		content = payload["content"]
+	}))
+	defer srv.Close()
+	s := &sink{sinkConfig: sinkConfig{Type: "discord", Webhook: srv.URL}}
+	if err := s.post(srv.Client(), strings.Repeat("桶", 2500)); err != nil {
+		t.Fatal(err)
+	}
+	if n := utf8.RuneCountInString(content); n != 2000 || !utf8.ValidString(content) || !strings.HasSuffix(content, "...") {
+		t.Errorf("sent %d runes, valid %v", n, utf8.ValidString(content))
+	}
+}
+
+func TestSinkPostHidesSecret(t *testing.T) {
+	s := &sink{sinkConfig: sinkConfig{Type: "slack", Webhook: "http://127.0.0.1:1/services/SECRET"}}
+	err := s.post(&http.Client{}, "hi")
+	if err == nil || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("got %v", err)
+	}
+}
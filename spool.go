@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// flushPending is set by -flush-pending.
+var flushPending bool
+
+func addFlushPendingFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&flushPending, "flush-pending", false, "Only send the batches an earlier run of -webhook or -es kept on disk after a failure, then exit")
+}
+
+// A network sink that fails after its retries keeps the batch, and every
+// later one of the run, in the pending directory next to the cache. The
+// next run with the same -webhook or -es url, or -flush-pending, sends
+// them before anything new.
+type pendingBatch struct {
+	// Index and Kind are the index of an es batch and whether it holds
+	// files or buckets, for its mapping
+	Index string `json:"index,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	Count int    `json:"count"`
+	Body  string `json:"body"`
+}
+
+func pendingDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bucketsearch", "pending"), nil
+}
+
+// pendingPrefix names the batch files of one sink and target.
+func pendingPrefix(sink, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return sink + "-" + hex.EncodeToString(sum[:6]) + "-"
+}
+
+// spool saves a batch for target that couldn't be sent, encrypted like
+// the other state with -encrypt-state.
+func spool(sink, target string, b pendingBatch) error {
+	dir, err := pendingDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0o700)
+	}
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s%d.json", pendingPrefix(sink, target), time.Now().UnixNano())
+	return saveState(filepath.Join(dir, name), b)
+}
+
+// sendPending sends the kept batches of a sink and target in the order
+// they were kept, removing each once sent. It stops at the first failure
+// and returns how many results went out.
+func sendPending(sink, target string, send func(pendingBatch) error) (int, error) {
+	dir, err := pendingDir()
+	if err != nil {
+		return 0, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, pendingPrefix(sink, target)+"*.json"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(paths)
+	sent := 0
+	for _, path := range paths {
+		data, err := readState(path)
+		if err != nil {
+			return sent, err
+		}
+		var b pendingBatch
+		if err := json.Unmarshal(data, &b); err != nil {
+			return sent, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := send(b); err != nil {
+			return sent, err
+		}
+		if err := os.Remove(path); err != nil {
+			return sent, err
+		}
+		sent += b.Count
+	}
+	return sent, nil
+}
+
+// sinkErr is the first failure of a network sink in this run; the paging
+// loops stop on it like on a spent budget.
+var (
+	sinkMu  sync.Mutex
+	sinkErr error
+)
+
+func failSink(err error) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if sinkErr == nil {
+		sinkErr = err
+	}
+}
+
+func sinkFailure() error {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	return sinkErr
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// encryptState is set by -encrypt-state.
+var encryptState bool
+
+func addEncryptFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&encryptState, "encrypt-state", false, "Encrypt the local state files (seen and watch state, annotations, unsent sink batches; not the -cache database, which it can't be used with) with a key kept in the system keychain (or set env BUCKETSEARCH_STATE_KEY, 64 hex characters)")
+}
+
+// stateMagic starts every encrypted state file; it is also the additional
+// data of the seal, so the header can't be swapped.
+var stateMagic = []byte("bucketsearch-encrypted-v1\n")
+
+const (
+	keychainService = "bucketsearch"
+	keychainAccount = "state-key"
+)
+
+// readState reads a local state file, decrypting it when it was written
+// with -encrypt-state. Plain files are read as they are and get encrypted
+// on their next save.
+func readState(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, stateMagic) {
+		return data, err
+	}
+	key, err := stateKey(false)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newStateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := data[len(stateMagic):]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%s: truncated", path)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], stateMagic)
+	if err != nil {
+		return nil, fmt.Errorf("%s: wrong key or damaged file", path)
+	}
+	return plain, nil
+}
+
+// saveState writes a state file like saveJSON, encrypted with
+// -encrypt-state or when the file it replaces was, so leaving off the flag
+// once doesn't quietly store the state in the clear.
+func saveState(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if !encryptState && !stateEncrypted(path) {
+		return replaceFile(path, data, 0644)
+	}
+	key, err := stateKey(true)
+	if err != nil {
+		return err
+	}
+	gcm, err := newStateCipher(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append(append([]byte(nil), stateMagic...), nonce...)
+	return replaceFile(path, gcm.Seal(out, nonce, data, stateMagic), 0600)
+}
+
+func stateEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(stateMagic))
+	_, err = io.ReadFull(f, head)
+	return err == nil && bytes.Equal(head, stateMagic)
+}
+
+func newStateCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	stateKeyMu    sync.Mutex
+	stateKeyBytes []byte
+)
+
+// stateKey returns the 256 bit state key: BUCKETSEARCH_STATE_KEY (hex) if
+// set, otherwise the one in the system keychain. With create a missing key
+// is generated and stored there. Only a key found is kept for the rest of
+// the run, so a read finding none doesn't stop a later save creating it.
+func stateKey(create bool) ([]byte, error) {
+	stateKeyMu.Lock()
+	defer stateKeyMu.Unlock()
+	if stateKeyBytes != nil {
+		return stateKeyBytes, nil
+	}
+	key, err := loadStateKey(create)
+	if err != nil {
+		return nil, err
+	}
+	stateKeyBytes = key
+	return key, nil
+}
+
+func loadStateKey(create bool) ([]byte, error) {
+	if v := os.Getenv("BUCKETSEARCH_STATE_KEY"); v != "" {
+		return decodeStateKey(v)
+	}
+	v, err := keychainGet()
+	if err == nil && v != "" {
+		return decodeStateKey(v)
+	}
+	if !create {
+		return nil, fmt.Errorf("no state key in the keychain (%v); set BUCKETSEARCH_STATE_KEY", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := keychainSet(hex.EncodeToString(key)); err != nil {
+		return nil, fmt.Errorf("store state key in the keychain: %v; set BUCKETSEARCH_STATE_KEY instead", err)
+	}
+	return key, nil
+}
+
+func decodeStateKey(v string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(v))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("state key must be 64 hex characters")
+	}
+	return key, nil
+}
+
+// keychainGet and keychainSet use the keychain tools of the platform:
+// security on macOS, secret-tool (libsecret) on Linux and the credential
+// vault through PowerShell on Windows.
+func keychainGet() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsVault+
+			`$c = $v.Retrieve($env:BS_SERVICE, $env:BS_ACCOUNT); $c.RetrievePassword(); $c.Password`)
+		cmd.Env = append(cmd.Environ(), "BS_SERVICE="+keychainService, "BS_ACCOUNT="+keychainAccount)
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	}
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func keychainSet(secret string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// the command goes in on stdin, security -i reads it there, so
+		// the secret never shows in the arguments ps lists; the -w
+		// prompt would read the terminal instead. secret is hex and
+		// needs no quoting.
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %s\n", keychainService, keychainAccount, secret))
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsVault+
+			`$v.Add((New-Object Windows.Security.Credentials.PasswordCredential($env:BS_SERVICE, $env:BS_ACCOUNT, $env:BS_SECRET)))`)
+		cmd.Env = append(cmd.Environ(), "BS_SERVICE="+keychainService, "BS_ACCOUNT="+keychainAccount, "BS_SECRET="+secret)
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label=bucketsearch state key", "service", keychainService, "account", keychainAccount)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	out, err := cmd.CombinedOutput()
+	if msg := bytes.TrimSpace(out); err != nil && len(msg) > 0 {
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+	if err != nil {
+		return err
+	}
+	// security -i exits 0 whatever its commands did
+	if v, err := keychainGet(); err != nil || v != secret {
+		return fmt.Errorf("the key didn't make it into the keychain: %s", bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+const windowsVault = `[void][Windows.Security.Credentials.PasswordVault,Windows.Security.Credentials,ContentType=WindowsRuntime];` +
+	`$v = New-Object Windows.Security.Credentials.PasswordVault;`
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func writeSuppressions(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "suppress.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSuppressions(t *testing.T) {
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	l, err := loadSuppressions(writeSuppressions(t, `
+- url: https://a.s3.amazonaws.com/readme.txt
+  reason: public docs
+- id: "42"
+  reason: test fixture
+- regex: ^https://(cdn|static)\.
+  reason: asset buckets
+  expires: `+tomorrow+`
+- regex: ^tmp-
+  reason: scratch buckets
+- url: https://b.s3.amazonaws.com/old.sql
+  reason: reviewed once
+  expires: 2000-01-01
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file File
+		want bool
+	}{
+		{File{File: ghw.File{URL: "https://a.s3.amazonaws.com/readme.txt"}}, true},
+		{File{File: ghw.File{URL: "https://a.s3.amazonaws.com/other.txt"}}, false},
+		{File{File: ghw.File{URL: "https://x/y", ID: 42}}, true},
+		{File{File: ghw.File{URL: "https://cdn.example.com/app.js"}}, true},
+		// expired entries no longer suppress
+		{File{File: ghw.File{URL: "https://b.s3.amazonaws.com/old.sql"}}, false},
+	}
+	for _, tt := range tests {
+		if got := l.file(tt.file); got != tt.want {
+			t.Errorf("file(%s, id %v) = %v, want %v", tt.file.URL, tt.file.ID, got, tt.want)
+		}
+	}
+	if !l.bucket("tmp-42") || l.bucket("backups") {
+		t.Error("bucket names are matched by the regex entries only")
+	}
+	if l.hits != 4 {
+		t.Errorf("counted %d hits, want 4", l.hits)
+	}
+	var nilList *suppressionList
+	if nilList.file(tests[0].file) || nilList.bucket("x") {
+		t.Error("a nil list suppressed something")
+	}
+}
+
+func TestSuppressionsInvalid(t *testing.T) {
+	for _, yaml := range []string{
+		"- reason: nothing to match\n",
+		"- url: https://a/1\n  id: \"1\"\n",
+		"- regex: \"(\"\n",
+		"- url: https://a/1\n  expires: next week\n",
+		"url: not a list\n",
+	} {
+		if _, err := loadSuppressions(writeSuppressions(t, yaml)); err == nil {
+			t.Errorf("loaded %q", strings.TrimSpace(yaml))
+		}
+	}
+}
+
+func TestKeepFileSuppressed(t *testing.T) {
+	l, err := loadSuppressions(writeSuppressions(t, "- regex: \\.js$\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suppressions = l
+	defer func() { suppressions = nil }()
+	opts := filesOptions{maxDepth: -1}
+	if keepFile(File{File: ghw.File{Name: "app.js", URL: "https://a/app.js"}}, opts) {
+		t.Error("kept a suppressed file")
+	}
+	if !keepFile(File{File: ghw.File{Name: "db.sql", URL: "https://a/db.sql"}}, opts) {
+		t.Error("dropped a file no entry matches")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// latinLetters transliterates the Cyrillic (Russian, Ukrainian,
+// Belarusian) and Greek letters to the Latin spellings bucket names use,
+// e.g. сбербанк -> sberbank.
+var latinLetters = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "e", 'є': "ye",
+	'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+
+	'α': "a", 'ά': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'έ': "e", 'ζ': "z", 'η': "i",
+	'ή': "i", 'θ': "th", 'ι': "i", 'ί': "i", 'ϊ': "i", 'ΐ': "i", 'κ': "k", 'λ': "l", 'μ': "m",
+	'ν': "n", 'ξ': "x", 'ο': "o", 'ό': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'ύ': "y", 'ϋ': "y", 'ΰ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o", 'ώ': "o",
+}
+
+// transliterate returns s with its Cyrillic and Greek letters in Latin
+// ones, and other characters as they are.
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if l, ok := latinLetters[unicode.ToLower(r)]; ok {
+			b.WriteString(l)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// transliterateKeywords returns every keyword followed by its Latin
+// transliteration when it has one.
+func transliterateKeywords(keywords []string) []string {
+	var out []string
+	for _, kw := range keywords {
+		out = append(out, kw)
+		if t := transliterate(kw); t != kw {
+			out = append(out, t)
+		}
+	}
+	return out
+}
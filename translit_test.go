@@ -0,0 +1,14 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransliterateKeywords(t *testing.T) {
+	got := transliterateKeywords([]string{"Сбербанк", "acme", "Газпром", "αθηνα backup"})
+	want := []string{"Сбербанк", "sberbank", "acme", "Газпром", "gazprom", "αθηνα backup", "athina backup"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
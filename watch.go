@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+)
+
+func runWatch(args []string) {
+	fs := newFlagSet("watch", "Re-run a search every -interval and print only files not seen before, as json lines; seen urls are kept in -state.")
+	common := addAPIFlags(fs)
+	filters := addFileFilterFlags(fs)
+	keywords := fs.String("keywords", "", "Search keywords")
+	interval := fs.Duration("interval", time.Hour, "Time between two polls")
+	state := fs.String("state", "watch.json", "Json state file of the urls seen so far")
+	output := fs.String("o", "", "Append new files to this file instead of printing them")
+	once := fs.Bool("once", false, "Poll once and exit, e.g. from cron")
+	reportFirst := fs.Bool("report-first", false, "Report everything on the first poll instead of only recording it")
+	addNotifyFlag(fs)
+	addNotifySinksFlag(fs)
+	addEncryptFlag(fs)
+	parseFlags(fs, args)
+
+	opts := filesOptions{keywords: *keywords}
+	filters.apply(&opts)
+	if opts.bucketList != nil {
+		log.Fatalln("watch takes a single -bucket, not -")
+	}
+	setupSinks()
+	api := common.client("watch")
+	defer telemetry.flush()
+	handleWatch(api, opts, watchOptions{
+		interval:    *interval,
+		state:       *state,
+		output:      *output,
+		once:        *once,
+		reportFirst: *reportFirst,
+	})
+}
+
+type watchOptions struct {
+	interval    time.Duration
+	state       string
+	output      string
+	once        bool
+	reportFirst bool
+}
+
+func handleWatch(api *ghw.Client, opts filesOptions, wopts watchOptions) {
+	state, err := loadWatchState(wopts.state)
+	if err != nil {
+		log.Fatalf("read state: %v", err)
+	}
+	seen := state.Seen
+	out := os.Stdout
+	if wopts.output != "" {
+		if out, err = os.OpenFile(wopts.output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			log.Fatalf("open output: %v", err)
+		}
+		defer out.Close()
+	}
+	enc := json.NewEncoder(out)
+	// the first complete poll is a baseline: what is there already isn't
+	// news, even when that is nothing
+	baseline := state.Baseline == "" && !wopts.reportFirst
+
+	for {
+		fresh, err := pollNew(api, opts, seen)
+		if errors.Is(err, ghw.ErrUnauthorized) || runCtx.Err() != nil {
+			requestFailed(err)
+		}
+		if err != nil && wopts.once {
+			log.Fatalf("poll failed: %v", err)
+		}
+		if err != nil {
+			// a failed poll is tried again on the next tick, the state
+			// is only saved after complete ones
+			log.Printf("poll failed: %v", err)
+		} else {
+			if !baseline {
+				var critical []string
+				for _, f := range fresh {
+					enc.Encode(f)
+					if fileSeverity(f) == "critical" {
+						critical = append(critical, path.Base(f.Name))
+					}
+				}
+				if n := len(critical); n > 0 {
+					if n > 3 {
+						critical = append(critical[:3], "...")
+					}
+					notify(fmt.Sprintf("bucketsearch watch: %d new critical files", n), strings.Join(critical, ", "))
+				}
+				sinkFiles(fresh)
+				flushSinks("bucketsearch watch")
+			}
+			if state.Baseline == "" {
+				state.Baseline = time.Now().UTC().Format(time.RFC3339)
+			}
+			if err := saveState(wopts.state, state); err != nil {
+				log.Fatalf("write state: %v", err)
+			}
+			if baseline {
+				log.Print(tr("baseline recorded, %d files", len(seen)))
+			} else {
+				log.Print(tr("%d new files", len(fresh)))
+			}
+			baseline = false
+		}
+		if wopts.once {
+			return
+		}
+		if !pauseRun(wopts.interval) {
+			return
+		}
+	}
+}
+
+// watchState is the -state file: the urls seen so far, with when, and
+// when the baseline poll completed.
+type watchState struct {
+	Baseline string            `json:"baseline,omitempty"`
+	Seen     map[string]string `json:"seen"`
+}
+
+// loadWatchState reads the -state file; a missing file is a fresh state.
+// Files of older versions hold only the seen map, and a baseline when it
+// isn't empty.
+func loadWatchState(path string) (watchState, error) {
+	state := watchState{Seen: map[string]string{}}
+	data, err := readState(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return state, err
+	}
+	if seen, ok := fields["seen"]; ok && bytes.HasPrefix(seen, []byte("{")) {
+		err = json.Unmarshal(data, &state)
+		if state.Seen == nil {
+			state.Seen = map[string]string{}
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state.Seen); err != nil {
+		return state, err
+	}
+	// the baseline saw the first urls
+	for _, at := range state.Seen {
+		if state.Baseline == "" || at < state.Baseline {
+			state.Baseline = at
+		}
+	}
+	return state, nil
+}
+
+// pollNew pages through the whole search and returns the files whose url
+// is not in seen yet, adding them to it. On error seen is left untouched.
+func pollNew(api *ghw.Client, opts filesOptions, seen map[string]string) ([]File, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	added := map[string]bool{}
+	perBucket := map[string]int{}
+	var fresh []File
+	it := api.Files(runCtx, filesQuery(opts)).Iter()
+	for it.Next() {
+		file := File{File: it.Value()}
+		file.Bucket = normalizeBucket(file.Bucket)
+		if !keepFile(file, opts) {
+			continue
+		}
+		if opts.perBucketMax > 0 {
+			if perBucket[file.Bucket] >= opts.perBucketMax {
+				continue
+			}
+			perBucket[file.Bucket]++
+		}
+		if _, ok := seen[file.URL]; ok || added[file.URL] {
+			continue
+		}
+		added[file.URL] = true
+		fresh = append(fresh, file)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	for _, f := range fresh {
+		seen[f.URL] = now
+	}
+	return fresh, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dogadmin/bucketsearch/ghw"
+	"github.com/dogadmin/bucketsearch/ghw/ghwtest"
+)
+
+func TestLoadWatchState(t *testing.T) {
+	dir := t.TempDir()
+	state, err := loadWatchState(filepath.Join(dir, "missing.json"))
+	if err != nil || state.Baseline != "" || len(state.Seen) != 0 || state.Seen == nil {
+		t.Errorf("missing file gave %+v, %v, want a fresh state", state, err)
+	}
+
+	// the flat map of older versions
+	old := filepath.Join(dir, "old.json")
+	os.WriteFile(old, []byte(`{"https://a/1":"2024-02-01T00:00:00Z","https://a/2":"2024-01-01T00:00:00Z"}`), 0644)
+	state, err = loadWatchState(old)
+	if err != nil || state.Baseline != "2024-01-01T00:00:00Z" || len(state.Seen) != 2 {
+		t.Errorf("old state gave %+v, %v", state, err)
+	}
+	os.WriteFile(old, []byte(`{}`), 0644)
+	if state, err = loadWatchState(old); err != nil || state.Baseline != "" {
+		t.Errorf("empty old state gave %+v, %v, want no baseline", state, err)
+	}
+
+	cur := filepath.Join(dir, "cur.json")
+	os.WriteFile(cur, []byte(`{"baseline":"2024-03-01T00:00:00Z","seen":{}}`), 0644)
+	if state, err = loadWatchState(cur); err != nil || state.Baseline != "2024-03-01T00:00:00Z" || state.Seen == nil {
+		t.Errorf("state gave %+v, %v", state, err)
+	}
+}
+
+// runTestWatch polls s once and returns the files it reported.
+func runTestWatch(t *testing.T, s *ghwtest.Server, state string, reportFirst bool) []File {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "new.ndjson")
+	handleWatch(s.Client(), filesOptions{maxDepth: -1}, watchOptions{state: state, output: out, once: true, reportFirst: reportFirst})
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var files []File
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var file File
+		if err := json.Unmarshal(sc.Bytes(), &file); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func TestWatchBaseline(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	state := filepath.Join(t.TempDir(), "watch.json")
+
+	if files := runTestWatch(t, s, state, false); len(files) != 0 {
+		t.Errorf("first poll reported %d files, want a silent baseline", len(files))
+	}
+	st, err := loadWatchState(state)
+	if err != nil || st.Baseline == "" || len(st.Seen) != len(s.Files) {
+		t.Fatalf("state after the baseline %+v, %v", st, err)
+	}
+
+	s.Files = append(s.Files, ghw.File{ID: 999, Bucket: "new", Name: "new.sql", URL: "https://new.s3.amazonaws.com/new.sql"})
+	files := runTestWatch(t, s, state, false)
+	if len(files) != 1 || files[0].URL != "https://new.s3.amazonaws.com/new.sql" {
+		t.Errorf("second poll reported %d files, want the new one", len(files))
+	}
+	if files := runTestWatch(t, s, state, false); len(files) != 0 {
+		t.Errorf("third poll reported %d files again", len(files))
+	}
+}
+
+func TestWatchEmptyBaseline(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	all := s.Files
+	s.Files = nil
+	state := filepath.Join(t.TempDir(), "watch.json")
+
+	// a search finding nothing yet is still a baseline, so the first
+	// files it finds later are news
+	runTestWatch(t, s, state, false)
+	s.Files = all
+	if files := runTestWatch(t, s, state, false); len(files) != len(all) {
+		t.Errorf("reported %d files after an empty baseline, want %d", len(files), len(all))
+	}
+}
+
+func TestWatchReportFirst(t *testing.T) {
+	s := ghwtest.NewServer()
+	defer s.Close()
+	state := filepath.Join(t.TempDir(), "watch.json")
+	if files := runTestWatch(t, s, state, true); len(files) != len(s.Files) {
+		t.Errorf("first poll with -report-first reported %d files, want %d", len(files), len(s.Files))
+	}
+}
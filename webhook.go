@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type webhookFlags struct {
+	url     *string
+	headers http.Header
+	batch   *int
+	retries *int
+}
+
+func addWebhookFlags(fs *flag.FlagSet) *webhookFlags {
+	f := &webhookFlags{headers: http.Header{}}
+	f.url = fs.String("webhook", "", "Also POST every result as json to this url, e.g. an ingestion service")
+	fs.Func("webhook-header", "Header sent with -webhook posts, \"Name: value\"; repeatable", func(v string) error {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return errors.New(`want "Name: value"`)
+		}
+		f.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		return nil
+	})
+	f.batch = fs.Int("webhook-batch", 100, "Results per -webhook post, sent as a json array; 1 posts each result as a single object")
+	f.retries = fs.Int("webhook-retries", 3, "Retry a failed -webhook post this many times, backing off exponentially")
+	return f
+}
+
+// apply sets up the webhook of this run, if any.
+func (f *webhookFlags) apply() {
+	if *f.url == "" {
+		return
+	}
+	if u, err := url.Parse(*f.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		log.Fatalf("-webhook needs an http(s) url\n")
+	}
+	batch := *f.batch
+	if batch < 1 {
+		batch = 1
+	}
+	webhook = &webhookSink{
+		url:     *f.url,
+		headers: f.headers,
+		batch:   batch,
+		retries: *f.retries,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	// what an earlier run couldn't send goes first
+	sent, err := sendPending("webhook", webhook.url, func(b pendingBatch) error {
+		return webhook.post([]byte(b.Body))
+	})
+	if err != nil {
+		log.Fatalf("webhook: send pending batches: %v (%d results sent)", err, sent)
+	}
+	if sent > 0 || flushPending {
+		log.Printf("webhook: sent %d pending results", sent)
+	}
+}
+
+// webhook is the -webhook of this run; nil without one.
+var webhook *webhookSink
+
+type webhookSink struct {
+	url     string
+	headers http.Header
+	batch   int
+	retries int
+	client  *http.Client
+
+	pending []any
+	sent    int
+	// err is the post that failed; later batches go to disk directly
+	err error
+}
+
+// add queues results and posts every full batch.
+func (s *webhookSink) add(results ...any) error {
+	if s == nil {
+		return nil
+	}
+	for _, r := range results {
+		s.pending = append(s.pending, r)
+		if len(s.pending) >= s.batch {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush posts whatever is queued. A post that still fails after the
+// retries keeps the batch on disk for the next run and stops the paging,
+// so the endpoint is never silently missing results.
+func (s *webhookSink) flush() error {
+	if s == nil || len(s.pending) == 0 {
+		return nil
+	}
+	var body []byte
+	if s.batch == 1 {
+		body, _ = json.Marshal(s.pending[0])
+	} else {
+		body, _ = json.Marshal(s.pending)
+	}
+	n := len(s.pending)
+	s.pending = s.pending[:0]
+	if s.err == nil {
+		if err := s.post(body); err != nil {
+			s.err = fmt.Errorf("webhook: %v", err)
+			log.Printf("%v (%d results posted before); keeping the rest on disk for -flush-pending", s.err, s.sent)
+			failSink(s.err)
+		}
+	}
+	if s.err != nil {
+		if err := spool("webhook", s.url, pendingBatch{Count: n, Body: string(body)}); err != nil {
+			log.Fatalf("webhook: keep batch: %v", err)
+		}
+		return s.err
+	}
+	s.sent += n
+	return nil
+}
+
+// sinkRetryMaxWait caps how long a sink's Retry-After is honored.
+const sinkRetryMaxWait = 5 * time.Minute
+
+func (s *webhookSink) post(body []byte) error {
+	wait := time.Second
+	for attempt := 0; ; attempt++ {
+		err := s.postOnce(body)
+		if err == nil {
+			return nil
+		}
+		var status *webhookStatus
+		retryable := !errors.As(err, &status) || status.code == http.StatusTooManyRequests || status.code >= 500
+		if !retryable || attempt >= s.retries {
+			return err
+		}
+		next := wait
+		if status != nil && status.retryAfter > 0 {
+			next = status.retryAfter
+			if next > sinkRetryMaxWait {
+				next = sinkRetryMaxWait
+			}
+		}
+		time.Sleep(next)
+		wait *= 2
+	}
+}
+
+type webhookStatus struct {
+	code int
+	body string
+	// retryAfter is the Retry-After the endpoint sent, if any
+	retryAfter time.Duration
+}
+
+func (e *webhookStatus) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("%d %s", e.code, http.StatusText(e.code))
+	}
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.body)
+}
+
+func (s *webhookSink) postOnce(body []byte) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bucketsearch")
+	for name, values := range s.headers {
+		req.Header[name] = values
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &webhookStatus{resp.StatusCode, strings.TrimSpace(string(msg)), retryAfterHeader(resp.Header.Get("Retry-After"))}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// retryAfterHeader parses a Retry-After value in seconds or as an http
+// date.
+func retryAfterHeader(v string) time.Duration {
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the bodies posted to it, answering each with the
+// next of statuses and 200 once they are used up.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "nope", status)
+			return
+		}
+		s.bodies = append(s.bodies, string(body))
+		s.headers = append(s.headers, r.Header.Clone())
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestWebhook(t *testing.T, url string, batch int) *webhookSink {
+	// keep spooled batches and the sink failure to this test
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Cleanup(func() { sinkErr = nil })
+	return &webhookSink{url: url, headers: http.Header{"X-Token": {"secret"}}, batch: batch, retries: 1,
+		client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func TestWebhookBatches(t *testing.T) {
+	srv := newWebhookServer(t)
+	s := newTestWebhook(t, srv.URL, 2)
+	if err := s.add(map[string]int{"n": 1}, map[string]int{"n": 2}, map[string]int{"n": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`[{"n":1},{"n":2}]`, `[{"n":3}]`}
+	if len(srv.bodies) != 2 || srv.bodies[0] != want[0] || srv.bodies[1] != want[1] {
+		t.Errorf("posted %q, want %q", srv.bodies, want)
+	}
+	if h := srv.headers[0]; h.Get("X-Token") != "secret" || h.Get("Content-Type") != "application/json" {
+		t.Errorf("posted with headers %v", h)
+	}
+	if s.sent != 3 {
+		t.Errorf("counted %d sent, want 3", s.sent)
+	}
+}
+
+func TestWebhookSingleObjects(t *testing.T) {
+	srv := newWebhookServer(t)
+	s := newTestWebhook(t, srv.URL, 1)
+	if err := s.add(map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.bodies) != 1 || srv.bodies[0] != `{"n":1}` {
+		t.Errorf("posted %q, want one object", srv.bodies)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv := newWebhookServer(t, http.StatusServiceUnavailable)
+	s := newTestWebhook(t, srv.URL, 1)
+	if err := s.add("a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.bodies) != 1 || sinkFailure() != nil {
+		t.Errorf("posted %q with sink failure %v, want the retry through", srv.bodies, sinkFailure())
+	}
+}
+
+func TestWebhookKeepsFailedBatches(t *testing.T) {
+	srv := newWebhookServer(t, http.StatusBadRequest)
+	s := newTestWebhook(t, srv.URL, 1)
+	if err := s.add("a"); err == nil {
+		t.Fatal("a rejected post went unreported")
+	}
+	// once failed, later batches go to disk without a post
+	if err := s.add("b"); err == nil {
+		t.Fatal("a batch after the failure went unreported")
+	}
+	if sinkFailure() == nil {
+		t.Error("the failure doesn't stop the paging")
+	}
+	if len(srv.bodies) != 0 {
+		t.Errorf("posted %q after the failure", srv.bodies)
+	}
+
+	// the next run sends them first, in order
+	var sent []string
+	n, err := sendPending("webhook", srv.URL, func(b pendingBatch) error {
+		sent = append(sent, b.Body)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(sent) != 2 || sent[0] != `"a"` || sent[1] != `"b"` {
+		t.Errorf("sent %d pending results %q, want a then b", n, sent)
+	}
+	if n, _ := sendPending("webhook", srv.URL, func(pendingBatch) error { return nil }); n != 0 {
+		t.Errorf("sent %d results again", n)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	if d := retryAfterHeader("7"); d != 7*time.Second {
+		t.Errorf("got %s for 7", d)
+	}
+	if d := retryAfterHeader(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); d < 58*time.Second || d > time.Minute {
+		t.Errorf("got %s for a date a minute ahead", d)
+	}
+	if d := retryAfterHeader("soon"); d != 0 {
+		t.Errorf("got %s for soon", d)
+	}
+}
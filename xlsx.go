@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// xlsxWriter writes a single sheet workbook for -format xlsx: a bold,
+// frozen header row and columns sized to their content. Rows are spooled
+// to a temporary file because the column widths go before the data.
+type xlsxWriter struct {
+	path   string
+	tmp    *os.File
+	w      *bufio.Writer
+	widths []int
+	rows   int
+}
+
+func createXLSX(path string, header []string) *xlsxWriter {
+	tmp, err := os.CreateTemp("", "bucketsearch-*.xml")
+	if err != nil {
+		log.Fatalf("create file: %v", err)
+	}
+	x := &xlsxWriter{path: path, tmp: tmp, w: bufio.NewWriter(tmp)}
+	x.row(header, true)
+	return x
+}
+
+func (x *xlsxWriter) write(row []string) {
+	x.row(row, false)
+}
+
+func (x *xlsxWriter) row(row []string, header bool) {
+	x.rows++
+	fmt.Fprintf(x.w, `<row r="%d">`, x.rows)
+	for i, cell := range row {
+		if i >= len(x.widths) {
+			x.widths = append(x.widths, 0)
+		}
+		if w := cellWidth(cell); w > x.widths[i] {
+			x.widths[i] = w
+		}
+		ref := xlsxColumn(i) + strconv.Itoa(x.rows)
+		if header {
+			fmt.Fprintf(x.w, `<c r="%s" s="1" t="inlineStr"><is><t>`, ref)
+		} else if isXLSXNumber(cell) {
+			fmt.Fprintf(x.w, `<c r="%s"><v>%s</v></c>`, ref, cell)
+			continue
+		} else {
+			fmt.Fprintf(x.w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		}
+		xml.EscapeText(x.w, []byte(cell))
+		io.WriteString(x.w, `</t></is></c>`)
+	}
+	io.WriteString(x.w, `</row>`)
+}
+
+// isXLSXNumber tells whether cell can be stored as a number without Excel
+// mangling it: no leading zeros and few enough digits to stay exact.
+func isXLSXNumber(cell string) bool {
+	if cell == "" || len(cell) > 15 || len(cell) > 1 && cell[0] == '0' && cell[1] != '.' {
+		return false
+	}
+	_, err := strconv.ParseFloat(cell, 64)
+	return err == nil && !strings.ContainsAny(cell, "eEnN")
+}
+
+// cellWidth approximates the displayed width, counting wide runes twice.
+func cellWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		if r >= 0x1100 && utf8.RuneLen(r) >= 3 {
+			w += 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+// xlsxColumn turns a zero based index into a column name: A, B, ..., AA.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// close assembles the workbook at path and removes the spooled rows.
+func (x *xlsxWriter) close() error {
+	defer os.Remove(x.tmp.Name())
+	defer x.tmp.Close()
+	if err := x.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := x.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	f, err := os.Create(x.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	z := zip.NewWriter(f)
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	} {
+		w, err := z.Create(part.name)
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, part.body)
+	}
+
+	w, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	io.WriteString(w, `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	if len(x.widths) > 0 {
+		io.WriteString(w, `<cols>`)
+		for i, width := range x.widths {
+			if width > 80 {
+				width = 80
+			}
+			fmt.Fprintf(w, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width+2)
+		}
+		io.WriteString(w, `</cols>`)
+	}
+	io.WriteString(w, `<sheetData>`)
+	if _, err := io.Copy(w, x.tmp); err != nil {
+		return err
+	}
+	io.WriteString(w, `</sheetData></worksheet>`)
+	if err := z.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// humanSize formats a byte count for people, e.g. 1.5 MB.
+func humanSize(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < 1024 {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	f := float64(n)
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	i := -1
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%s%.1f %s", sign, f, units[i])
+}
+
+const xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`</Types>`
+
+const xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="results" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// styles.xml: xf 0 is the default, xf 1 the bold header
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`</styleSheet>`